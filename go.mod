@@ -1,22 +1,46 @@
 module github.com/killingspark/restic-cronned
 
+go 1.27.1
+
 replace github.com/Sirupsen/logrus => github.com/sirupsen/logrus v1.1.0
 
 require (
-	github.com/BurntSushi/toml v0.3.1 // indirect
+	github.com/BurntSushi/toml v0.3.1
 	github.com/KillingSpark/restic-cronned v0.0.5
 	github.com/Sirupsen/logrus v1.2.0
+	github.com/robfig/cron v0.0.0-20180505203441-b41be1df6967
+	github.com/rshmelev/lumberjack v0.0.0-20150318213330-768d4f039f25
+	github.com/spf13/viper v1.2.1
+	github.com/zalando/go-keyring v0.0.0-20180221093347-6d81c293b3fb
+	gopkg.in/alecthomas/kingpin.v2 v2.2.6
+	gopkg.in/yaml.v2 v2.2.1
+)
+
+require (
 	github.com/alecthomas/template v0.0.0-20160405071501-a0175ee3bccc // indirect
 	github.com/alecthomas/units v0.0.0-20151022065526-2efee857e7cf // indirect
 	github.com/danieljoos/wincred v1.0.1 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
 	github.com/godbus/dbus v4.1.0+incompatible // indirect
+	github.com/hashicorp/hcl v1.0.0 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/magiconair/properties v1.8.0 // indirect
+	github.com/mitchellh/mapstructure v1.0.0 // indirect
 	github.com/natefinch/lumberjack v2.0.0+incompatible // indirect
-	github.com/robfig/cron v0.0.0-20180505203441-b41be1df6967
-	github.com/rshmelev/lumberjack v0.0.0-20150318213330-768d4f039f25
+	github.com/pelletier/go-toml v1.2.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.2.0 // indirect
-	github.com/spf13/viper v1.2.1
-	github.com/zalando/go-keyring v0.0.0-20180221093347-6d81c293b3fb
-	gopkg.in/alecthomas/kingpin.v2 v2.2.6
+	github.com/spf13/afero v1.1.2 // indirect
+	github.com/spf13/cast v1.2.0 // indirect
+	github.com/spf13/jwalterweatherman v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.2 // indirect
+	github.com/stretchr/objx v0.1.1 // indirect
+	github.com/stretchr/testify v1.2.2 // indirect
+	golang.org/x/crypto v0.0.0-20180904163835-0709b304e793 // indirect
+	golang.org/x/sys v0.0.0-20180906133057-8cf3aee42992 // indirect
+	golang.org/x/text v0.3.0 // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
 	gopkg.in/yaml.v1 v1.0.0-20140924161607-9f9df34309c0 // indirect
 )
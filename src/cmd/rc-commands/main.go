@@ -1,42 +1,278 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+
+	"github.com/killingspark/restic-cronned/src/output"
 )
 
 const (
-	cmdStopAll string = "stopall"
-	cmdStop    string = "stop"
-	cmdRestart string = "restart"
-	cmdReload  string = "reload"
+	cmdStopAll    string = "stopall"
+	cmdStop       string = "stop"
+	cmdRestart    string = "restart"
+	cmdReload     string = "reload"
+	cmdTrigger    string = "trigger"
+	cmdPause      string = "pause"
+	cmdResume     string = "resume"
+	cmdSkip       string = "skipnext"
+	cmdCancel     string = "cancel"
+	cmdDefinition string = "definition"
+	cmdAdHoc      string = "adhoc"
+	cmdLogs       string = "logs"
+	//these take no job name or something else
+	cmdQueue       string = "queue"
+	cmdGraph       string = "graph"
+	cmdMaintenance string = "maintenance"
+	cmdOperation   string = "operation"
+	cmdEvents      string = "events"
 )
 
+//route is the request of the api a command is sent as
+type route struct {
+	method string
+	path   string
+}
+
+//jobRoutes are the commands that take a job name, {name} is replaced by it
+var jobRoutes = map[string]route{
+	cmdStop:       {"POST", "/jobs/{name}/stop"},
+	cmdRestart:    {"POST", "/jobs/{name}/restart"},
+	cmdReload:     {"POST", "/jobs/{name}/reload"},
+	cmdTrigger:    {"POST", "/jobs/{name}/trigger"},
+	cmdPause:      {"POST", "/jobs/{name}/pause"},
+	cmdResume:     {"POST", "/jobs/{name}/resume"},
+	cmdSkip:       {"POST", "/jobs/{name}/skip-next"},
+	cmdCancel:     {"POST", "/jobs/{name}/cancel"},
+	cmdDefinition: {"GET", "/jobs/{name}/definition"},
+}
+
+//plainRoutes are the commands that take no argument
+var plainRoutes = map[string]route{
+	cmdStopAll:     {"POST", "/stop-all"},
+	cmdQueue:       {"GET", "/jobs"},
+	cmdGraph:       {"GET", "/graph"},
+	cmdMaintenance: {"GET", "/maintenance"},
+	cmdEvents:      {"GET", "/events"},
+}
+
 func printUsage() {
-	println("rccommands ip:port command name")
+	println("rccommands [address] command name")
+	println("rccommands [address] stopall|queue|graph")
+	println("rccommands [address] maintenance [on|off]")
+	println("rccommands [address] operation id")
+	println("rccommands [address] events [name]")
+	println("rccommands [address] logs [-f] name [run id]")
+	println("rccommands [address] adhoc [--replace] [--nowait] name [restic arguments...]")
+	println("address is ip:port, http(s)://host:port or unix:/path/to/socket.")
+	println("Without it the unix socket of the daemon is used ($RC_SOCKET or " + defaultSocket() + "), if it does not exist " + defaultAddress)
+}
+
+//defaultAddress is where the daemon listens without a ServerPort in its config
+const defaultAddress = "http://localhost:8080"
+
+func defaultSocket() string {
+	if socket := os.Getenv("RC_SOCKET"); socket != "" {
+		return socket
+	}
+	return output.DefaultSocketPath()
+}
+
+//isAddress tells addresses from commands, which never contain : or /
+func isAddress(arg string) bool {
+	return strings.ContainsAny(arg, ":/")
+}
+
+//adHocRequest builds the path and the body of an ad hoc run from the arguments following the command.
+//The run is waited for and its output is streamed, unless --nowait is given
+func adHocRequest(args []string) (string, output.AdHocRequestDTO) {
+	var body output.AdHocRequestDTO
+	wait := true
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		switch args[0] {
+		case "--replace":
+			body.Replace = true
+		case "--nowait":
+			wait = false
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return "", body
+	}
+	body.Arguments = args[1:]
+	p := "/api/v1/jobs/" + url.PathEscape(args[0]) + "/adhoc"
+	if wait {
+		p += "?wait=true"
+	}
+	return p, body
+}
+
+//newRequest builds the request of the api for the command and its arguments, nil if they do not fit the command
+func newRequest(base string, args []string) (*http.Request, error) {
+	var method, p string
+	var body interface{}
+	jobRoute, isJobCommand := jobRoutes[args[0]]
+	switch {
+	case args[0] == cmdLogs:
+		method, p = "GET", logsPath(args[1:])
+	case args[0] == cmdAdHoc:
+		method = "POST"
+		p, body = adHocRequest(args[1:])
+	case args[0] == cmdMaintenance && len(args) > 1:
+		if args[1] != "on" && args[1] != "off" {
+			return nil, nil
+		}
+		method, p, body = "PUT", "/api/v1/maintenance", output.MaintenanceDTO{Enabled: args[1] == "on"}
+	case args[0] == cmdOperation && len(args) > 1:
+		method, p = "GET", "/api/v1/operations/"+url.PathEscape(args[1])
+	case args[0] == cmdEvents && len(args) > 1:
+		method, p = "GET", "/api/v1/events?"+url.Values{"job": {args[1]}}.Encode()
+	case isJobCommand && len(args) > 1:
+		method, p = jobRoute.method, "/api/v1"+strings.Replace(jobRoute.path, "{name}", url.PathEscape(args[1]), 1)
+	case plainRoutes[args[0]].path != "":
+		method, p = plainRoutes[args[0]].method, "/api/v1"+plainRoutes[args[0]].path
+	}
+	if p == "" {
+		return nil, nil
+	}
+	if body == nil {
+		return http.NewRequest(method, base+p, nil)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, base+p, bytes.NewReader(data))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, err
+}
+
+//logsPath builds the path of the log of a run from the arguments following the command.
+//Without a run id the log of the current or last run is shown, -f follows it until the run ends
+func logsPath(args []string) string {
+	follow := false
+	if len(args) > 0 && (args[0] == "-f" || args[0] == "--follow") {
+		follow = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	id := "latest"
+	if len(args) > 1 {
+		id = url.PathEscape(args[1])
+	}
+	p := "/api/v1/jobs/" + url.PathEscape(args[0]) + "/runs/" + id + "/log"
+	if follow {
+		p += "?follow=1"
+	}
+	return p
+}
+
+//baseURL accepts ip:port as well as http:// and https:// urls
+func baseURL(address string) string {
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		return strings.TrimSuffix(address, "/")
+	}
+	return "http://" + address
+}
+
+//setAuth adds the credentials from the environment: RC_TOKEN for a bearer token or RC_USER and RC_PASSWORD for basic auth
+func setAuth(req *http.Request) {
+	if token := os.Getenv("RC_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if user := os.Getenv("RC_USER"); user != "" {
+		req.SetBasicAuth(user, os.Getenv("RC_PASSWORD"))
+	}
+}
+
+//newClient sets up tls from the environment: RC_CA_CERT to verify the server and RC_CLIENT_CERT and RC_CLIENT_KEY
+//if the server wants a client certificate
+func newClient(socket string) (*http.Client, error) {
+	if socket != "" {
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		return &http.Client{Transport: &http.Transport{DialContext: dial}}, nil
+	}
+	config := &tls.Config{}
+	if caFile := os.Getenv("RC_CA_CERT"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		config.RootCAs.AppendCertsFromPEM(pem)
+	}
+	if certFile := os.Getenv("RC_CLIENT_CERT"); certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, os.Getenv("RC_CLIENT_KEY"))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}, nil
 }
 
 func main() {
-	if len(os.Args) < 3 {
+	//args is the command followed by its arguments
+	args := os.Args[1:]
+	var base, socket string
+	switch {
+	case len(args) > 0 && strings.HasPrefix(args[0], "unix:"):
+		socket = strings.TrimPrefix(args[0], "unix:")
+		args = args[1:]
+	case len(args) > 0 && isAddress(args[0]):
+		base = baseURL(args[0])
+		args = args[1:]
+	default:
+		//the socket is off by default, the daemon then only listens on its default ServerPort
+		socket = defaultSocket()
+		if _, err := os.Stat(socket); socket == "" || err != nil {
+			socket = ""
+			base = defaultAddress
+		}
+	}
+	if socket != "" {
+		//the host is not used to connect, the client dials the socket
+		base = "http://unix"
+	}
+	if len(args) < 1 {
 		printUsage()
 		return
 	}
+
 	var resp *http.Response
-	var req *http.Request
-	var err error
-	if len(os.Args) > 3 {
-		req, err = http.NewRequest("GET", "http://"+os.Args[1]+"/"+os.Args[2]+"?name="+os.Args[3], nil)
-	} else {
-		req, err = http.NewRequest("GET", "http://"+os.Args[1]+"/"+os.Args[2], nil)
+	req, err := newRequest(base, args)
+	if err != nil {
+		println(err.Error())
+		return
 	}
+	if req == nil {
+		printUsage()
+		return
+	}
+	setAuth(req)
 
+	c, err := newClient(socket)
 	if err != nil {
 		println(err.Error())
 		return
 	}
-
-	c := http.Client{}
 	resp, err = c.Do(req)
 
 	if err != nil {
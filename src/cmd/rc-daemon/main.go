@@ -1,12 +1,16 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/killingspark/restic-cronned/src/jobs"
+	"github.com/killingspark/restic-cronned/src/logging"
+	"github.com/killingspark/restic-cronned/src/notify"
 	"github.com/killingspark/restic-cronned/src/output"
+	"github.com/killingspark/restic-cronned/src/systemd"
 	"github.com/rshmelev/lumberjack"
 	"github.com/spf13/viper"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -16,11 +20,42 @@ var (
 	port       = kingpin.Flag("port", "Which port the server should listen on (if any)").Short('p').String()
 	jobpath    = kingpin.Flag("jobpath", "Which directory contains the job descriptions").Short('j').String()
 	configpath = kingpin.Flag("configpath", "Which directory contains the config file").Short('c').String()
+	schema     = kingpin.Flag("schema", "Print the JSON schema for job files and exit").Bool()
 )
 
 func setupLogging() {
-	log.SetFormatter(&log.TextFormatter{})
+	format := viper.GetString("LogFormat")
+	formatter, err := logging.NewFormatter(format)
+	if err != nil {
+		println(err.Error() + ", logging as logfmt")
+		format = logging.FormatLogfmt
+		formatter, _ = logging.NewFormatter(format)
+	}
+	log.SetFormatter(formatter)
+	level, err := log.ParseLevel(viper.GetString("LogLevel"))
+	if err != nil {
+		println(err.Error() + ", logging with level info")
+		level = log.InfoLevel
+	}
+	logging.SetLevel(level)
+
 	logpath := os.ExpandEnv(viper.GetString("LogDir"))
+	if viper.GetBool("JobLogFiles") {
+		//next to the directory with the output of the runs
+		files, _ := logging.NewJobFiles(logpath, format, viper.GetInt("LogMaxSize"), viper.GetInt("LogMaxAge"))
+		log.AddHook(files)
+	}
+
+	output := viper.GetString("LogOutput")
+	if output == "journal" || (output == "auto" && systemd.JournalAvailable()) {
+		hook, err := systemd.NewJournalHook("restic-cronned")
+		if err == nil {
+			log.AddHook(logging.Filter(hook))
+			log.SetOutput(ioutil.Discard)
+			return
+		}
+		println("journal not available, logging into LogDir: " + err.Error())
+	}
 	os.MkdirAll(logpath, 0700) //readwrite for user only
 	log.SetOutput(&lumberjack.Logger{
 		Filename: path.Join(logpath, "restic-cronned.log"),
@@ -35,21 +70,84 @@ func startDaemon() {
 		println(err.Error())
 		return
 	}
+	startNotifier(queue)
 	queue.StartQueue()
+	startSystemd(queue)
 
 	if len(*port) > 2 {
-		go output.StartServer(queue, *port)
+		go startServer(queue)
 	} else {
 		println("no valid port specified -> no status server started")
 	}
+	if viper.GetString("Socket.Path") != "" {
+		go startSocketServer(queue)
+	}
+	if viper.GetString("MetricsPort") != "" {
+		go startMetricsServer(queue)
+	}
 
 	queue.WaitForAllJobs()
+	systemd.Notify(systemd.Stopping)
 	log.Info("All Jobs stopped")
 }
 
-func loadConfig() {
-	kingpin.Parse()
+//startServer starts the status server with the authentication and tls settings from the config
+func startServer(queue *jobs.JobQueue) {
+	config := output.ServerConfig{Address: *port}
+	err := viper.UnmarshalKey("Auth", &config.Credentials)
+	if err == nil {
+		err = viper.UnmarshalKey("TLS", &config.TLS)
+	}
+	if err == nil {
+		err = output.StartServer(queue, config)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"Error": err.Error()}).Error("Server could not be started")
+		println("server could not be started: " + err.Error())
+	}
+}
 
+//startSocketServer serves the api on the unix socket from the config
+func startSocketServer(queue *jobs.JobQueue) {
+	config := output.SocketConfig{
+		Path:    os.ExpandEnv(viper.GetString("Socket.Path")),
+		Group:   viper.GetString("Socket.Group"),
+		Admins:  viper.GetStringSlice("Socket.Admins"),
+		Readers: viper.GetStringSlice("Socket.Readers"),
+	}
+	err := output.StartSocketServer(queue, config)
+	if err != nil {
+		log.WithFields(log.Fields{"Path": config.Path, "Error": err.Error()}).Error("Socket server could not be started")
+		println("socket server could not be started: " + err.Error())
+	}
+}
+
+//startNotifier sends the notifications configured in the config for the events of the queue
+func startNotifier(queue *jobs.JobQueue) {
+	var config notify.Config
+	err := viper.UnmarshalKey("Notifications", &config)
+	var notifier *notify.Notifier
+	if err == nil {
+		notifier, err = notify.New(config)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"Error": err.Error()}).Error("Notifications could not be set up")
+		println("notifications could not be set up: " + err.Error())
+		return
+	}
+	go notifier.Run(queue)
+}
+
+//startMetricsServer serves the metrics on their own port, so prometheus does not need credentials
+func startMetricsServer(queue *jobs.JobQueue) {
+	address := viper.GetString("MetricsPort")
+	if err := output.StartMetricsServer(queue, address); err != nil {
+		log.WithFields(log.Fields{"Address": address, "Error": err.Error()}).Error("Metrics server could not be started")
+		println("metrics server could not be started: " + err.Error())
+	}
+}
+
+func loadConfig() {
 	if *configpath != "" {
 		viper.AddConfigPath(*configpath) // call multiple times to add many search paths
 		println("ConfigPath: " + *configpath)
@@ -60,10 +158,20 @@ func loadConfig() {
 	viper.AddConfigPath("$HOME/.config/restic-cronned") // call multiple times to add many search paths
 
 	viper.SetDefault("JobPath", os.ExpandEnv("$HOME/.config/restic-cronned/jobs/"))
-	viper.SetDefault("ServerPort", ":8080")
+	viper.SetDefault("ServerPort", "localhost:8080")
+	viper.SetDefault("Socket.Path", "")
+	viper.SetDefault("MetricsPort", "")
+	viper.SetDefault("LogOutput", "auto")
+	viper.SetDefault("LogFormat", logging.FormatLogfmt)
+	viper.SetDefault("LogLevel", "info")
+	viper.SetDefault("JobLogFiles", false)
 	viper.SetDefault("LogMaxAge", 30)
 	viper.SetDefault("LogMaxSize", 10)
 	viper.SetDefault("LogDir", os.ExpandEnv("$HOME/.cache/restic-cronned"))
+	viper.SetDefault("RunLogsKept", 100)
+	viper.SetDefault("StrictJobs", true)
+	viper.SetDefault("RepoPath", os.ExpandEnv("$HOME/.config/restic-cronned/repos.d/"))
+	viper.SetDefault("StateDir", os.ExpandEnv("$HOME/.local/share/restic-cronned"))
 
 	viper.ReadInConfig()
 
@@ -73,13 +181,60 @@ func loadConfig() {
 	if *port == "" {
 		*port = viper.GetString("ServerPort")
 	}
+	jobs.StrictDecoding = viper.GetBool("StrictJobs")
+	jobs.StateDir = os.ExpandEnv(viper.GetString("StateDir"))
+	//the output of the runs goes next to the log of the daemon
+	jobs.LogDir = path.Join(os.ExpandEnv(viper.GetString("LogDir")), "runs")
+	jobs.LogFilesKept = viper.GetInt("RunLogsKept")
 
 	println("JobPath: " + *jobpath)
 	println("Port: " + *port)
 }
 
+//loadRepositories registers the repository profiles from the config file and the repos.d directory
+func loadRepositories() {
+	if cfg := viper.ConfigFileUsed(); cfg != "" {
+		repos, err := jobs.LoadRepositoriesFromConfig(cfg)
+		if err != nil {
+			log.WithFields(log.Fields{"File": cfg, "Error": err.Error()}).Error("Could not load repositories")
+		} else if err = jobs.RegisterRepositories(repos...); err != nil {
+			log.WithFields(log.Fields{"File": cfg, "Error": err.Error()}).Error("Could not load repositories")
+		}
+	}
+
+	repoPath := os.ExpandEnv(viper.GetString("RepoPath"))
+	if _, err := os.Stat(repoPath); err != nil {
+		return
+	}
+	repos, err := jobs.LoadRepositoriesFromDir(repoPath)
+	if err != nil {
+		log.WithFields(log.Fields{"Dir": repoPath, "Error": err.Error()}).Error("Could not load repositories")
+		return
+	}
+	err = jobs.RegisterRepositories(repos...)
+	if err != nil {
+		log.WithFields(log.Fields{"Dir": repoPath, "Error": err.Error()}).Error("Could not load repositories")
+	}
+}
+
+func printSchema() {
+	s, err := jobs.JobSchema()
+	if err != nil {
+		println(err.Error())
+		os.Exit(1)
+	}
+	os.Stdout.Write(s)
+	os.Stdout.Write([]byte("\n"))
+}
+
 func main() {
+	kingpin.Parse()
+	if *schema {
+		printSchema()
+		return
+	}
 	loadConfig()
 	setupLogging()
+	loadRepositories()
 	startDaemon()
 }
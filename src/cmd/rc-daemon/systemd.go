@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/killingspark/restic-cronned/src/jobs"
+	"github.com/killingspark/restic-cronned/src/systemd"
+)
+
+//minWatchdogSilence is the least time a job loop may be silent before the watchdog is not pinged anymore,
+//the loops beat every few seconds
+const minWatchdogSilence = 15 * time.Second
+
+//startSystemd tells systemd that the queue is started, keeps the status line up to date and pings the watchdog
+//while the queue does not hang. Without Type=notify it does nothing
+func startSystemd(queue *jobs.JobQueue) {
+	notified, err := systemd.Notify(systemd.Ready, systemd.Status(statusLine(len(queue.List()), nil)))
+	if err != nil {
+		log.WithFields(log.Fields{"Error": err.Error()}).Error("systemd could not be notified")
+		return
+	}
+	if !notified {
+		return
+	}
+	log.Info("Notified systemd")
+	go updateStatus(queue)
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go pingWatchdog(queue, interval)
+	}
+}
+
+//statusLine is shown by systemctl status
+func statusLine(jobCount int, running map[string]bool) string {
+	if len(running) == 0 {
+		return fmt.Sprintf("%d jobs, waiting", jobCount)
+	}
+	names := make([]string, 0, len(running))
+	for name := range running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("%d jobs, running: %s", jobCount, strings.Join(names, ", "))
+}
+
+//updateStatus follows the runs on the events of the queue and sends the running jobs as status
+func updateStatus(queue *jobs.JobQueue) {
+	running := make(map[string]bool)
+	handle := func(event jobs.Event) {
+		switch event.Type {
+		case jobs.EventRunStarted:
+			running[event.Job] = true
+		case jobs.EventRunFinished, jobs.EventJobRemoved:
+			delete(running, event.Job)
+		case jobs.EventJobReloaded:
+		default:
+			return
+		}
+		systemd.Notify(systemd.Status(statusLine(len(queue.List()), running)))
+	}
+	var lastID uint64
+	for {
+		missed, events, cancel := queue.Events().Subscribe(lastID)
+		for _, event := range missed {
+			//nothing ran before the queue started, only the events missed after a drop count
+			if lastID != 0 {
+				handle(event)
+			}
+		}
+		if len(missed) > 0 {
+			lastID = missed[len(missed)-1].ID
+		}
+		for event := range events {
+			lastID = event.ID
+			handle(event)
+		}
+		//the channel is closed if this lagged behind, it resumes after the last event it got
+		cancel()
+	}
+}
+
+//pingWatchdog pings the watchdog as long as no job loop hangs, otherwise systemd restarts the daemon after WatchdogSec
+func pingWatchdog(queue *jobs.JobQueue, interval time.Duration) {
+	silence := interval
+	if silence < minWatchdogSilence {
+		silence = minWatchdogSilence
+	}
+	for {
+		if stuck := queue.Stuck(silence); len(stuck) > 0 {
+			log.WithFields(log.Fields{"Stuck": strings.Join(stuck, ", ")}).Error("Hanging, the watchdog is not pinged")
+		} else {
+			systemd.Notify(systemd.Watchdog)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//AdHocRun is a one-off run of a job with other arguments. It does not touch the schedule, the retry counter or the follow ups
+type AdHocRun struct {
+	//added to the ResticArguments of the job, or used instead of them if Replace is set
+	Arguments []string
+	Replace   bool
+	//if set, stdout and stderr of restic are copied to it
+	Output io.Writer
+
+	info *RunInfo
+	done chan struct{}
+}
+
+//clientWriter copies the output of an ad hoc run to its client. A client that went away must not fail the copy,
+//that would kill restic, so the first error is swallowed and the client gets nothing after it.
+//stdout and stderr are copied at the same time, the mutex keeps their writes apart
+type clientWriter struct {
+	mutex sync.Mutex
+	wr    io.Writer
+}
+
+func (cw *clientWriter) Write(p []byte) (int, error) {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	if cw.wr != nil {
+		if _, err := cw.wr.Write(p); err != nil {
+			cw.wr = nil
+		}
+	}
+	return len(p), nil
+}
+
+func (adHoc *AdHocRun) arguments(defined []string) []string {
+	if adHoc.Replace {
+		return adHoc.Arguments
+	}
+	args := make([]string, 0, len(defined)+len(adHoc.Arguments))
+	args = append(args, defined...)
+	return append(args, adHoc.Arguments...)
+}
+
+func (job *Job) runAdHoc(adHoc *AdHocRun) {
+	job.logger().WithFields(log.Fields{"Arguments": adHoc.Arguments, "Replace": adHoc.Replace}).Info("Ad hoc run")
+	//an ad hoc run was not triggered by another job
+	triggeredBy := job.triggeredBy
+	job.triggeredBy = nil
+	job.run(adHoc)
+	job.triggeredBy = triggeredBy
+	close(adHoc.done)
+}
+
+//validateAdHoc checks the variables in the arguments of an ad hoc run
+func (job *Job) validateAdHoc(adHoc *AdHocRun) error {
+	for _, arg := range adHoc.Arguments {
+		if err := job.validateVariables(arg); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidArgument, err.Error())
+		}
+	}
+	return nil
+}
+
+//RunAdHoc runs the job once with the arguments of adHoc and blocks until restic finished.
+//If the job is working right now the run starts after it
+func (job *Job) RunAdHoc(adHoc *AdHocRun) (*RunInfo, error) {
+	if err := job.validateAdHoc(adHoc); err != nil {
+		return nil, err
+	}
+	adHoc.done = make(chan struct{})
+	if !job.sendTrigger(jobTrigger{kind: triggerExtern, adHoc: adHoc}) {
+		return nil, ErrNotRunning
+	}
+	<-adHoc.done
+	return adHoc.info, nil
+}
+
+//RunAdHoc runs the job with this name once with other arguments and waits for it
+func (queue *JobQueue) RunAdHoc(name string, adHoc *AdHocRun) (*RunInfo, error) {
+	job, _ := queue.FindJob(name)
+	if job == nil {
+		return nil, ErrNoSuchJob
+	}
+	return job.RunAdHoc(adHoc)
+}
+
+//StartAdHoc runs the job with this name once with other arguments in the background. The returned operation can be polled
+func (queue *JobQueue) StartAdHoc(name string, adHoc *AdHocRun) (Operation, error) {
+	job, _ := queue.FindJob(name)
+	if job == nil {
+		return Operation{}, ErrNoSuchJob
+	}
+	if err := job.validateAdHoc(adHoc); err != nil {
+		return Operation{}, err
+	}
+	return queue.startOperation("adhoc", name, func() (*RunInfo, error) { return job.RunAdHoc(adHoc) }), nil
+}
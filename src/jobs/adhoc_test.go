@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdHocRun(t *testing.T) {
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/echo"
+	job.ResticArguments = []string{"backup"}
+	job.JobNameToTrigger = JobNames{"B"}
+	job.CurrentRetry = 2
+
+	next := newJob()
+	next.JobName = "B"
+	next.ResticPath = "/bin/true"
+
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	queue.AddJobs(job, next)
+	defer queue.StopAllJobs()
+	time.Sleep(10 * time.Millisecond)
+
+	var out bytes.Buffer
+	info, err := queue.RunAdHoc("A", &AdHocRun{Arguments: []string{"--dry-run"}, Output: &out})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if strings.TrimSpace(out.String()) != "backup --dry-run" {
+		t.Error("Arguments not added: " + out.String())
+	}
+	if !info.AdHoc || info.Result != edgeSuccess {
+		t.Error("Run not tagged as ad hoc")
+	}
+	if snapshot := job.Snapshot(); snapshot.LastRun != nil || snapshot.CurrentRetry != 2 || len(snapshot.History) != 1 {
+		t.Error("Ad hoc run changed the state of the job")
+	}
+
+	out.Reset()
+	queue.RunAdHoc("A", &AdHocRun{Arguments: []string{"snapshots"}, Replace: true, Output: &out})
+	if strings.TrimSpace(out.String()) != "snapshots" {
+		t.Error("Arguments not replaced: " + out.String())
+	}
+	time.Sleep(50 * time.Millisecond)
+	if next.Snapshot().LastRun != nil {
+		t.Error("Ad hoc run triggered a follow up")
+	}
+
+	if _, err := queue.RunAdHoc("A", &AdHocRun{Arguments: []string{"${nope}"}}); err == nil {
+		t.Error("Unknown variable in ad hoc arguments accepted")
+	}
+}
+
+//goneClient fails like the connection of a client that went away
+type goneClient struct{}
+
+func (goneClient) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestAdHocClientGone(t *testing.T) {
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/sh"
+	job.ResticArguments = []string{"-c", "for i in 1 2 3; do echo $i; echo err $i >&2; done"}
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	queue.AddJobs(job)
+	defer queue.StopAllJobs()
+
+	info, err := queue.RunAdHoc("A", &AdHocRun{Output: goneClient{}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info.Result != ResultSuccess {
+		t.Errorf("The run failed with the client: %s", info.Result)
+	}
+	if lines, _, _, _, _ := job.RunLog(info.ID).Lines(0); len(lines) != 6 {
+		t.Errorf("The run log was cut off: %v", lines)
+	}
+}
@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//CancelGracePeriod is how long restic gets after SIGINT to remove its lock and exit before it is killed
+var CancelGracePeriod = 30 * time.Second
+
+//setProcess remembers the running command so it can be cancelled and returns the channel to close when it exited
+func (job *Job) setProcess(cmd *exec.Cmd) chan struct{} {
+	job.process.Lock()
+	defer job.process.Unlock()
+	job.process.cmd = cmd
+	job.process.done = make(chan struct{})
+	job.process.cancelled = false
+	return job.process.done
+}
+
+//clearProcess forgets the command after it exited and reports if it was cancelled
+func (job *Job) clearProcess() bool {
+	job.process.Lock()
+	defer job.process.Unlock()
+	job.process.cmd = nil
+	cancelled := job.process.cancelled
+	job.process.cancelled = false
+	return cancelled
+}
+
+//Cancel interrupts the running restic process. It is sent SIGINT first so restic can remove its lock and is killed if it did not
+//exit after CancelGracePeriod. Cancel blocks until the process exited
+func (job *Job) Cancel() error {
+	job.process.Lock()
+	cmd, done := job.process.cmd, job.process.done
+	if cmd == nil {
+		job.process.Unlock()
+		return ErrNotRunning
+	}
+	job.process.cancelled = true
+	job.process.Unlock()
+
+	job.logger().Info("Cancel, sending SIGINT")
+	err := cmd.Process.Signal(os.Interrupt)
+	if err != nil {
+		job.logger().WithFields(log.Fields{"Error": err.Error()}).Warning("Could not interrupt restic")
+	}
+	select {
+	case <-done:
+		return nil
+	case <-time.After(CancelGracePeriod):
+	}
+	job.logger().Warning("restic did not exit after SIGINT, killing it")
+	err = cmd.Process.Kill()
+	<-done
+	return err
+}
+
+//Operation is a long running action started from the outside. It can be polled by its ID
+type Operation struct {
+	ID       string    `json:"ID"`
+	Action   string    `json:"Action"`
+	Job      string    `json:"Job"`
+	Status   string    `json:"Status"`
+	Error    string    `json:"Error,omitempty"`
+	Started  time.Time `json:"Started"`
+	Finished time.Time `json:"Finished"`
+	//the run the operation made, if any
+	Run *RunInfo `json:"Run,omitempty"`
+
+	//closed when the operation finished
+	done chan struct{}
+}
+
+//Pending tells if the operation is still running
+func (op Operation) Pending() bool {
+	return op.Status == operationRunning
+}
+
+//stati of operations
+const (
+	operationRunning = "running"
+	operationDone    = "done"
+	operationFailed  = "failed"
+)
+
+//operations are kept for this long after they finished
+const operationRetention = time.Hour
+
+type operationStore struct {
+	sync.Mutex
+	ops map[string]*Operation
+}
+
+func newOperationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+//startOperation runs fn in the background as a new operation and returns it
+func (queue *JobQueue) startOperation(action, jobName string, fn func() (*RunInfo, error)) Operation {
+	op := &Operation{ID: newOperationID(), Action: action, Job: jobName, Status: operationRunning, Started: time.Now(), done: make(chan struct{})}
+
+	queue.operations.Lock()
+	if queue.operations.ops == nil {
+		queue.operations.ops = make(map[string]*Operation)
+	}
+	for id, old := range queue.operations.ops {
+		if old.Status != operationRunning && time.Since(old.Finished) > operationRetention {
+			delete(queue.operations.ops, id)
+		}
+	}
+	queue.operations.ops[op.ID] = op
+	started := *op
+	queue.operations.Unlock()
+
+	go func() {
+		run, err := fn()
+		queue.operations.Lock()
+		defer queue.operations.Unlock()
+		defer close(op.done)
+		op.Finished = time.Now()
+		op.Run = run
+		if err != nil {
+			op.Status = operationFailed
+			op.Error = err.Error()
+		} else {
+			op.Status = operationDone
+		}
+	}()
+	return started
+}
+
+//Operation returns the operation with this ID
+func (queue *JobQueue) Operation(id string) (Operation, error) {
+	queue.operations.Lock()
+	defer queue.operations.Unlock()
+	op, ok := queue.operations.ops[id]
+	if !ok {
+		return Operation{}, ErrNoSuchOperation
+	}
+	return *op, nil
+}
+
+//WaitOperation waits at most timeout for the operation to finish and returns it as it is then
+func (queue *JobQueue) WaitOperation(id string, timeout time.Duration) (Operation, error) {
+	op, err := queue.Operation(id)
+	if err != nil {
+		return op, err
+	}
+	select {
+	case <-op.done:
+	case <-time.After(timeout):
+	}
+	return queue.Operation(id)
+}
+
+//StartStop stops the job with this name in the background. Stopping waits until the running command finished,
+//the returned operation can be polled
+func (queue *JobQueue) StartStop(name string) (Operation, error) {
+	job, _ := queue.FindJob(name)
+	if job == nil {
+		return Operation{}, ErrNoSuchJob
+	}
+	if job.currentStatus() == statusStopped {
+		return Operation{}, ErrNotRunning
+	}
+	return queue.startOperation("stop", name, func() (*RunInfo, error) {
+		job.Stop()
+		return nil, nil
+	}), nil
+}
+
+//StartStopAll stops all jobs in the background
+func (queue *JobQueue) StartStopAll() Operation {
+	return queue.startOperation("stopall", "", func() (*RunInfo, error) {
+		queue.StopAllJobs()
+		return nil, nil
+	})
+}
+
+//CancelJob cancels the running restic process of the job in the background. The returned operation can be polled
+//to see when the process exited
+func (queue *JobQueue) CancelJob(name string) (Operation, error) {
+	job, _ := queue.FindJob(name)
+	if job == nil {
+		return Operation{}, ErrNoSuchJob
+	}
+	if job.currentStatus() != statusWorking {
+		return Operation{}, ErrNotRunning
+	}
+	return queue.startOperation("cancel", name, func() (*RunInfo, error) { return nil, job.Cancel() }), nil
+}
@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCancel(t *testing.T) {
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/sleep"
+	job.ResticArguments = []string{"10"}
+	job.JobNameToTrigger = JobNames{"B"}
+
+	next := newJob()
+	next.JobName = "B"
+	next.ResticPath = "/bin/true"
+
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	queue.AddJobs(job, next)
+	defer queue.StopAllJobs()
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := queue.CancelJob("A"); err == nil {
+		t.Error("Cancelled a job that was not running")
+	}
+
+	queue.TriggerJob("A")
+	time.Sleep(50 * time.Millisecond)
+	op, err := queue.CancelJob("A")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if op.Status != operationRunning {
+		t.Error("Cancel did not return immediately")
+	}
+
+	for i := 0; i < 100 && op.Status == operationRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+		op, _ = queue.Operation(op.ID)
+	}
+	if op.Status != operationDone {
+		t.Error("Operation did not finish: " + op.Status + " " + op.Error)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if last := job.Snapshot().LastRun; last == nil || last.Result != resultCancelled {
+		t.Error("Run not recorded as cancelled")
+	}
+	if next.Snapshot().LastRun != nil {
+		t.Error("Follow up triggered by a cancelled run")
+	}
+}
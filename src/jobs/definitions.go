@@ -0,0 +1,287 @@
+package jobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//errors of writing job definitions
+var (
+	ErrETagRequired = errors.New("If-Match with the ETag of the definition is needed to change it")
+	ErrETagMismatch = errors.New("The definition was changed in the meantime")
+	ErrSharedFile   = errors.New("The job is not the only content of its file, edit the file instead")
+)
+
+//archiveDir is the directory in the job directory deleted definitions are moved to
+const archiveDir = ".archive"
+
+//ETag identifies the content of a definition file
+func ETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+//JobSource is the file that defines a job as it is on disk
+type JobSource struct {
+	File    string
+	Content []byte
+	ETag    string
+}
+
+//definitionFile finds the file that defines the job. It is the file of the loaded job, or <name>.json for a new job.
+//exists is false if there is no such file yet
+func (queue *JobQueue) definitionFile(name string) (file string, exists bool, err error) {
+	if err := validateJobName(name); err != nil {
+		return "", false, fmt.Errorf("%w: %s", ErrInvalidArgument, err.Error())
+	}
+	file = path.Join(queue.Directory, name+".json")
+	if job, _ := queue.FindJob(name); job != nil && job.SourceFile() != "" {
+		file = job.SourceFile()
+	}
+	_, err = os.Stat(file)
+	if os.IsNotExist(err) {
+		return file, false, nil
+	}
+	return file, err == nil, err
+}
+
+//readSource reads the file and checks that it defines only the job with this name
+func readSource(file, name string) (*JobSource, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	f.Seek(0, 0)
+	defs, err := readDefinitions(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(defs) != 1 || defs[0].name() != name || defs[0].isTemplate() || defs[0].raw["Instances"] != nil || defs[0].raw["InstancesGlob"] != nil {
+		return nil, ErrSharedFile
+	}
+	return &JobSource{File: file, Content: content, ETag: ETag(content)}, nil
+}
+
+//checkETag implements If-Match: existing definitions can only be changed by someone who knows their current content
+func checkETag(source *JobSource, ifMatch string) error {
+	if source == nil {
+		if ifMatch != "" && ifMatch != "*" {
+			return ErrETagMismatch
+		}
+		return nil
+	}
+	if ifMatch == "" {
+		return ErrETagRequired
+	}
+	if ifMatch != "*" && ifMatch != source.ETag {
+		return ErrETagMismatch
+	}
+	return nil
+}
+
+//Source returns the file that defines the job with this name
+func (queue *JobQueue) Source(name string) (*JobSource, error) {
+	job, _ := queue.FindJob(name)
+	if job == nil || job.SourceFile() == "" {
+		return nil, ErrNoSuchJob
+	}
+	content, err := ioutil.ReadFile(job.SourceFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoDefinition
+		}
+		return nil, err
+	}
+	return &JobSource{File: job.SourceFile(), Content: content, ETag: ETag(content)}, nil
+}
+
+//validateDefinition builds the job from the new content of file together with the definitions of all other files,
+//so templates and the follow-up graph are checked
+func (queue *JobQueue) validateDefinition(file, name string, raw map[string]interface{}) (*Job, error) {
+	dirDefs, err := readDirDefinitions(queue.Directory)
+	if err != nil {
+		return nil, err
+	}
+	defs := []*definition{{raw: raw, file: file}}
+	for _, def := range dirDefs {
+		if def.file != file {
+			defs = append(defs, def)
+		}
+	}
+	jobs, errs := buildJobs(defs)
+	for _, err := range errs {
+		var defErr *definitionError
+		if errors.As(err, &defErr) && defErr.file == file {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidArgument, defErr.err.Error())
+		}
+	}
+	_, errs = validateGraph(jobs)
+	for _, err := range errs {
+		var cycle *cycleError
+		if errors.As(err, &cycle) && cycle.jobs.contains(name) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidArgument, err.Error())
+		}
+	}
+	for _, job := range jobs {
+		if job.JobName == name && job.SourceFile() == file {
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: the definition does not define the job %s", ErrInvalidArgument, name)
+}
+
+//writeAtomically writes to a temporary file first, a crash can not leave a half written definition behind
+func writeAtomically(file string, content []byte) error {
+	tmp := path.Join(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+//PutJob validates the json definition and writes it to the job directory. The job is started in the background,
+//replacing the old one as soon as that stopped. ifMatch must be the ETag of the current definition if there is one.
+//The ETag of the new definition and the operation that starts the job are returned
+func (queue *JobQueue) PutJob(name string, content []byte, ifMatch string) (string, bool, Operation, error) {
+	queue.definitionsMutex.Lock()
+	defer queue.definitionsMutex.Unlock()
+
+	file, exists, err := queue.definitionFile(name)
+	if err != nil {
+		return "", false, Operation{}, err
+	}
+	var source *JobSource
+	if exists {
+		if source, err = readSource(file, name); err != nil {
+			return "", false, Operation{}, err
+		}
+		if !strings.HasSuffix(file, ".json") {
+			return "", false, Operation{}, fmt.Errorf("%w: %s is no json file", ErrSharedFile, filepath.Base(file))
+		}
+	}
+	if err := checkETag(source, ifMatch); err != nil {
+		return "", false, Operation{}, err
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return "", false, Operation{}, fmt.Errorf("%w: %s", ErrInvalidArgument, err.Error())
+	}
+	if n, ok := raw["JobName"]; ok && n != name {
+		return "", false, Operation{}, fmt.Errorf("%w: JobName %v does not match %s", ErrInvalidArgument, n, name)
+	} else if !ok {
+		//the file must name the job, the content is only reformatted if it does not
+		raw["JobName"] = name
+		if content, err = json.MarshalIndent(raw, "", "    "); err != nil {
+			return "", false, Operation{}, err
+		}
+	}
+	delete(raw, "$schema")
+	if err := upgradeDefinition(raw); err != nil {
+		return "", false, Operation{}, fmt.Errorf("%w: %s", ErrInvalidArgument, err.Error())
+	}
+	job, err := queue.validateDefinition(file, name, raw)
+	if err != nil {
+		return "", false, Operation{}, err
+	}
+
+	if err := writeAtomically(file, content); err != nil {
+		return "", false, Operation{}, err
+	}
+	log.WithFields(log.Fields{"Job": name, "File": file, "Created": !exists}).Info("Definition written")
+	return ETag(content), !exists, queue.startChange("put", name, func() error {
+		queue.addJobs(job)
+		return nil
+	}), nil
+}
+
+//startChange applies the change of the job list in the background, after the changes that were started before it.
+//Replacing or removing a job waits until its restic finished, the caller must not hold a lock meanwhile
+func (queue *JobQueue) startChange(action, name string, change func() error) Operation {
+	previous, done := queue.reserveChange()
+	return queue.startOperation(action, name, func() (*RunInfo, error) {
+		defer close(done)
+		if previous != nil {
+			<-previous
+		}
+		return nil, change()
+	})
+}
+
+//DeleteJob deletes the definition file of the job, or moves it to the .archive directory in the job directory, and removes
+//the job in the background. ifMatch must be the ETag of the current definition. The operation that removes the job is returned
+func (queue *JobQueue) DeleteJob(name string, archive bool, ifMatch string) (Operation, error) {
+	queue.definitionsMutex.Lock()
+	defer queue.definitionsMutex.Unlock()
+
+	file, exists, err := queue.definitionFile(name)
+	if err != nil {
+		return Operation{}, err
+	}
+	if !exists {
+		if !queue.JobExists(name) {
+			return Operation{}, ErrNoSuchJob
+		}
+		return queue.startChange("delete", name, func() error { return queue.removeJob(name) }), nil
+	}
+	source, err := readSource(file, name)
+	if err != nil {
+		return Operation{}, err
+	}
+	if err := checkETag(source, ifMatch); err != nil {
+		return Operation{}, err
+	}
+
+	if archive {
+		dir := path.Join(queue.Directory, archiveDir)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return Operation{}, err
+		}
+		archived := path.Join(dir, filepath.Base(file)+"."+time.Now().Format("20060102-150405"))
+		log.WithFields(log.Fields{"Job": name, "File": file, "Archive": archived}).Info("Definition archived")
+		err = os.Rename(file, archived)
+	} else {
+		log.WithFields(log.Fields{"Job": name, "File": file}).Info("Definition deleted")
+		err = os.Remove(file)
+	}
+	if err != nil {
+		return Operation{}, err
+	}
+	return queue.startChange("delete", name, func() error {
+		//a definition that could not be built has no job to remove
+		if err := queue.removeJob(name); err != ErrNoSuchJob {
+			return err
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+//waitChange waits until the operation changed the job list
+func waitChange(t *testing.T, queue *JobQueue, op Operation) {
+	op, err := queue.WaitOperation(op.ID, 5*time.Second)
+	if err != nil || op.Status != operationDone {
+		t.Fatalf("Change not applied: %v %s %s", err, op.Status, op.Error)
+	}
+}
+
+func TestPutAndDeleteJob(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{
+		"pair.yaml": "- JobName: A\n  ResticPath: /bin/true\n- JobName: B\n  ResticPath: /bin/true\n",
+	})
+	defer os.RemoveAll(dir)
+	queue, err := NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.StartQueue()
+	defer queue.StopAllJobs()
+
+	etag, created, op, err := queue.PutJob("C", []byte(`{"ResticPath": "/bin/true", "NextJob": "A"}`), "")
+	if err != nil || !created {
+		t.Fatalf("Job not created: %v", err)
+	}
+	waitChange(t, queue, op)
+	if _, err := os.Stat(path.Join(dir, "C.json")); err != nil || !queue.JobExists("C") {
+		t.Error("Created job not written or not started")
+	}
+
+	_, _, _, err = queue.PutJob("C", []byte(`{"ResticPath": "/bin/false"}`), "")
+	if !errors.Is(err, ErrETagRequired) {
+		t.Error("Update without If-Match accepted")
+	}
+	_, _, _, err = queue.PutJob("C", []byte(`{"ResticPath": "/bin/false"}`), `"stale"`)
+	if !errors.Is(err, ErrETagMismatch) {
+		t.Error("Update with a stale ETag accepted")
+	}
+	_, _, _, err = queue.PutJob("C", []byte(`{"ResticPaht": "/bin/false"}`), etag)
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Error("Invalid definition accepted")
+	}
+	_, _, _, err = queue.PutJob("C", []byte(`{"NextJob": "C"}`), etag)
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Error("Definition with a cycle accepted")
+	}
+	etag, created, op, err = queue.PutJob("C", []byte(`{"JobName": "C", "ResticPath": "/bin/false"}`), etag)
+	if err != nil || created {
+		t.Fatal("Job not replaced")
+	}
+	waitChange(t, queue, op)
+	job, _ := queue.FindJob("C")
+	if job.ResticPath != "/bin/false" {
+		t.Error("Replaced job not started")
+	}
+	source, err := queue.Source("C")
+	if err != nil || source.ETag != etag || string(source.Content) != `{"JobName": "C", "ResticPath": "/bin/false"}` {
+		t.Error("Source does not match the written definition")
+	}
+
+	_, _, _, err = queue.PutJob("A", []byte(`{"ResticPath": "/bin/false"}`), "*")
+	if !errors.Is(err, ErrSharedFile) {
+		t.Error("Job in a file with other jobs was overwritten")
+	}
+
+	op, err = queue.DeleteJob("C", true, etag)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	waitChange(t, queue, op)
+	archived, _ := ioutil.ReadDir(path.Join(dir, archiveDir))
+	if queue.JobExists("C") || len(archived) != 1 {
+		t.Error("Job not removed and archived")
+	}
+	if _, err := os.Stat(path.Join(dir, "C.json")); !os.IsNotExist(err) {
+		t.Error("Definition still in the job directory")
+	}
+}
+
+func TestPutJobWhileRunning(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{
+		"A.json": `{"JobName": "A", "ResticPath": "/bin/sleep", "ResticArguments": ["1"]}`,
+	})
+	defer os.RemoveAll(dir)
+	queue, err := NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.StartQueue()
+	defer queue.StopAllJobs()
+	time.Sleep(10 * time.Millisecond)
+	queue.TriggerJob("A")
+	time.Sleep(50 * time.Millisecond)
+
+	source, _ := queue.Source("A")
+	started := time.Now()
+	_, _, op, err := queue.PutJob("A", []byte(`{"ResticPath": "/bin/true"}`), source.ETag)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if time.Since(started) > 500*time.Millisecond || !op.Pending() {
+		t.Error("PutJob waited for the running job")
+	}
+	//the list can be read while the old job is stopping
+	if job, _ := queue.FindJob("A"); job == nil || job.ResticPath != "/bin/sleep" || len(queue.List()) != 1 {
+		t.Error("Old job not listed until it stopped")
+	}
+	waitChange(t, queue, op)
+	if job, _ := queue.FindJob("A"); job == nil || job.ResticPath != "/bin/true" {
+		t.Error("New job not started after the old one stopped")
+	}
+}
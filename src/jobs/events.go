@@ -0,0 +1,153 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+)
+
+//types of the events of the jobs
+const (
+	EventTriggerScheduled      = "trigger_scheduled"
+	EventTriggerReceived       = "trigger_received"
+	EventPreconditionsChecking = "preconditions_checking"
+	EventPreconditionsFailed   = "preconditions_failed"
+	EventRunStarted            = "run_started"
+	EventProgress              = "progress"
+	EventRunFinished           = "run_finished"
+	EventRetryScheduled        = "retry_scheduled"
+	EventJobFailed             = "job_failed"
+	EventJobStopped            = "job_stopped"
+	EventJobReloaded           = "job_reloaded"
+	EventJobRemoved            = "job_removed"
+	EventJobStale              = "job_stale"
+	EventJobFresh              = "job_fresh"
+)
+
+//Event is something that happened to a job. Which of the other fields are set depends on the Type
+type Event struct {
+	//ID counts up from 1 with every event since the daemon started
+	ID   uint64
+	Type string
+	Job  string
+	Time time.Time
+	//the kind of trigger: regular, retry, extern, followup, adhoc or intern
+	Trigger string
+	//when a scheduled trigger fires. job_stale, job_fresh: the time of the newest backup, zero if there is none
+	At       time.Time
+	Progress float64
+	//retry_scheduled: the number of the retry, job_failed: the retries that failed
+	Retry int
+	//a copy of the run when it started or finished
+	Run *RunInfo
+}
+
+//EventPublisher is implemented by stores that pass the events of their jobs on
+type EventPublisher interface {
+	Publish(event Event)
+}
+
+//eventHistorySize is the number of events kept, so subscribers can resume after a reconnect
+const eventHistorySize = 1000
+
+//subscriberBuffer is the number of events a subscriber may lag behind before it is dropped
+const subscriberBuffer = 256
+
+//EventBus passes the events on to its subscribers and keeps the last ones. The zero value is ready to use
+type EventBus struct {
+	mutex       sync.Mutex
+	lastID      uint64
+	history     []Event
+	subscribers map[chan Event]bool
+}
+
+//Publish numbers the event and sends it to all subscribers. Subscribers that can not keep up are dropped,
+//their channel is closed and they can resume with the ID of the last event they got
+func (bus *EventBus) Publish(event Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.lastID++
+	event.ID = bus.lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	bus.history = append(bus.history, event)
+	if len(bus.history) > eventHistorySize {
+		bus.history = bus.history[len(bus.history)-eventHistorySize:]
+	}
+	for ch := range bus.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(bus.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+//Subscribe returns the kept events after lastID and a channel for the events that follow. Call cancel when done.
+//An ID from before a restart of the daemon is larger than any current ID, then all kept events are returned
+func (bus *EventBus) Subscribe(lastID uint64) (missed []Event, events <-chan Event, cancel func()) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if lastID > bus.lastID {
+		lastID = 0
+	}
+	for _, event := range bus.history {
+		if event.ID > lastID {
+			missed = append(missed, event)
+		}
+	}
+	ch := make(chan Event, subscriberBuffer)
+	if bus.subscribers == nil {
+		bus.subscribers = make(map[chan Event]bool)
+	}
+	bus.subscribers[ch] = true
+	cancel = func() {
+		bus.mutex.Lock()
+		defer bus.mutex.Unlock()
+		if bus.subscribers[ch] {
+			delete(bus.subscribers, ch)
+			close(ch)
+		}
+	}
+	return missed, ch, cancel
+}
+
+//emit publishes the event of this job if the store passes events on
+func (job *Job) emit(event Event) {
+	event.Job = job.JobName
+	if publisher, ok := job.jobstore.(EventPublisher); ok {
+		publisher.Publish(event)
+	}
+}
+
+//emitRun publishes an event with a copy of the run, the run itself is still changed
+func (job *Job) emitRun(eventType string, info *RunInfo) {
+	run := *info
+	job.emit(Event{Type: eventType, Run: &run})
+}
+
+//triggerName tells what kind of trigger it is for the events
+func triggerName(trig jobTrigger) string {
+	switch {
+	case trig.adHoc != nil:
+		return "adhoc"
+	case trig.timer != "":
+		return trig.timer
+	case trig.from != nil:
+		return "followup"
+	case trig.kind == triggerExtern:
+		return "extern"
+	}
+	return "intern"
+}
+
+//Publish passes the event on to the subscribers of the queue
+func (queue *JobQueue) Publish(event Event) {
+	queue.events.Publish(event)
+}
+
+//Events returns the bus the events of all jobs of the queue are published on
+func (queue *JobQueue) Events() *EventBus {
+	return &queue.events
+}
@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	var bus EventBus
+	bus.Publish(Event{Type: EventJobStopped, Job: "A"})
+	bus.Publish(Event{Type: EventJobRemoved, Job: "A"})
+
+	missed, events, cancel := bus.Subscribe(1)
+	if len(missed) != 1 || missed[0].ID != 2 || missed[0].Type != EventJobRemoved {
+		t.Errorf("Wrong events to resume: %v", missed)
+	}
+	bus.Publish(Event{Type: EventJobReloaded, Job: "A"})
+	if event := <-events; event.ID != 3 || event.Time.IsZero() {
+		t.Errorf("Wrong event: %v", event)
+	}
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("Channel not closed by cancel")
+	}
+
+	//an id from before a restart
+	missed, _, cancel = bus.Subscribe(100)
+	defer cancel()
+	if len(missed) != 3 {
+		t.Error("All kept events expected for an unknown id")
+	}
+
+	//slow subscribers are dropped
+	_, slow, _ := bus.Subscribe(3)
+	for i := 0; i < subscriberBuffer+1; i++ {
+		bus.Publish(Event{Type: EventProgress})
+	}
+	count := 0
+	for range slow {
+		count++
+	}
+	if count != subscriberBuffer {
+		t.Errorf("Slow subscriber got %d events", count)
+	}
+}
+
+func TestJobEvents(t *testing.T) {
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/true"
+
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	_, events, cancel := queue.Events().Subscribe(0)
+	defer cancel()
+	queue.AddJobs(job)
+	time.Sleep(10 * time.Millisecond)
+	queue.TriggerJob("A")
+	time.Sleep(50 * time.Millisecond)
+	queue.RemoveJob("A")
+
+	expected := []string{EventTriggerReceived, EventRunStarted, EventRunFinished, EventJobStopped, EventJobRemoved}
+	for _, eventType := range expected {
+		select {
+		case event := <-events:
+			if event.Type != eventType || event.Job != "A" {
+				t.Errorf("Got %s of %s, expected %s", event.Type, event.Job, eventType)
+			}
+			if event.Type == EventTriggerReceived && event.Trigger != "extern" {
+				t.Error("Wrong trigger: " + event.Trigger)
+			}
+			if event.Type == EventRunFinished && (event.Run == nil || event.Run.Result != edgeSuccess) {
+				t.Error("Run missing in the event")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Missing event " + eventType)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//variablePattern matches ${name} and ${name:argument}
+var variablePattern = regexp.MustCompile(`\$\{([A-Za-z][A-Za-z0-9_.]*)(?::([^}]*))?\}`)
+
+//variableFunc returns the value of a variable for the job at the time now
+type variableFunc func(job *Job, arg string, now time.Time) string
+
+//variable is a variable that can be used in job fields. needsArg says if the variable must (or must not) be given an argument
+type variable struct {
+	value    variableFunc
+	needsArg bool
+	//checks if the variable can be used with this job, called when the job is loaded
+	validate func(job *Job) error
+}
+
+var variables = map[string]variable{
+	"env": {
+		value:    func(job *Job, arg string, now time.Time) string { return os.Getenv(arg) },
+		needsArg: true,
+	},
+	"hostname": {
+		value: func(job *Job, arg string, now time.Time) string {
+			name, _ := os.Hostname()
+			return name
+		},
+	},
+	"job.name": {
+		value: func(job *Job, arg string, now time.Time) string { return job.JobName },
+	},
+	"now": {
+		value: func(job *Job, arg string, now time.Time) string { return formatTime(now, arg) },
+	},
+	"repo.name": {
+		value: func(job *Job, arg string, now time.Time) string { return job.RepositoryName },
+		validate: func(job *Job) error {
+			if job.RepositoryName == "" {
+				return fmt.Errorf("${repo.name} used but the job has no Repository")
+			}
+			return nil
+		},
+	},
+	"repo.location": {
+		value: func(job *Job, arg string, now time.Time) string {
+			if repo := job.repository(); repo != nil {
+				return repo.Location
+			}
+			return job.getRepo()
+		},
+	},
+	//the run that triggered this job as a follow up, empty if the job was triggered in another way
+	"trigger.job":      triggerVariable(func(info *RunInfo, arg string) string { return info.Job }),
+	"trigger.result":   triggerVariable(func(info *RunInfo, arg string) string { return info.Result }),
+	"trigger.exitcode": triggerVariable(func(info *RunInfo, arg string) string { return strconv.Itoa(info.ExitCode) }),
+	"trigger.snapshot": triggerVariable(func(info *RunInfo, arg string) string { return info.SnapshotID }),
+	"trigger.started":  triggerVariable(func(info *RunInfo, arg string) string { return formatTime(info.Started, arg) }),
+	"trigger.finished": triggerVariable(func(info *RunInfo, arg string) string { return formatTime(info.Finished, arg) }),
+}
+
+//triggerVariable creates a variable whose value is taken from the run that triggered the job
+func triggerVariable(value func(info *RunInfo, arg string) string) variable {
+	return variable{
+		value: func(job *Job, arg string, now time.Time) string {
+			if job.triggeredBy == nil {
+				return ""
+			}
+			return value(job.triggeredBy, arg)
+		},
+	}
+}
+
+//formatTime formats t with the go time layout, RFC 3339 if no layout is given
+func formatTime(t time.Time, layout string) string {
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return t.Format(layout)
+}
+
+//variableNames lists the names of all known variables
+func variableNames() []string {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//expand replaces all variables in s with their current values
+func (job *Job) expand(s string, now time.Time) string {
+	return variablePattern.ReplaceAllStringFunc(s, func(m string) string {
+		match := variablePattern.FindStringSubmatch(m)
+		v, ok := job.lookupVariable(match[1])
+		if !ok {
+			return m
+		}
+		return v.value(job, match[2], now)
+	})
+}
+
+//expandAll expands all strings in the list
+func (job *Job) expandAll(list []string, now time.Time) []string {
+	expanded := make([]string, len(list))
+	for idx, s := range list {
+		expanded[idx] = job.expand(s, now)
+	}
+	return expanded
+}
+
+//lookupVariable finds a variable by name
+func (job *Job) lookupVariable(name string) (variable, bool) {
+	v, ok := variables[name]
+	return v, ok
+}
+
+//validateVariables checks that s only uses known variables in the correct way
+func (job *Job) validateVariables(s string) error {
+	for _, match := range variablePattern.FindAllStringSubmatch(s, -1) {
+		name, arg := match[1], match[2]
+		v, ok := job.lookupVariable(name)
+		if !ok {
+			return fmt.Errorf("unknown variable ${%s} in %q (known: %s)", name, s, strings.Join(variableNames(), ", "))
+		}
+		hasArg := strings.Contains(match[0], ":")
+		if v.needsArg && (!hasArg || arg == "") {
+			return fmt.Errorf("variable ${%s} in %q needs an argument like ${%s:...}", name, s, name)
+		}
+		if v.validate != nil {
+			if err := v.validate(job); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//expandableFields returns all strings of the job that are expanded at run time
+func (job *Job) expandableFields() []string {
+	fields := make([]string, 0)
+	fields = append(fields, job.ResticArguments...)
+	for _, p := range job.Preconditions.PathesMust {
+		fields = append(fields, string(p))
+	}
+	for _, h := range job.Preconditions.HostsMustRoute {
+		fields = append(fields, string(h))
+	}
+	for _, h := range job.Preconditions.HostsMustConnect {
+		fields = append(fields, h.Host)
+	}
+	if repo := job.repository(); repo != nil {
+		fields = append(fields, repo.Flags...)
+		for _, val := range repo.Env {
+			fields = append(fields, val)
+		}
+	}
+	if ping := job.pingConfig(); ping != nil {
+		fields = append(fields, ping.URL, ping.Start, ping.Success, ping.Fail)
+	}
+	if filter := job.MaxAgeSnapshots; filter != nil {
+		fields = append(fields, filter.Tags...)
+		fields = append(fields, filter.Paths...)
+		fields = append(fields, filter.Host)
+	}
+	return fields
+}
+
+//validateAllVariables checks all expandable fields of the job, so mistakes are found when loading and not when running
+func (job *Job) validateAllVariables() error {
+	for _, s := range job.expandableFields() {
+		if err := job.validateVariables(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//expandedPreconditions returns the preconditions with all variables replaced
+func (job *Job) expandedPreconditions(now time.Time) JobPreconditions {
+	jp := JobPreconditions{}
+	for _, p := range job.Preconditions.PathesMust {
+		jp.PathesMust = append(jp.PathesMust, PathPrecond(job.expand(string(p), now)))
+	}
+	for _, h := range job.Preconditions.HostsMustRoute {
+		jp.HostsMustRoute = append(jp.HostsMustRoute, HostRoutePrecond(job.expand(string(h), now)))
+	}
+	for _, h := range job.Preconditions.HostsMustConnect {
+		jp.HostsMustConnect = append(jp.HostsMustConnect, HostTCPPrecond{Host: job.expand(h.Host, now), Port: h.Port})
+	}
+	return jp
+}
@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVariableExpansion(t *testing.T) {
+	os.Setenv("RC_TEST_HOME", "/home/test")
+	job, err := loadJobFromString(t, `{
+		"JobName": "A",
+		"ResticArguments": ["backup", "${env:RC_TEST_HOME}", "--tag", "${job.name}-${now:2006-01-02}", "--host", "${hostname}"],
+		"Preconditions": {"PathesMust": ["${env:RC_TEST_HOME}/data"]}
+	}`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	now := time.Date(2018, 10, 3, 2, 0, 0, 0, time.UTC)
+	hostname, _ := os.Hostname()
+	args := job.expandAll(job.ResticArguments, now)
+	if strings.Join(args, " ") != "backup /home/test --tag A-2018-10-03 --host "+hostname {
+		t.Error("Wrong expansion: " + strings.Join(args, " "))
+	}
+	if job.ResticArguments[1] != "${env:RC_TEST_HOME}" {
+		t.Error("Expansion changed the definition, it must happen at run time")
+	}
+	if p := job.expandedPreconditions(now).PathesMust[0]; p != "/home/test/data" {
+		t.Error("Precondition path not expanded: " + string(p))
+	}
+}
+
+func TestUnknownVariablesRejected(t *testing.T) {
+	for _, def := range []string{
+		`{"JobName": "A", "ResticArguments": ["--tag", "${hostnmae}"]}`,
+		`{"JobName": "A", "ResticArguments": ["${env}"]}`,
+		`{"JobName": "A", "ResticArguments": ["${repo.name}"]}`,
+		`{"JobName": "A", "Preconditions": {"PathesMust": ["${home}"]}}`,
+	} {
+		_, err := loadJobFromString(t, def)
+		if err == nil {
+			t.Error("Invalid variable accepted: " + def)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//jobFileExtensions are the file types that are considered when searching for job (and repository) definitions
+var jobFileExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+//isJobFile checks if the name has one of the extensions of jobFileExtensions
+func isJobFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range jobFileExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+//parseDefinitions parses the content of a definition file into the raw definitions it contains.
+//A file can either contain one definition, a list of them, or an object with a listKey list (the only way for toml)
+func parseDefinitions(data []byte, fileName string, listKey string) ([]map[string]interface{}, error) {
+	parsed, err := parseDocument(data, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj, ok := parsed.(map[string]interface{}); ok {
+		list, hasList := obj[listKey]
+		if !hasList {
+			return []map[string]interface{}{obj}, nil
+		}
+		if len(obj) > 1 {
+			return nil, fmt.Errorf("a file with a %q list must not contain other keys", listKey)
+		}
+		parsed = list
+	}
+
+	var items []interface{}
+	switch l := parsed.(type) {
+	case []interface{}:
+		items = l
+	default:
+		return nil, errors.New("a definition file must contain an object or a list of objects")
+	}
+
+	defs := make([]map[string]interface{}, 0, len(items))
+	for idx, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("entry %d in the list is not an object", idx)
+		}
+		defs = append(defs, obj)
+	}
+	return defs, nil
+}
+
+//parseDocument parses a json, yaml or toml document (chosen by the extension of fileName) into generic maps and lists
+func parseDocument(data []byte, fileName string) (interface{}, error) {
+	var parsed interface{}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		var y interface{}
+		err := yaml.Unmarshal(data, &y)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err = normalizeYAML(y)
+		if err != nil {
+			return nil, err
+		}
+	case ".toml":
+		var t map[string]interface{}
+		_, err := toml.Decode(string(data), &t)
+		if err != nil {
+			return nil, err
+		}
+		//toml returns tables arrays as []map[string]interface{}, make them look like json lists
+		parsed = deepCopy(t)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err := dec.Decode(&parsed)
+		if err != nil {
+			return nil, friendlyDecodeError(err, reflect.TypeOf(Job{}))
+		}
+	}
+	return parsed, nil
+}
+
+//normalizeYAML converts the map[interface{}]interface{} yaml produces into the map[string]interface{} json expects
+func normalizeYAML(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", k)
+			}
+			n, err := normalizeYAML(val)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = n
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for idx, val := range t {
+			n, err := normalizeYAML(val)
+			if err != nil {
+				return nil, err
+			}
+			l[idx] = n
+		}
+		return l, nil
+	default:
+		return v, nil
+	}
+}
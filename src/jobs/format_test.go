@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeJobFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "rc-jobs")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(path.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	return dir
+}
+
+func TestJobFormats(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{
+		"single.json": `{"JobName": "Json", "ResticArguments": ["snapshots"]}`,
+		"list.json":   `[{"JobName": "ListA"}, {"JobName": "ListB", "NextJob": "ListA"}]`,
+		"pair.yaml": `
+# backup and forget always go together
+- JobName: YamlBackup
+  NextJob: YamlForget
+  maxFailedRetries: 2
+  Preconditions:
+    HostsMustConnect:
+      - {Host: localhost, Port: 22}
+- JobName: YamlForget
+  ResticArguments: [forget, --keep-last, "30"]
+`,
+		"pair.toml": `
+# keep a month of snapshots
+[[Jobs]]
+JobName = "TomlBackup"
+NextJob = "TomlForget"
+
+[[Jobs]]
+JobName = "TomlForget"
+ResticArguments = ["forget", "--keep-last", "30"]
+`,
+		"notes.txt": `not a job`,
+	})
+	defer os.RemoveAll(dir)
+
+	js, err := FindJobs(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	found := make(map[string]*Job)
+	for _, j := range js {
+		found[j.JobName] = j
+	}
+	for _, name := range []string{"Json", "ListA", "ListB", "YamlBackup", "YamlForget", "TomlBackup", "TomlForget"} {
+		if found[name] == nil {
+			t.Error("Job not loaded: " + name)
+		}
+	}
+	if len(js) != 7 {
+		t.Errorf("Expected 7 jobs, got %d", len(js))
+	}
+	if j := found["YamlBackup"]; j != nil {
+		if j.MaxFailedRetries != 2 || len(j.Preconditions.HostsMustConnect) != 1 || j.Preconditions.HostsMustConnect[0].Port != 22 {
+			t.Error("Yaml job not correctly unmarshalled")
+		}
+	}
+	if j := found["TomlForget"]; j != nil && len(j.ResticArguments) != 3 {
+		t.Error("Toml job not correctly unmarshalled")
+	}
+}
+
+func TestReloadJobFromSharedFile(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{
+		"pair.yaml": "- JobName: A\n- JobName: B\n  maxFailedRetries: 1\n",
+	})
+	defer os.RemoveAll(dir)
+
+	queue, err := NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.StartQueue()
+	defer queue.StopAllJobs()
+
+	ioutil.WriteFile(path.Join(dir, "pair.yaml"), []byte("- JobName: A\n- JobName: B\n  maxFailedRetries: 5\n"), 0600)
+	err = queue.ReloadJob("B")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	job, _ := queue.FindJob("B")
+	if job == nil || job.MaxFailedRetries != 5 {
+		t.Error("Job was not reloaded from the shared file")
+	}
+}
+
+func TestLoadFileJobs(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{
+		"templates.json": `{"JobName": "base", "Template": true, "maxFailedRetries": 4}`,
+		"backup.json":    `{"JobName": "backup", "Extends": "base"}`,
+		"other.json":     `{"JobName": "other", "Username": "u", "Service": "s"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	jobs, errs, err := loadFileJobs(dir, path.Join(dir, "backup.json"))
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("%v %v", err, errs)
+	}
+	if len(jobs) != 1 || jobs[0].JobName != "backup" || jobs[0].MaxFailedRetries != 4 {
+		t.Errorf("Only the job of the file should be built with its template: %v", jobs)
+	}
+	if _, _, err := loadFileJobs(dir, path.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("Missing file: %v", err)
+	}
+}
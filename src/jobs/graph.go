@@ -0,0 +1,324 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//JobNames is a list of job names. In job files it can also be given as a single string
+type JobNames []string
+
+//UnmarshalJSON accepts a single name as well as a list of names
+func (names *JobNames) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		if single == "" {
+			*names = JobNames{}
+		} else {
+			*names = JobNames{single}
+		}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	if err != nil {
+		return err
+	}
+	*names = JobNames(list)
+	return nil
+}
+
+func (names JobNames) jsonSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}
+}
+
+//edge kinds of the follow-up graph
+const (
+	edgeSuccess = "success"
+	edgeFailure = "failure"
+	edgePartial = "partial"
+)
+
+//followUps returns the names of the jobs to trigger for this kind of result
+func (job *Job) followUps(kind string) JobNames {
+	switch kind {
+	case edgeSuccess:
+		return job.JobNameToTrigger
+	case edgeFailure:
+		return job.OnFailureJobs
+	case edgePartial:
+		return job.OnPartialJobs
+	}
+	return nil
+}
+
+//triggerFollowUps triggers all jobs that follow this job for this kind of result and passes them the run
+func (job *Job) triggerFollowUps(kind string, info *RunInfo) {
+	names := job.followUps(kind)
+	if len(names) <= 0 {
+		job.logger().WithFields(log.Fields{"Result": kind}).Info("No follow up job")
+		return
+	}
+	for _, name := range names {
+		toTrigger, _ := job.jobstore.FindJob(name)
+		if toTrigger == nil {
+			job.logger().WithFields(log.Fields{"NextJob": name, "Result": kind}).Warning("could not find next Job")
+			continue
+		}
+		if !job.edgeAllowed(kind, toTrigger, time.Now()) {
+			continue
+		}
+		toTrigger.sendFollowUpTrigger(info)
+	}
+}
+
+//FollowUpCondition restricts when a follow up job is triggered. All conditions that are set must be met
+type FollowUpCondition struct {
+	//only every n-th time, e.g. every 10th successful backup
+	Every int `json:"Every"`
+	//at most once per duration, e.g. "168h" or "7d"
+	MinInterval string `json:"MinInterval"`
+	//only if the follow up did not succeed for this long
+	IfLastSuccessOlderThan string `json:"IfLastSuccessOlderThan"`
+
+	minInterval time.Duration
+	olderThan   time.Duration
+}
+
+//validateConditions parses the durations of the conditions and checks that they belong to a follow up edge
+func (job *Job) validateConditions() error {
+	for name, cond := range job.FollowUpConditions {
+		if cond == nil {
+			return fmt.Errorf("FollowUpConditions for %q is empty", name)
+		}
+		if !job.JobNameToTrigger.contains(name) && !job.OnFailureJobs.contains(name) && !job.OnPartialJobs.contains(name) {
+			return fmt.Errorf("FollowUpConditions for %q, which is no follow up of this job", name)
+		}
+		if cond.Every < 0 {
+			return fmt.Errorf("FollowUpConditions for %q: Every must not be negative", name)
+		}
+		var err error
+		if cond.MinInterval != "" {
+			if cond.minInterval, err = ParseDuration(cond.MinInterval); err != nil {
+				return fmt.Errorf("FollowUpConditions for %q: MinInterval: %s", name, err.Error())
+			}
+		}
+		if cond.IfLastSuccessOlderThan != "" {
+			if cond.olderThan, err = ParseDuration(cond.IfLastSuccessOlderThan); err != nil {
+				return fmt.Errorf("FollowUpConditions for %q: IfLastSuccessOlderThan: %s", name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+//edgeAllowed counts that the edge to next had the chance to fire and checks its condition. The counters are persisted
+func (job *Job) edgeAllowed(kind string, next *Job, now time.Time) bool {
+	cond := job.FollowUpConditions[next.JobName]
+	if cond == nil {
+		return true
+	}
+	key := kind + ":" + next.JobName
+	fields := log.Fields{"NextJob": next.JobName, "Result": kind}
+
+	//the last success of the follow up is read before locking, the follow up might be this job itself
+	nextSuccess := next.lastSuccess()
+
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	defer job.saveState()
+
+	job.state.EdgeCounts[key]++
+	if cond.Every > 1 && job.state.EdgeCounts[key]%cond.Every != 0 {
+		job.logger().WithFields(fields).Info(fmt.Sprintf("Follow up skipped, runs only every %d times (now %d)", cond.Every, job.state.EdgeCounts[key]%cond.Every))
+		return false
+	}
+	if cond.minInterval > 0 {
+		if last, ok := job.state.EdgeLastFired[key]; ok && now.Sub(last) < cond.minInterval {
+			job.logger().WithFields(fields).Info("Follow up skipped, it was triggered less than " + cond.MinInterval + " ago")
+			return false
+		}
+	}
+	if cond.olderThan > 0 && now.Sub(nextSuccess) < cond.olderThan {
+		job.logger().WithFields(fields).Info("Follow up skipped, it succeeded less than " + cond.IfLastSuccessOlderThan + " ago")
+		return false
+	}
+	job.state.EdgeLastFired[key] = now
+	return true
+}
+
+//fanInComplete records that the job was triggered by source and checks if all jobs in AfterAll did trigger it since its last run
+func (job *Job) fanInComplete(source string) bool {
+	if len(job.AfterAll) == 0 {
+		return true
+	}
+	if job.fanIn == nil {
+		job.fanIn = make(map[string]bool)
+	}
+	job.fanIn[source] = true
+	missing := make([]string, 0)
+	for _, name := range job.AfterAll {
+		if !job.fanIn[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		job.logger().WithFields(log.Fields{"From": source, "Waiting": strings.Join(missing, ",")}).Info("Waiting for more jobs before running")
+		return false
+	}
+	job.fanIn = nil
+	return true
+}
+
+//GraphNode is a job in the follow-up graph with its outgoing and incoming edges
+type GraphNode struct {
+	Name          string   `json:"Name"`
+	NextJobs      []string `json:"NextJobs"`
+	OnFailureJobs []string `json:"OnFailureJobs"`
+	OnPartialJobs []string `json:"OnPartialJobs"`
+	AfterAll      []string `json:"AfterAll"`
+	TriggeredBy   []string `json:"TriggeredBy"`
+}
+
+//buildGraph creates the dependency view of the jobs
+func buildGraph(jobs []*Job) []GraphNode {
+	incoming := make(map[string][]string)
+	for _, job := range jobs {
+		for _, kind := range []string{edgeSuccess, edgeFailure, edgePartial} {
+			for _, name := range job.followUps(kind) {
+				incoming[name] = append(incoming[name], job.JobName+" ("+kind+")")
+			}
+		}
+	}
+	nodes := make([]GraphNode, 0, len(jobs))
+	for _, job := range jobs {
+		in := incoming[job.JobName]
+		sort.Strings(in)
+		nodes = append(nodes, GraphNode{
+			Name:          job.JobName,
+			NextJobs:      nonNil(job.JobNameToTrigger),
+			OnFailureJobs: nonNil(job.OnFailureJobs),
+			OnPartialJobs: nonNil(job.OnPartialJobs),
+			AfterAll:      nonNil(job.AfterAll),
+			TriggeredBy:   nonNil(in),
+		})
+	}
+	return nodes
+}
+
+func nonNil(l []string) []string {
+	if l == nil {
+		return []string{}
+	}
+	return l
+}
+
+//Graph returns the follow-up graph of the jobs in the queue
+func (queue *JobQueue) Graph() []GraphNode {
+	return buildGraph(queue.List())
+}
+
+//cycleError reports the jobs of a follow up cycle, in the order they trigger each other
+type cycleError struct {
+	jobs JobNames
+}
+
+func (err *cycleError) Error() string {
+	return fmt.Sprintf("follow up cycle: %s -> %s", strings.Join(err.jobs, " -> "), err.jobs[0])
+}
+
+//validateGraph checks the follow-up graph of the jobs. Jobs that are part of a cycle are reported and removed,
+//references to unknown jobs are only reported (the job might be added later)
+func validateGraph(jobs []*Job) ([]*Job, []error) {
+	errs := make([]error, 0)
+	byName := make(map[string]*Job, len(jobs))
+	for _, job := range jobs {
+		byName[job.JobName] = job
+	}
+
+	for _, job := range jobs {
+		for _, kind := range []string{edgeSuccess, edgeFailure, edgePartial} {
+			for _, name := range job.followUps(kind) {
+				if byName[name] == nil {
+					errs = append(errs, fmt.Errorf("%s: follow up job %q (%s) does not exist", job.JobName, name, kind))
+				}
+			}
+		}
+		for _, name := range job.AfterAll {
+			if other := byName[name]; other == nil || !other.followUps(edgeSuccess).contains(job.JobName) {
+				errs = append(errs, fmt.Errorf("%s: AfterAll job %q never triggers it with NextJob, it will never run as a follow up", job.JobName, name))
+			}
+		}
+	}
+
+	//depth first search, jobs on the stack that are reached again form a cycle
+	const (
+		unvisited = 0
+		onStack   = 1
+		done      = 2
+	)
+	state := make(map[string]int)
+	inCycle := make(map[string]bool)
+	stack := make([]string, 0)
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = onStack
+		stack = append(stack, name)
+		job := byName[name]
+		for _, kind := range []string{edgeSuccess, edgeFailure, edgePartial} {
+			for _, next := range job.followUps(kind) {
+				if byName[next] == nil {
+					continue
+				}
+				switch state[next] {
+				case unvisited:
+					visit(next)
+				case onStack:
+					cycle := make([]string, 0)
+					for i := len(stack) - 1; i >= 0; i-- {
+						cycle = append([]string{stack[i]}, cycle...)
+						inCycle[stack[i]] = true
+						if stack[i] == next {
+							break
+						}
+					}
+					errs = append(errs, &cycleError{jobs: cycle})
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+	for _, job := range jobs {
+		if state[job.JobName] == unvisited {
+			visit(job.JobName)
+		}
+	}
+
+	valid := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		if !inCycle[job.JobName] {
+			valid = append(valid, job)
+		}
+	}
+	return valid, errs
+}
+
+func (names JobNames) contains(name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
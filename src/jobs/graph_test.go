@@ -0,0 +1,151 @@
+package jobs
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextJobList(t *testing.T) {
+	job, err := loadJobFromString(t, `{"JobName": "A", "NextJob": "B"}`)
+	if err != nil || len(job.JobNameToTrigger) != 1 || job.JobNameToTrigger[0] != "B" {
+		t.Error("Single NextJob not decoded")
+	}
+	job, err = loadJobFromString(t, `{"JobName": "A", "NextJob": ["B", "C"], "OnFailureJobs": "unlock"}`)
+	if err != nil || len(job.JobNameToTrigger) != 2 || job.OnFailureJobs[0] != "unlock" {
+		t.Error("NextJob list not decoded")
+	}
+}
+
+func TestGraphCycles(t *testing.T) {
+	a := newJob()
+	a.JobName = "A"
+	a.JobNameToTrigger = JobNames{"B"}
+	b := newJob()
+	b.JobName = "B"
+	b.OnFailureJobs = JobNames{"C"}
+	c := newJob()
+	c.JobName = "C"
+	c.OnPartialJobs = JobNames{"A"}
+	d := newJob()
+	d.JobName = "D"
+	d.JobNameToTrigger = JobNames{"B"}
+
+	valid, errs := validateGraph([]*Job{a, b, c, d})
+	if len(errs) != 1 {
+		t.Errorf("Expected one cycle error, got %d", len(errs))
+	}
+	if len(valid) != 1 || valid[0] != d {
+		t.Error("Jobs in the cycle were not removed")
+	}
+
+	nodes := buildGraph([]*Job{a, b, c, d})
+	if len(nodes[1].TriggeredBy) != 2 {
+		t.Error("Incoming edges missing in the graph")
+	}
+}
+
+func TestFollowUpEdges(t *testing.T) {
+	backup1 := newJob()
+	backup1.JobName = "backup1"
+	backup1.ResticPath = "/bin/true"
+	backup1.JobNameToTrigger = JobNames{"check"}
+
+	backup2 := newJob()
+	backup2.JobName = "backup2"
+	backup2.ResticPath = "/bin/false"
+	backup2.OnFailureJobs = JobNames{"unlock"}
+
+	check := newJob()
+	check.JobName = "check"
+	check.ResticPath = "/bin/false"
+	check.MaxFailedRetries = 10
+	check.AfterAll = JobNames{"backup1", "backup2"}
+
+	unlock := newJob()
+	unlock.JobName = "unlock"
+	unlock.ResticPath = "/bin/false"
+	unlock.MaxFailedRetries = 10
+	unlock.JobNameToTrigger = JobNames{"check"}
+
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	queue.AddJobs(backup1, backup2, check, unlock)
+	defer queue.StopAllJobs()
+	time.Sleep(10 * time.Millisecond)
+
+	queue.TriggerJob("backup2")
+	time.Sleep(200 * time.Millisecond)
+	if unlock.Snapshot().CurrentRetry != 1 {
+		t.Error("OnFailureJobs not triggered")
+	}
+
+	queue.TriggerJob("backup1")
+	time.Sleep(200 * time.Millisecond)
+	if check.Snapshot().CurrentRetry != 0 {
+		t.Error("Fan in job ran before all jobs in AfterAll succeeded")
+	}
+}
+
+func TestFollowUpConditions(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	StateDir = dir
+	defer func() { StateDir = "" }()
+
+	load := func() *Job {
+		job, err := loadJobFromString(t, `{
+			"JobName": "backup",
+			"NextJob": ["forget", "prune", "check"],
+			"FollowUpConditions": {
+				"prune": {"MinInterval": "7d"},
+				"check": {"Every": 3, "IfLastSuccessOlderThan": "1h"}
+			}
+		}`)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		job.loadState()
+		return job
+	}
+	backup := load()
+	forget, prune, check := newJob(), newJob(), newJob()
+	forget.JobName, prune.JobName, check.JobName = "forget", "prune", "check"
+
+	now := time.Now()
+	if !backup.edgeAllowed(edgeSuccess, forget, now) || !backup.edgeAllowed(edgeSuccess, forget, now) {
+		t.Error("Unconditional follow up was skipped")
+	}
+	if !backup.edgeAllowed(edgeSuccess, prune, now) {
+		t.Error("First prune was skipped")
+	}
+	if backup.edgeAllowed(edgeSuccess, prune, now.Add(24*time.Hour)) {
+		t.Error("Prune ran again within MinInterval")
+	}
+
+	//simulate a restart, the counters must survive it
+	backup = load()
+	if !backup.edgeAllowed(edgeSuccess, prune, now.Add(8*24*time.Hour)) {
+		t.Error("Prune skipped after MinInterval passed")
+	}
+	if backup.edgeAllowed(edgeSuccess, check, now) || backup.edgeAllowed(edgeSuccess, check, now) {
+		t.Error("Check ran before the third success")
+	}
+	backup = load()
+	check.recordSuccess(now.Add(-10 * time.Minute))
+	if backup.edgeAllowed(edgeSuccess, check, now) {
+		t.Error("Check ran although it succeeded recently")
+	}
+	check.recordSuccess(now.Add(-2 * time.Hour))
+	for i := 0; i < 2; i++ {
+		backup.edgeAllowed(edgeSuccess, check, now)
+	}
+	if !backup.edgeAllowed(edgeSuccess, check, now) {
+		t.Error("Check skipped on the sixth success")
+	}
+
+	_, err := loadJobFromString(t, `{"JobName": "A", "NextJob": "B", "FollowUpConditions": {"C": {"Every": 2}}}`)
+	if err == nil {
+		t.Error("Condition for a job that is no follow up accepted")
+	}
+}
@@ -2,57 +2,132 @@ package jobs
 
 //Job a job to be run periodically
 import (
-	"bytes"
+	"encoding/json"
 	"io"
 	"os"
 	"os/exec"
-	"path"
-	"strconv"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/robfig/cron"
 	keyring "github.com/zalando/go-keyring"
+
+	"github.com/killingspark/restic-cronned/src/logging"
 )
 
 //Job represents one job that will be run in a Queue
 type Job struct {
+	//version of the file format the job was written in
+	SchemaVersion int `json:"SchemaVersion"`
 	//timers that get waited
 	RegularTimer       string `json:"regularTimer"`
 	regTimerSchedule   cron.Schedule
 	RetryTimer         string `json:"retryTimer"`
 	retryTimerSchedule cron.Schedule
 	//Retry counter/limit
-	CurrentRetry     int `json:"CurrentRetry"`
+	CurrentRetry     int `json:"CurrentRetry" schema:"-"`
 	MaxFailedRetries int `json:"maxFailedRetries"`
 	//statemachine status
-	Status JobStatus `json:"status"`
+	Status JobStatus `json:"status" schema:"-"`
+	//guards what the loop and its timers change and others read: Status, CurrentRetry, Progress, WaitStart, WaitEnd, LastRun and History.
+	//Outside of the package they are read with Snapshot
+	loopMutex sync.RWMutex
 	//the progress of the running restic command. not working.
-	Progress float64 `json:"progress"`
+	Progress float64 `json:"progress" schema:"-"`
 	//times set when the wait is started
-	WaitStart time.Duration `json:"WaitStart"`
-	WaitEnd   time.Duration `json:"WaitEnd"`
+	WaitStart time.Duration `json:"WaitStart" schema:"-"`
+	WaitEnd   time.Duration `json:"WaitEnd" schema:"-"`
 
 	//channels used for stopping the loop/answering to the caller
 	stop       chan bool
 	stopAnswer chan bool
+	//closed when the loop finished, so the goroutines besides the loop stop too
+	done chan struct{}
 	//channel to trigger the loop to run once
-	trigger chan TriggerType
+	trigger chan jobTrigger
+	//jobs of AfterAll that triggered this job since its last run
+	fanIn map[string]bool
+	//the run of another job that triggered the current run of this job as a follow up
+	triggeredBy *RunInfo
+	//the last run of this job
+	LastRun *RunInfo `json:"LastRun" schema:"-"`
+	//the last runs of this job including ad hoc runs, the newest last
+	History []*RunInfo `json:"History" schema:"-"`
 	//interface to the queue that lats you query for jobs. used for triggerNext
 	jobstore JobStore
+	//the file this job was loaded from and its definition after resolving templates and instances
+	sourceFile string
+	definition map[string]interface{}
 	//generic data from the config files
-	JobNameToTrigger      string `json:"NextJob"`
-	JobName               string `json:"JobName"`
-	Username              string `json:"Username"`
-	Service               string `json:"Service"`
+	JobNameToTrigger      JobNames `json:"NextJob"`
+	JobName               string   `json:"JobName" schema:"required"`
+	Username              string   `json:"Username"`
+	Service               string   `json:"Service"`
 	password              string
+	RepositoryName        string           `json:"Repository"`
 	ResticPath            string           `json:"ResticPath"`
 	ResticArguments       []string         `json:"ResticArguments"`
 	Preconditions         JobPreconditions `json:"Preconditions"`
 	CheckPrecondsEvery    int              `json:"CheckPrecondsEvery"`
 	CheckPrecondsMaxTimes int              `json:"CheckPrecondsMaxTimes"`
+	//urls of an external monitor pinged around the scheduled runs, the ones of the Repository if not set
+	Ping *Ping `json:"Ping"`
+	//recovery point objective: the newest backup must not be older than this, e.g. "26h" or "7d"
+	MaxAge string `json:"MaxAge"`
+	maxAge time.Duration
+	//take the newest backup from the snapshots in the repository instead of the last success
+	MaxAgeSnapshots *SnapshotFilter `json:"MaxAgeSnapshots"`
+	//the level of the log entries of this job, e.g. "debug" while looking into a problem. The global LogLevel if empty
+	LogLevel string `json:"LogLevel"`
+	logLevel log.Level
+
+	//templating: a job can extend a template (or any other job) and a template can be instantiated many times
+	Template      bool                `json:"Template"`
+	Extends       string              `json:"Extends"`
+	Instances     []map[string]string `json:"Instances"`
+	InstancesGlob string              `json:"InstancesGlob"`
+
+	//follow-up graph: jobs triggered on permanent failure and on partial success, and the jobs that all must have succeeded before this one runs as a follow up
+	OnFailureJobs JobNames `json:"OnFailureJobs"`
+	OnPartialJobs JobNames `json:"OnPartialJobs"`
+	AfterAll      JobNames `json:"AfterAll"`
+	//conditions for the follow ups, keyed by the name of the follow up job
+	FollowUpConditions map[string]*FollowUpCondition `json:"FollowUpConditions"`
+
+	//persisted state: counters, the last success and pause/skip
+	state *jobState
+	//a paused job keeps its schedule but drops all triggers until it is resumed
+	Paused bool `json:"Paused" schema:"-"`
+	//the next regular trigger is dropped
+	SkipNext bool `json:"SkipNext" schema:"-"`
+
+	//the running restic process, so it can be cancelled. done is closed when it exited
+	process struct {
+		sync.Mutex
+		cmd       *exec.Cmd
+		done      chan struct{}
+		cancelled bool
+	}
+	//the output of the runs in the history
+	logs struct {
+		sync.Mutex
+		runs []*RunLog
+	}
+	//counters of the runs for the metrics
+	metrics jobMetrics
+	//what the checks of the MaxAge found out
+	staleness staleState
+	//the loop shows the watchdog that it is alive
+	heartbeat heartbeat
+	//the id of the run the log entries of the job belong to, 0 between the runs
+	currentRun int32
+	//the last ping sent, the next one waits for it
+	pings struct {
+		sync.Mutex
+		last chan struct{}
+	}
 }
 
 func newJob() *Job {
@@ -61,7 +136,9 @@ func newJob() *Job {
 		Progress:   0,
 		stop:       make(chan bool),
 		stopAnswer: make(chan bool),
-		trigger:    make(chan TriggerType),
+		done:       make(chan struct{}),
+		trigger:    make(chan jobTrigger),
+		state:      newJobState(),
 	}
 }
 
@@ -69,9 +146,12 @@ func newJob() *Job {
 type JobReturn int
 
 const (
-	returnStop  JobReturn = 0
-	returnOk    JobReturn = 1
-	returnRetry JobReturn = 2
+	returnStop    JobReturn = 0
+	returnOk      JobReturn = 1
+	returnRetry   JobReturn = 2
+	returnPartial JobReturn = 3
+	//the run was cancelled from the outside, it is neither retried nor followed up
+	returnCancelled JobReturn = 4
 )
 
 //TriggerType extern triggers only followup jobs but does not retrigger himself
@@ -82,6 +162,21 @@ const (
 	triggerExtern TriggerType = 1
 )
 
+//jobTrigger is what is sent to the loop of a job. from is the run that triggered this job as a follow up,
+//timer is set if the trigger was sent by the regular or the retry timer
+type jobTrigger struct {
+	kind  TriggerType
+	from  *RunInfo
+	timer string
+	//set for a one-off run with other arguments
+	adHoc *AdHocRun
+}
+
+const (
+	timerRegular = "regular"
+	timerRetry   = "retry"
+)
+
 //JobStatus stati the jobs can be in
 type JobStatus string
 
@@ -92,84 +187,209 @@ const (
 	statusWorking JobStatus = "working"
 )
 
+//Statuses are all stati a job can be in
+var Statuses = []JobStatus{statusReady, statusWaiting, statusWorking, statusStopped}
+
+func (job *Job) setStatus(status JobStatus) {
+	job.loopMutex.Lock()
+	defer job.loopMutex.Unlock()
+	job.Status = status
+}
+
+//currentStatus reads the status, the loop may change it meanwhile
+func (job *Job) currentStatus() JobStatus {
+	job.loopMutex.RLock()
+	defer job.loopMutex.RUnlock()
+	return job.Status
+}
+
+func (job *Job) setRetry(retry int) {
+	job.loopMutex.Lock()
+	defer job.loopMutex.Unlock()
+	job.CurrentRetry = retry
+}
+
+//setProgress returns the progress it replaces
+func (job *Job) setProgress(progress float64) float64 {
+	job.loopMutex.Lock()
+	defer job.loopMutex.Unlock()
+	previous := job.Progress
+	job.Progress = progress
+	return previous
+}
+
+//JobSnapshot is the state of a job at one moment
+type JobSnapshot struct {
+	Status       JobStatus
+	Paused       bool
+	SkipNext     bool
+	CurrentRetry int
+	Progress     float64
+	//the scheduled trigger in nanoseconds since the epoch
+	WaitStart time.Duration
+	WaitEnd   time.Duration
+	LastRun   *RunInfo
+	//the newest last
+	History []*RunInfo
+}
+
+//Snapshot takes the state the loop keeps changing under the locks of the job. The runs are not changed after they
+//were recorded and can be shared
+func (job *Job) Snapshot() JobSnapshot {
+	job.loopMutex.RLock()
+	snapshot := JobSnapshot{
+		Status:       job.Status,
+		CurrentRetry: job.CurrentRetry,
+		Progress:     job.Progress,
+		WaitStart:    job.WaitStart,
+		WaitEnd:      job.WaitEnd,
+		LastRun:      job.LastRun,
+		History:      make([]*RunInfo, len(job.History)),
+	}
+	copy(snapshot.History, job.History)
+	job.loopMutex.RUnlock()
+
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	snapshot.Paused = job.Paused
+	snapshot.SkipNext = job.SkipNext
+	return snapshot
+}
+
+//MarshalJSON encodes the job with the fields the loop changes taken from a snapshot
+func (job *Job) MarshalJSON() ([]byte, error) {
+	type plain Job
+	snapshot := job.Snapshot()
+	return json.Marshal(struct {
+		*plain
+		Status       JobStatus     `json:"status"`
+		Paused       bool          `json:"Paused"`
+		SkipNext     bool          `json:"SkipNext"`
+		CurrentRetry int           `json:"CurrentRetry"`
+		Progress     float64       `json:"progress"`
+		WaitStart    time.Duration `json:"WaitStart"`
+		WaitEnd      time.Duration `json:"WaitEnd"`
+		LastRun      *RunInfo      `json:"LastRun"`
+		History      []*RunInfo    `json:"History"`
+	}{(*plain)(job), snapshot.Status, snapshot.Paused, snapshot.SkipNext, snapshot.CurrentRetry,
+		snapshot.Progress, snapshot.WaitStart, snapshot.WaitEnd, snapshot.LastRun, snapshot.History})
+}
+
 func (job *Job) retrieveAndStorePassword() {
-	key, err := keyring.Get(job.Service, job.Username)
+	service, username := job.Service, job.Username
+	if repo := job.repository(); repo != nil && service == "" && username == "" {
+		service, username = repo.Service, repo.Username
+	}
+	key, err := keyring.Get(service, username)
 	if err != nil {
-		log.WithFields(log.Fields{"Job": job.JobName}).Warning("couldn't retrieve password.")
+		job.logger().Warning("couldn't retrieve password.")
 	} else {
-		log.WithFields(log.Fields{"Job": job.JobName}).Info("retrieved password.")
+		job.logger().Info("retrieved password.")
 		job.password = key
 	}
 }
 
 //SendTrigger makes the job  run immediatly (if waiting or immediatly again if working right now)
 func (job *Job) SendTrigger(trigType TriggerType) {
-	if job.Status == statusWaiting || job.Status == statusWorking {
-		log.WithFields(log.Fields{"Job": job.JobName}).Info("Trigger try")
-		job.trigger <- trigType
+	job.sendTrigger(jobTrigger{kind: trigType})
+}
+
+//sendFollowUpTrigger triggers the job as the follow up of the run from
+func (job *Job) sendFollowUpTrigger(from *RunInfo) {
+	job.sendTrigger(jobTrigger{kind: triggerIntern, from: from})
+}
+
+//sendTrigger returns false if the job was not running and the trigger was not sent
+func (job *Job) sendTrigger(trig jobTrigger) bool {
+	if status := job.currentStatus(); status == statusWaiting || status == statusWorking {
+		job.logger().Info("Trigger try")
+		job.trigger <- trig
+		return true
 	}
+	return false
 }
 
 //SendTriggerWithDelay makes the job run after "dur" nanoseconds
 func (job *Job) SendTriggerWithDelay(dur time.Duration) {
+	job.sendTriggerWithDelay(dur, jobTrigger{kind: triggerIntern})
+}
+
+//scheduleRegularTrigger makes the job run at the next time of the regular timer
+func (job *Job) scheduleRegularTrigger() {
+	job.sendTriggerWithDelay(job.durationTillNextRegularTrigger(), jobTrigger{kind: triggerIntern, timer: timerRegular})
+}
+
+func (job *Job) sendTriggerWithDelay(dur time.Duration, trig jobTrigger) {
 	if dur < 0 {
 		//ignore for example jobs that shouldnt be run
-		log.WithFields(log.Fields{"Job": job.JobName, "Duration": dur}).Info("Ignore trigger with negative duration")
+		job.logger().WithFields(log.Fields{"Duration": dur}).Info("Ignore trigger with negative duration")
 		return
 	}
 	if dur > 0 {
 		//for frontends
+		job.loopMutex.Lock()
 		job.WaitStart = time.Duration(time.Now().UnixNano())
 		job.WaitEnd = job.WaitStart + dur
+		job.loopMutex.Unlock()
 
 		sleepUntil := time.Now().Add(dur).Round(0)
 
-		log.WithFields(log.Fields{"Job": job.JobName, "Time": sleepUntil.String()}).Info("Trigger scheduled")
+		job.logger().WithFields(log.Fields{"Time": sleepUntil.String()}).Info("Trigger scheduled")
+		//retries have their own event
+		if trig.timer != timerRetry {
+			job.emit(Event{Type: EventTriggerScheduled, Trigger: triggerName(trig), At: sleepUntil})
+		}
 
 		for time.Now().Round(0).Before(sleepUntil) {
 			time.Sleep(10 * time.Second)
 		}
 	}
-	job.SendTrigger(triggerIntern)
-}
-
-func (job *Job) triggerNextJob() {
-	if len(job.JobNameToTrigger) <= 0 {
-		log.WithFields(log.Fields{"Job": job.JobName}).Info("No follow up job")
-		return
-	}
-	toTrigger, _ := job.jobstore.FindJob(job.JobNameToTrigger)
-	if toTrigger != nil {
-		toTrigger.SendTrigger(triggerIntern)
-	} else {
-		log.WithFields(log.Fields{"Job": job.JobName, "NextJob": job.JobNameToTrigger}).Warning("could not find next Job")
-	}
+	job.sendTrigger(trig)
 }
 
 func (job *Job) loop(finishCallback func()) {
 	defer job.finish(finishCallback)
 	for {
 		var retrigger = false
-		job.Status = statusWaiting
-		log.WithFields(log.Fields{"Job": job.JobName}).Info("Await trigger/stop")
-		select {
-		case trigType := <-job.trigger:
-			log.WithFields(log.Fields{"Job": job.JobName}).Info("Trigger received")
-			switch trigType {
-			case triggerIntern:
-				retrigger = true
-			case triggerExtern:
-				retrigger = false
-			}
-		case <-job.stop:
+		job.setStatus(statusWaiting)
+		job.setCurrentRun(0)
+		job.logger().Info("Await trigger/stop")
+		trig, ok := job.nextTrigger()
+		if !ok {
+			//before the answer, so the event comes before anything the caller does next
+			job.emit(Event{Type: EventJobStopped})
 			job.stopAnswer <- true
 			return
 		}
+		job.logger().Info("Trigger received")
+		job.emit(Event{Type: EventTriggerReceived, Trigger: triggerName(trig)})
+		if trig.adHoc != nil {
+			job.beat(true)
+			job.runAdHoc(trig.adHoc)
+			continue
+		}
+		switch trig.kind {
+		case triggerIntern:
+			retrigger = true
+		case triggerExtern:
+			retrigger = false
+		}
+		if job.dropTrigger(trig) {
+			continue
+		}
+		if trig.from != nil && !job.fanInComplete(trig.from.Job) {
+			continue
+		}
+		job.triggeredBy = trig.from
 
+		//the preconditions and the run may take long, the watchdog must not take that for a hang
+		job.beat(true)
 		if job.CheckPrecondsMaxTimes > 0 {
+			job.emit(Event{Type: EventPreconditionsChecking})
 			preconds := false
 			for i := 0; !preconds && i < job.CheckPrecondsMaxTimes; i++ {
-				preconds = job.Preconditions.CheckAll()
+				expanded := job.expandedPreconditions(time.Now())
+				preconds = expanded.CheckAll()
 				if !preconds {
 					time.Sleep(time.Duration(job.CheckPrecondsEvery) * time.Second)
 				}
@@ -180,20 +400,32 @@ func (job *Job) loop(finishCallback func()) {
 			}
 		}
 
-		result := job.run()
+		job.pingStart()
+		result := job.run(nil)
+		//restic finished, handling the result must not block. A loop that hangs from here on is no longer taken for busy
+		job.beat(false)
+		info := job.LastRun
+		job.pingFinished(info)
 		switch result {
 		case returnRetry:
 			if job.CurrentRetry < job.MaxFailedRetries {
 				job.retry()
 			} else {
-				job.fail()
+				job.fail(info)
 			}
 			break
 		case returnOk:
-			job.success(retrigger)
+			job.success(retrigger, info)
+			break
+		case returnPartial:
+			job.partial(retrigger, info)
+			break
+		case returnCancelled:
+			job.cancelledRun(retrigger)
 			break
 		case returnStop:
-			job.fail()
+			job.fail(info)
+			job.emit(Event{Type: EventJobStopped})
 			return
 		}
 	}
@@ -201,15 +433,19 @@ func (job *Job) loop(finishCallback func()) {
 
 func (job *Job) start(store JobStore, finishCallback func()) {
 	job.jobstore = store
+	job.loadState()
+	logging.AddJob(job.JobName)
+	if job.LogLevel != "" {
+		logging.SetJobLevel(job.JobName, job.logLevel)
+	} else {
+		logging.ResetJobLevel(job.JobName)
+	}
+	//a restarted job needs a new one, the old one was closed when it stopped
+	job.done = make(chan struct{})
+	job.setStatus(statusWaiting)
 	go job.loop(finishCallback)
-	job.Status = statusWaiting
-	go job.SendTriggerWithDelay(job.durationTillNextRegularTrigger())
-}
-
-var jobTriggerPersistDir = path.Join(os.ExpandEnv("$HOME"), ".local/share/restic-cronned")
-
-type persistedJobTrigger struct {
-	NextTrigger time.Time
+	go job.scheduleRegularTrigger()
+	go job.watchStale(job.done)
 }
 
 func (job *Job) durationTillNextRegularTrigger() time.Duration {
@@ -231,103 +467,155 @@ func (job *Job) durationTillNextRetryTrigger() time.Duration {
 }
 
 func (job *Job) retry() {
-	log.WithFields(log.Fields{"Job": job.JobName, "Retries": job.CurrentRetry}).Info("Start next retry")
-	job.CurrentRetry++
-	go job.SendTriggerWithDelay(job.durationTillNextRetryTrigger())
+	job.logger().WithFields(log.Fields{"Retries": job.CurrentRetry}).Info("Start next retry")
+	job.setRetry(job.CurrentRetry + 1)
+	dur := job.durationTillNextRetryTrigger()
+	if dur >= 0 {
+		job.emit(Event{Type: EventRetryScheduled, Retry: job.CurrentRetry, At: time.Now().Add(dur)})
+	}
+	go job.sendTriggerWithDelay(dur, jobTrigger{kind: triggerIntern, timer: timerRetry})
+}
+
+func (job *Job) success(retrigger bool, info *RunInfo) {
+	job.logger().WithFields(log.Fields{"Retries": job.CurrentRetry}).Info("successful")
+	job.setRetry(0)
+	job.recordSuccess(info.Finished)
+
+	if retrigger {
+		if job.regTimerSchedule != nil {
+			go job.scheduleRegularTrigger()
+		}
+	}
+
+	go job.triggerFollowUps(edgeSuccess, info)
 }
 
-func (job *Job) success(retrigger bool) {
-	log.WithFields(log.Fields{"Job": job.JobName, "Retries": job.CurrentRetry}).Info("successful")
-	job.CurrentRetry = 0
+//partial is called when restic could create the snapshot but not read all files. Retrying would most likely not help
+func (job *Job) partial(retrigger bool, info *RunInfo) {
+	job.logger().WithFields(log.Fields{"Retries": job.CurrentRetry}).Warning("partially successful")
+	job.setRetry(0)
 
 	if retrigger {
 		if job.regTimerSchedule != nil {
-			go job.SendTriggerWithDelay(job.durationTillNextRegularTrigger())
+			go job.scheduleRegularTrigger()
 		}
 	}
 
-	go job.triggerNextJob()
+	go job.triggerFollowUps(edgePartial, info)
+}
+
+//cancelledRun is called when the run was cancelled. The job waits for its next regular trigger
+func (job *Job) cancelledRun(retrigger bool) {
+	job.logger().Warning("Cancelled")
+	job.setRetry(0)
+
+	if retrigger {
+		if job.regTimerSchedule != nil {
+			go job.scheduleRegularTrigger()
+		}
+	}
 }
 
-//Stop stops a job it will exit after if has finished if currently running (this may take a while!) or exit immediatly if waiting
+//Stop stops a job it will exit after if has finished if currently running (this may take a while!) or exit immediatly if waiting.
+//Use Cancel to end the running command first
 func (job *Job) Stop() {
-	log.WithFields(log.Fields{"Job": job.JobName}).Info("Stopped externally")
+	job.logger().Info("Stopped externally")
 	job.stop <- true
 	<-job.stopAnswer
 }
 
-func (job *Job) fail() {
-	log.WithFields(log.Fields{"Job": job.JobName, "Retries": job.CurrentRetry}).Error("Failed. Will try again at next regular trigger")
+func (job *Job) fail(info *RunInfo) {
+	job.logger().WithFields(log.Fields{"Retries": job.CurrentRetry}).Error("Failed. Will try again at next regular trigger")
+	failed := Event{Type: EventJobFailed, Retry: job.CurrentRetry}
+	if info != nil {
+		run := *info
+		failed.Run = &run
+	}
+	job.emit(failed)
+	go job.triggerFollowUps(edgeFailure, info)
 }
 
 func (job *Job) failPreconds() {
-	log.WithFields(log.Fields{"Job": job.JobName}).Error("Failed Preconditions. Will try again at next regular trigger")
-	go job.SendTriggerWithDelay(job.durationTillNextRegularTrigger())
+	job.logger().Error("Failed Preconditions. Will try again at next regular trigger")
+	job.emit(Event{Type: EventPreconditionsFailed})
+	job.recordPreconditionFailure()
+	go job.scheduleRegularTrigger()
 }
 
 func (job *Job) finish(finishCallback func()) {
-	log.WithFields(log.Fields{"Job": job.JobName}).Error("Finished")
-	job.Status = statusStopped
+	job.logger().Error("Finished")
+	job.setStatus(statusStopped)
+	close(job.done)
 	finishCallback()
 }
 
-//reads the output from the command, extracts the percentage that is finished and updates the job accordingly
-//doesnt work currently because restic does some fancy printing that wont be catched outside a terminal
-func (job *Job) updateStatus(stdout io.ReadCloser) {
-	defer stdout.Close()
-	buf := make([]byte, 256)
-	var n, err = stdout.Read(buf)
-	for !(n == 0 && err != nil) {
-		var chunk = string(buf)
-		chunk = strings.Replace(chunk, "\n", " ", -1)
-		chunk = strings.Replace(chunk, "\t", " ", -1)
-		chunk = strings.Replace(chunk, "\000", " ", 1)
-		toks := strings.Split(chunk, " ")
-		for _, token := range toks {
-			if strings.HasSuffix(token, "%") {
-				val, err := strconv.ParseFloat(token[:len(token)-1], 32)
-				if err == nil {
-					job.Progress = val
-				}
-			}
-		}
-		n, err = stdout.Read(buf)
-	}
-}
-
 func (job *Job) getRepo() string {
 	var repo string
 	for idx, arg := range job.ResticArguments {
-		if arg == "-r" && len(job.ResticArguments) > idx+2 {
+		if arg == "-r" && len(job.ResticArguments) > idx+1 {
 			repo = job.ResticArguments[idx+1]
 		}
 	}
 	return repo
 }
 
-func (job *Job) run() JobReturn {
-	job.Status = statusWorking
-	defer func() { job.Status = statusWaiting }()
+//run runs restic once. adHoc is nil for the runs of the schedule and the follow ups
+func (job *Job) run(adHoc *AdHocRun) JobReturn {
+	job.setStatus(statusWorking)
+	defer job.setStatus(statusWaiting)
 
-	var cmd *exec.Cmd
-	if len(job.ResticPath) > 0 {
-		cmd = exec.Command(job.ResticPath, job.ResticArguments...)
-	} else {
-		cmd = exec.Command("restic", job.ResticArguments...)
+	//jobs on the same repository would only fight over the repository lock
+	if locker, ok := job.jobstore.(RepoLocker); ok {
+		unlock := locker.LockRepo(job.repositoryKey())
+		defer unlock()
 	}
 
-	cmd.Env = append(os.Environ(), "RESTIC_PASSWORD="+job.password)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	info := &RunInfo{ID: job.nextRunID(), Job: job.JobName, Started: time.Now(), AdHoc: adHoc != nil}
+	job.setCurrentRun(info.ID)
+	job.setProgress(0)
+
+	resticArgs := job.ResticArguments
+	if adHoc != nil {
+		resticArgs = adHoc.arguments(job.ResticArguments)
+	}
+	binary, args, env := job.command(resticArgs)
+	cmd := exec.Command(binary, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, job.triggerEnvironment()...)
+	//both streams go line by line into the log of the run, the last line of stderr is logged if restic fails
+	runLog := job.startRunLog(info.ID, info.Started)
+	var lastError string
+	stderr := &lineWriter{fn: func(line string) {
+		runLog.add(line)
+		lastError = line
+	}}
+	cmd.Stderr = stderr
+	//the progress and the snapshot id are read from the output. The progress is only available with --json
+	stdout := &lineWriter{fn: func(line string) {
+		runLog.add(line)
+		job.parseOutputLine(line, info)
+	}}
+	cmd.Stdout = stdout
+	if adHoc != nil && adHoc.Output != nil {
+		client := &clientWriter{wr: adHoc.Output}
+		cmd.Stdout = io.MultiWriter(stdout, client)
+		cmd.Stderr = io.MultiWriter(stderr, client)
+	}
 
-	//doesnt work right now, see comment on updateStatus
-	//stdout, err := cmd.StdoutPipe()
-	//if err == nil {
-	//	go job.updateStatus(stdout)
-	//}
-	log.WithFields(log.Fields{"Job": job.JobName}).Info("Run restic")
-	err := cmd.Run()
-	log.WithFields(log.Fields{"Job": job.JobName}).Info("Finished running restic")
+	job.logger().Info("Run restic")
+	job.emitRun(EventRunStarted, info)
+	err := cmd.Start()
+	if err == nil {
+		done := job.setProcess(cmd)
+		err = cmd.Wait()
+		close(done)
+	}
+	cancelled := job.clearProcess()
+	stdout.Flush()
+	stderr.Flush()
+	runLog.finish()
+	job.pruneLogFiles()
+	job.logger().Info("Finished running restic")
 
 	var exitCode = 0
 
@@ -344,13 +632,34 @@ func (job *Job) run() JobReturn {
 			exitCode = 1
 		}
 
-		log.WithFields(log.Fields{"Job": job.JobName, "error": err.Error(), "message": stderr.String()}).Warning("error")
+		job.logger().WithFields(log.Fields{"error": err.Error(), "message": lastError, "Log": runLog.Path()}).Warning("error")
 	}
 
-	switch exitCode {
-	case 0:
-		return returnOk //everything fine
+	var ret JobReturn
+	switch {
+	case cancelled:
+		ret = returnCancelled //killed by Cancel, the exit code does not matter
+	case exitCode == 0:
+		ret = returnOk //everything fine
+	case exitCode == 3:
+		ret = returnPartial //snapshot created but some files could not be read
 	default:
-		return returnRetry //not fine but retryable
+		ret = returnRetry //not fine but retryable
+	}
+
+	info.ExitCode = exitCode
+	info.Result = resultOf(ret)
+	info.Finished = time.Now()
+	job.recordRun(info)
+	job.recheckSnapshots()
+	job.emitRun(EventRunFinished, info)
+	if adHoc != nil {
+		adHoc.info = info
+	} else {
+		job.recordMetrics(info)
+		job.loopMutex.Lock()
+		job.LastRun = info
+		job.loopMutex.Unlock()
 	}
+	return ret
 }
@@ -32,15 +32,15 @@ func (store TestStore) FindJob(name string) (*Job, int) {
 func (suite *goTestSuite) SetupSuite() {
 	suite.job1 = newJob()
 	suite.job1.JobName = "A"
-	suite.job1.JobNameToTrigger = "B"
-	suite.job1.RegularTimer = -1
-	suite.job1.RetryTimer = -1
+	suite.job1.JobNameToTrigger = JobNames{"B"}
+	suite.job1.RegularTimer = ""
+	suite.job1.RetryTimer = ""
 
 	suite.job2 = newJob()
 	suite.job2.JobName = "B"
 	suite.job2.ResticArguments = []string{"aösdfhasödlk", "asdfwerrwefosdf"}
-	suite.job2.RegularTimer = -1
-	suite.job2.RetryTimer = -1
+	suite.job2.RegularTimer = ""
+	suite.job2.RetryTimer = ""
 	suite.job2.MaxFailedRetries = 2
 
 	suite.store = TestStore{[]*Job{suite.job1, suite.job2}}
@@ -57,17 +57,17 @@ func (suite *goTestSuite) TearDownTest() {
 }
 
 func (suite *goTestSuite) TestStati() {
-	if suite.job1.Status != statusReady {
+	if suite.job1.currentStatus() != statusReady {
 		suite.test.Error("Wrong state, should be ready")
 	}
 	suite.wg.Add(1)
 	suite.job1.start(suite.store, func() { suite.wg.Done() })
 	time.Sleep(1 * time.Millisecond)
-	if suite.job1.Status != statusWaiting {
+	if suite.job1.currentStatus() != statusWaiting {
 		suite.test.Error("Wrong state, should be working|waiting")
 	}
 	suite.job1.Stop()
-	if suite.job1.Status != statusStopped {
+	if suite.job1.currentStatus() != statusStopped {
 		suite.test.Error("Wrong state, should be stopped")
 	}
 	var b = true
@@ -85,7 +85,7 @@ func (suite *goTestSuite) TestFollowupTrigger() {
 	time.Sleep(100 * time.Millisecond)
 	suite.job1.SendTrigger(triggerIntern)
 	time.Sleep(100 * time.Millisecond)
-	if suite.job2.CurrentRetry != 1 {
+	if suite.job2.Snapshot().CurrentRetry != 1 {
 		suite.test.Error("job2 wasnt triggered")
 	}
 }
@@ -97,20 +97,20 @@ func (suite *goTestSuite) TestFail() {
 	time.Sleep(100 * time.Millisecond)
 	suite.job2.SendTrigger(triggerIntern)
 	time.Sleep(100 * time.Millisecond)
-	if suite.job2.CurrentRetry != 1 {
+	if suite.job2.Snapshot().CurrentRetry != 1 {
 		suite.test.Error("didnt record failed try")
 	}
 
 	suite.job2.SendTrigger(triggerIntern)
 	time.Sleep(100 * time.Millisecond)
-	if suite.job2.CurrentRetry != 2 {
+	if suite.job2.Snapshot().CurrentRetry != 2 {
 		suite.test.Error("didnt record failed try")
 	}
 
 	suite.job2.SendTrigger(triggerIntern)
 	time.Sleep(100 * time.Millisecond)
-	if suite.job2.Status != statusStopped {
-		suite.test.Error("didnt stop after max fails" + suite.job2.Status)
+	if suite.job2.currentStatus() != statusStopped {
+		suite.test.Error("didnt stop after max fails" + suite.job2.currentStatus())
 	}
 
 	var b = true
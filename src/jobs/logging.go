@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//logger returns the entry the job logs with. From the start of a run until the job waits for the next trigger the
+//entries carry the id of the run, so one run can be picked out of the log of jobs running at the same time
+func (job *Job) logger() *log.Entry {
+	fields := log.Fields{"Job": job.JobName}
+	if run := atomic.LoadInt32(&job.currentRun); run != 0 {
+		fields["Run"] = int(run)
+	}
+	return log.WithFields(fields)
+}
+
+//setCurrentRun sets the run the log entries of the job belong to, 0 when no run is going on
+func (job *Job) setCurrentRun(id int) {
+	atomic.StoreInt32(&job.currentRun, int32(id))
+}
@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/killingspark/restic-cronned/src/logging"
+)
+
+//entryHook keeps the log entries of one job
+type entryHook struct {
+	sync.Mutex
+	job     string
+	entries []*log.Entry
+}
+
+func (hook *entryHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (hook *entryHook) Fire(entry *log.Entry) error {
+	if entry.Data["Job"] == hook.job {
+		hook.Lock()
+		hook.entries = append(hook.entries, entry)
+		hook.Unlock()
+	}
+	return nil
+}
+
+func TestRunIDInLog(t *testing.T) {
+	hook := &entryHook{job: "R"}
+	oldHooks := log.StandardLogger().ReplaceHooks(log.LevelHooks{})
+	defer log.StandardLogger().ReplaceHooks(oldHooks)
+	log.AddHook(hook)
+
+	job := newJob()
+	job.JobName = "R"
+	job.ResticPath = "/bin/false"
+	job.run(nil)
+	job.run(nil)
+	job.logger().Info("Retry")
+	job.setCurrentRun(0)
+	job.logger().Info("Await trigger")
+
+	runs := map[string][]interface{}{}
+	for _, entry := range hook.entries {
+		runs[entry.Message] = append(runs[entry.Message], entry.Data["Run"])
+	}
+	if started := runs["Run restic"]; len(started) != 2 || started[0] != 1 || started[1] != 2 {
+		t.Errorf("Wrong runs of the starts %v", started)
+	}
+	if failed := runs["error"]; len(failed) != 2 || failed[1] != 2 {
+		t.Errorf("Wrong runs of the errors %v", failed)
+	}
+	if retry := runs["Retry"]; len(retry) != 1 || retry[0] != 2 {
+		t.Errorf("The handling of the result is not part of the run %v", retry)
+	}
+	if waiting := runs["Await trigger"]; len(waiting) != 1 || waiting[0] != nil {
+		t.Errorf("Run between the runs %v", waiting)
+	}
+}
+
+func TestRemovedJobLevel(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{"noisy.json": `{"JobName": "noisy", "ResticPath": "/bin/true", "LogLevel": "debug"}`})
+	defer os.RemoveAll(dir)
+	queue, err := NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	logging.SetLevel(log.InfoLevel)
+	queue.StartQueue()
+	defer queue.StopAllJobs()
+	if log.GetLevel() != log.DebugLevel {
+		t.Fatal("LogLevel of the job not set")
+	}
+	queue.RemoveJob("noisy")
+	if log.GetLevel() != log.InfoLevel {
+		t.Error("The level of the removed job is still used")
+	}
+}
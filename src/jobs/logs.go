@@ -0,0 +1,235 @@
+package jobs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//LogDir is the directory the output of the runs is written to, one file per run in a directory per job.
+//No files are written if it is empty
+var LogDir = ""
+
+//LogFilesKept is the number of log files kept per job, the oldest are deleted
+var LogFilesKept = 100
+
+//runLogLines is the number of lines of a run that are kept in memory
+const runLogLines = 1000
+
+//outputTailSize is the number of lines Output returns
+const outputTailSize = 200
+
+//RunLog is what restic printed on stdout and stderr during one run. The last lines are kept in memory,
+//all lines are written to the log file of the run
+type RunLog struct {
+	RunID   int
+	Started time.Time
+
+	mutex sync.Mutex
+	//ring of the last lines, line n is at n % runLogLines
+	lines []string
+	total int
+	done  bool
+	file  *os.File
+	path  string
+	//closed and replaced when a line is added or the run ends, followers wait on it
+	changed chan struct{}
+}
+
+func (job *Job) logDir() string {
+	if LogDir == "" {
+		return ""
+	}
+	return path.Join(LogDir, job.JobName)
+}
+
+//LogFile returns the log file of the run, or "" if there is none
+func (job *Job) LogFile(id int) string {
+	dir := job.logDir()
+	if dir == "" {
+		return ""
+	}
+	file := path.Join(dir, strconv.Itoa(id)+".log")
+	if _, err := os.Stat(file); err != nil {
+		return ""
+	}
+	return file
+}
+
+//logFileIDs returns the ids of the runs that have a log file, the oldest first
+func (job *Job) logFileIDs() []int {
+	dir := job.logDir()
+	if dir == "" {
+		return nil
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	ids := make([]int, 0, len(infos))
+	for _, info := range infos {
+		if id, err := strconv.Atoi(strings.TrimSuffix(info.Name(), ".log")); err == nil && strings.HasSuffix(info.Name(), ".log") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+//pruneLogFiles deletes the oldest log files of the job so only LogFilesKept remain
+func (job *Job) pruneLogFiles() {
+	ids := job.logFileIDs()
+	for len(ids) > LogFilesKept && LogFilesKept > 0 {
+		file := path.Join(job.logDir(), strconv.Itoa(ids[0])+".log")
+		if err := os.Remove(file); err != nil {
+			job.logger().WithFields(log.Fields{"File": file, "Error": err.Error()}).Warning("Could not delete log file")
+		}
+		ids = ids[1:]
+	}
+}
+
+//nextRunID counts the runs of the job. Without persisted state it continues after the newest log file
+func (job *Job) nextRunID() int {
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	if job.state.LastRunID == 0 {
+		if ids := job.logFileIDs(); len(ids) > 0 {
+			job.state.LastRunID = ids[len(ids)-1]
+		}
+	}
+	job.state.LastRunID++
+	job.saveState()
+	return job.state.LastRunID
+}
+
+//startRunLog creates the log of a new run. The logs of the runs in the history are kept
+func (job *Job) startRunLog(id int, started time.Time) *RunLog {
+	runLog := &RunLog{RunID: id, Started: started, changed: make(chan struct{})}
+	if dir := job.logDir(); dir != "" {
+		file := path.Join(dir, strconv.Itoa(id)+".log")
+		err := os.MkdirAll(dir, 0700)
+		if err == nil {
+			runLog.file, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		}
+		if err != nil {
+			job.logger().WithFields(log.Fields{"File": file, "Error": err.Error()}).Warning("Could not create log file")
+		} else {
+			runLog.path = file
+		}
+	}
+
+	job.logs.Lock()
+	defer job.logs.Unlock()
+	job.logs.runs = append(job.logs.runs, runLog)
+	if len(job.logs.runs) > historySize {
+		job.logs.runs = job.logs.runs[len(job.logs.runs)-historySize:]
+	}
+	return runLog
+}
+
+//RunLog returns the log of the run if it is still kept in memory
+func (job *Job) RunLog(id int) *RunLog {
+	job.logs.Lock()
+	defer job.logs.Unlock()
+	for _, runLog := range job.logs.runs {
+		if runLog.RunID == id {
+			return runLog
+		}
+	}
+	return nil
+}
+
+//LatestRunLog returns the log of the current or the last run, nil if the job did not run yet
+func (job *Job) LatestRunLog() *RunLog {
+	job.logs.Lock()
+	defer job.logs.Unlock()
+	if len(job.logs.runs) == 0 {
+		return nil
+	}
+	return job.logs.runs[len(job.logs.runs)-1]
+}
+
+func (runLog *RunLog) add(line string) {
+	runLog.mutex.Lock()
+	defer runLog.mutex.Unlock()
+	if runLog.lines == nil {
+		runLog.lines = make([]string, runLogLines)
+	}
+	runLog.lines[runLog.total%runLogLines] = line
+	runLog.total++
+	if runLog.file != nil {
+		fmt.Fprintln(runLog.file, line)
+	}
+	close(runLog.changed)
+	runLog.changed = make(chan struct{})
+}
+
+//finish closes the log file and wakes up the followers a last time
+func (runLog *RunLog) finish() {
+	runLog.mutex.Lock()
+	defer runLog.mutex.Unlock()
+	runLog.done = true
+	if runLog.file != nil {
+		runLog.file.Close()
+		runLog.file = nil
+	}
+	close(runLog.changed)
+	runLog.changed = make(chan struct{})
+}
+
+//Path is the log file of the run, "" if there is none
+func (runLog *RunLog) Path() string {
+	return runLog.path
+}
+
+//Lines returns the lines from line number from on that are still in memory and the number of the next line.
+//skipped is the number of lines that were already dropped from memory. If done is false, changed is closed
+//as soon as there is more
+func (runLog *RunLog) Lines(from int) (lines []string, next, skipped int, done bool, changed <-chan struct{}) {
+	runLog.mutex.Lock()
+	defer runLog.mutex.Unlock()
+	if first := runLog.total - runLogLines; from < first {
+		skipped = first - from
+		from = first
+	}
+	for n := from; n < runLog.total; n++ {
+		lines = append(lines, runLog.lines[n%runLogLines])
+	}
+	return lines, runLog.total, skipped, runLog.done, runLog.changed
+}
+
+//JobOutput is the tail of the output of the current or the last run
+type JobOutput struct {
+	RunID   int
+	Running bool
+	Started time.Time
+	Lines   []string
+}
+
+//Output returns the last lines of the output of the current run, or of the last run if the job is not running
+func (job *Job) Output() JobOutput {
+	runLog := job.LatestRunLog()
+	if runLog == nil {
+		return JobOutput{Lines: []string{}}
+	}
+	runLog.mutex.Lock()
+	total := runLog.total
+	runLog.mutex.Unlock()
+	from := total - outputTailSize
+	if from < 0 {
+		from = 0
+	}
+	lines, _, _, done, _ := runLog.Lines(from)
+	if lines == nil {
+		lines = []string{}
+	}
+	return JobOutput{RunID: runLog.RunID, Running: !done, Started: runLog.Started, Lines: lines}
+}
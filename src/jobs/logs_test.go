@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+func TestRunLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-logs")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	oldDir, oldKept := LogDir, LogFilesKept
+	LogDir, LogFilesKept = dir, 2
+	defer func() { LogDir, LogFilesKept = oldDir, oldKept }()
+
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/sh"
+	job.ResticArguments = []string{"-c", "echo out; echo err >&2"}
+	for i := 0; i < 3; i++ {
+		job.run(nil)
+	}
+
+	if job.LastRun.ID != 3 {
+		t.Errorf("Wrong run id %d", job.LastRun.ID)
+	}
+	out := job.Output()
+	if out.Running || out.RunID != 3 || len(out.Lines) != 2 {
+		t.Errorf("Wrong output: %v", out)
+	}
+	content, err := ioutil.ReadFile(path.Join(dir, "A", "3.log"))
+	if err != nil || string(content) != "out\nerr\n" && string(content) != "err\nout\n" {
+		t.Errorf("Wrong log file: %q", content)
+	}
+	if job.LogFile(1) != "" || job.LogFile(2) == "" {
+		t.Error("Old log files not pruned")
+	}
+
+	//without state the ids continue after the newest log file
+	again := newJob()
+	again.JobName = "A"
+	if again.nextRunID() != 4 {
+		t.Error("Run id not continued")
+	}
+}
+
+func TestRunLogLines(t *testing.T) {
+	runLog := &RunLog{changed: make(chan struct{})}
+	lines, next, skipped, done, changed := runLog.Lines(0)
+	if len(lines) != 0 || next != 0 || done {
+		t.Error("Empty log has lines")
+	}
+	for i := 0; i < runLogLines+10; i++ {
+		runLog.add(strconv.Itoa(i))
+	}
+	select {
+	case <-changed:
+	default:
+		t.Error("Followers not woken up")
+	}
+
+	lines, next, skipped, _, _ = runLog.Lines(5)
+	if skipped != 5 || lines[0] != "10" || next != runLogLines+10 || len(lines) != runLogLines {
+		t.Errorf("Ring buffer wrong: %d skipped, first %s", skipped, lines[0])
+	}
+	runLog.finish()
+	if lines, _, _, done, _ = runLog.Lines(next); !done || len(lines) != 0 {
+		t.Error("Finished log not done")
+	}
+}
@@ -0,0 +1,103 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+)
+
+//RunDurationBuckets are the upper bounds in seconds of the buckets of the run duration histogram
+var RunDurationBuckets = []float64{30, 60, 300, 900, 1800, 3600, 7200, 14400, 28800}
+
+//jobMetrics counts the scheduled runs of a job since the daemon started. Ad hoc runs are not counted
+type jobMetrics struct {
+	sync.Mutex
+	runs map[string]uint64
+	//runs per bucket of RunDurationBuckets, the last one is for the longer runs
+	durations       []uint64
+	durationSum     float64
+	precondFailures uint64
+}
+
+//JobMetrics is a snapshot of the metrics of a job
+type JobMetrics struct {
+	LastSuccess          time.Time
+	LastFailure          time.Time
+	ConsecutiveFailures  int
+	PreconditionFailures uint64
+	//runs by result
+	Runs map[string]uint64
+	//DurationBuckets[i] is the number of runs that took at most RunDurationBuckets[i] seconds
+	DurationBuckets []uint64
+	DurationSum     float64
+	DurationCount   uint64
+	LastRun         *RunInfo
+}
+
+//recordMetrics counts the finished run. Failed runs are remembered in the state until the next success
+func (job *Job) recordMetrics(info *RunInfo) {
+	job.metrics.Lock()
+	if job.metrics.runs == nil {
+		job.metrics.runs = make(map[string]uint64)
+		job.metrics.durations = make([]uint64, len(RunDurationBuckets)+1)
+	}
+	job.metrics.runs[info.Result]++
+	duration := info.Finished.Sub(info.Started).Seconds()
+	bucket := len(RunDurationBuckets)
+	for idx, bound := range RunDurationBuckets {
+		if duration <= bound {
+			bucket = idx
+			break
+		}
+	}
+	job.metrics.durations[bucket]++
+	job.metrics.durationSum += duration
+	job.metrics.Unlock()
+
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	switch info.Result {
+	case edgeFailure:
+		job.state.LastFailure = info.Finished
+		job.state.ConsecutiveFailures++
+	case edgeSuccess, edgePartial:
+		job.state.ConsecutiveFailures = 0
+	default:
+		return
+	}
+	job.saveState()
+}
+
+func (job *Job) recordPreconditionFailure() {
+	job.metrics.Lock()
+	defer job.metrics.Unlock()
+	job.metrics.precondFailures++
+}
+
+//Metrics returns a snapshot of the metrics of the job
+func (job *Job) Metrics() JobMetrics {
+	metrics := JobMetrics{
+		Runs:            make(map[string]uint64),
+		DurationBuckets: make([]uint64, len(RunDurationBuckets)),
+		LastRun:         job.Snapshot().LastRun,
+	}
+	job.metrics.Lock()
+	for result, count := range job.metrics.runs {
+		metrics.Runs[result] = count
+	}
+	for idx, count := range job.metrics.durations {
+		metrics.DurationCount += count
+		if idx < len(RunDurationBuckets) {
+			metrics.DurationBuckets[idx] = metrics.DurationCount
+		}
+	}
+	metrics.DurationSum = job.metrics.durationSum
+	metrics.PreconditionFailures = job.metrics.precondFailures
+	job.metrics.Unlock()
+
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	metrics.LastSuccess = job.state.LastSuccess
+	metrics.LastFailure = job.state.LastFailure
+	metrics.ConsecutiveFailures = job.state.ConsecutiveFailures
+	return metrics
+}
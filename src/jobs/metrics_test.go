@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/false"
+	job.run(nil)
+	job.run(nil)
+
+	metrics := job.Metrics()
+	if metrics.ConsecutiveFailures != 2 || metrics.LastFailure.IsZero() || metrics.Runs[edgeFailure] != 2 {
+		t.Errorf("Failures not counted: %+v", metrics)
+	}
+	if metrics.DurationCount != 2 || metrics.DurationBuckets[0] != 2 || metrics.DurationBuckets[len(RunDurationBuckets)-1] != 2 {
+		t.Errorf("Durations not counted: %v", metrics.DurationBuckets)
+	}
+
+	job.ResticPath = "/bin/true"
+	job.run(&AdHocRun{})
+	if job.Metrics().DurationCount != 2 {
+		t.Error("Ad hoc run counted")
+	}
+	job.run(nil)
+	metrics = job.Metrics()
+	if metrics.ConsecutiveFailures != 0 || metrics.Runs[edgeSuccess] != 1 || metrics.LastRun.Result != edgeSuccess {
+		t.Errorf("Success not counted: %+v", metrics)
+	}
+
+	job.recordPreconditionFailure()
+	if job.Metrics().PreconditionFailures != 1 {
+		t.Error("Precondition failure not counted")
+	}
+}
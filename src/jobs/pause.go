@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+//MaintenanceChecker is implemented by stores that can pause all their jobs at once
+type MaintenanceChecker interface {
+	InMaintenance() bool
+}
+
+//Pause makes the job drop all triggers until it is resumed. Unlike Stop the job keeps its schedule
+func (job *Job) Pause() {
+	job.logger().Info("Paused")
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	job.state.Paused = true
+	job.Paused = true
+	job.saveState()
+}
+
+//Resume lets the job run again at its next trigger
+func (job *Job) Resume() {
+	job.logger().Info("Resumed")
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	job.state.Paused = false
+	job.Paused = false
+	job.saveState()
+}
+
+//Skip makes the job drop only its next regular trigger
+func (job *Job) Skip() {
+	job.logger().Info("Skipping next regular run")
+	job.setSkipNext(true)
+}
+
+func (job *Job) setSkipNext(skip bool) {
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	job.state.SkipNext = skip
+	job.SkipNext = skip
+	job.saveState()
+}
+
+func (job *Job) isPaused() bool {
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	return job.Paused
+}
+
+func (job *Job) inMaintenance() bool {
+	checker, ok := job.jobstore.(MaintenanceChecker)
+	return ok && checker.InMaintenance()
+}
+
+//dropTrigger checks if the trigger has to be dropped because the job is paused or skips its next run.
+//Dropped timer triggers schedule the next regular trigger so the job keeps its schedule
+func (job *Job) dropTrigger(trig jobTrigger) bool {
+	if job.isPaused() || job.inMaintenance() {
+		job.logger().Info("Paused, trigger dropped")
+		if trig.timer != "" {
+			//pending retries are given up, the next regular run starts over
+			job.setRetry(0)
+			go job.scheduleRegularTrigger()
+		}
+		return true
+	}
+	if trig.timer == timerRegular && job.Snapshot().SkipNext {
+		job.logger().Info("Regular run skipped")
+		job.setSkipNext(false)
+		go job.scheduleRegularTrigger()
+		return true
+	}
+	return false
+}
+
+//InMaintenance reports if the queue is in maintenance mode
+func (queue *JobQueue) InMaintenance() bool {
+	queue.maintenanceMutex.Lock()
+	defer queue.maintenanceMutex.Unlock()
+	return queue.maintenance
+}
+
+//SetMaintenance pauses (or resumes) all jobs of the queue at once. The paused state of the single jobs is not touched
+func (queue *JobQueue) SetMaintenance(enabled bool) {
+	log.WithFields(log.Fields{"Maintenance": enabled}).Info("Maintenance mode changed")
+	queue.maintenanceMutex.Lock()
+	defer queue.maintenanceMutex.Unlock()
+	queue.maintenance = enabled
+	queue.saveState()
+}
+
+//PauseJob pauses the job with this name
+func (queue *JobQueue) PauseJob(name string) error {
+	job, _ := queue.FindJob(name)
+	if job == nil {
+		return ErrNoSuchJob
+	}
+	job.Pause()
+	return nil
+}
+
+//ResumeJob resumes the job with this name
+func (queue *JobQueue) ResumeJob(name string) error {
+	job, _ := queue.FindJob(name)
+	if job == nil {
+		return ErrNoSuchJob
+	}
+	job.Resume()
+	return nil
+}
+
+//SkipNextJob makes the job with this name skip its next regular run
+func (queue *JobQueue) SkipNextJob(name string) error {
+	job, _ := queue.FindJob(name)
+	if job == nil {
+		return ErrNoSuchJob
+	}
+	job.Skip()
+	return nil
+}
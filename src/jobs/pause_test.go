@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPauseAndSkip(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	StateDir = dir
+	defer func() { StateDir = "" }()
+
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/true"
+
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	queue.AddJobs(job)
+	defer queue.StopAllJobs()
+	time.Sleep(10 * time.Millisecond)
+
+	queue.PauseJob("A")
+	if queue.TriggerJob("A") == nil {
+		t.Error("Paused job accepted a manual trigger")
+	}
+	job.sendTrigger(jobTrigger{kind: triggerIntern, timer: timerRegular})
+	time.Sleep(50 * time.Millisecond)
+	if job.Snapshot().LastRun != nil {
+		t.Error("Paused job ran")
+	}
+
+	//the state must survive a restart
+	restarted := newJob()
+	restarted.JobName = "A"
+	restarted.loadState()
+	if !restarted.Paused {
+		t.Error("Paused state not persisted")
+	}
+
+	queue.ResumeJob("A")
+	queue.SkipNextJob("A")
+	job.sendTrigger(jobTrigger{kind: triggerIntern, timer: timerRegular})
+	time.Sleep(50 * time.Millisecond)
+	if snapshot := job.Snapshot(); snapshot.LastRun != nil || snapshot.SkipNext {
+		t.Error("Next regular run was not skipped")
+	}
+	job.sendTrigger(jobTrigger{kind: triggerIntern, timer: timerRegular})
+	time.Sleep(50 * time.Millisecond)
+	if job.Snapshot().LastRun == nil {
+		t.Error("Job did not run after the skipped run")
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	StateDir = dir
+	defer func() { StateDir = "" }()
+
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/true"
+
+	queue, err := NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.AddJobs(job)
+	defer queue.StopAllJobs()
+	time.Sleep(10 * time.Millisecond)
+
+	queue.SetMaintenance(true)
+	job.SendTrigger(triggerExtern)
+	time.Sleep(50 * time.Millisecond)
+	if job.Snapshot().LastRun != nil {
+		t.Error("Job ran in maintenance mode")
+	}
+
+	restarted, _ := NewJobQueue(dir)
+	if !restarted.InMaintenance() {
+		t.Error("Maintenance mode not persisted")
+	}
+
+	queue.SetMaintenance(false)
+	queue.TriggerJob("A")
+	time.Sleep(50 * time.Millisecond)
+	if job.Snapshot().LastRun == nil {
+		t.Error("Job did not run after maintenance mode ended")
+	}
+}
+
+//maintenance mode is switched by the api while the loops check it, go test -race finds accesses without the lock
+func TestMaintenanceWhileRunning(t *testing.T) {
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/true"
+	queue, err := NewJobQueue(writeJobFiles(t, map[string]string{}))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(queue.Directory)
+	queue.AddJobs(job)
+	defer queue.StopAllJobs()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			queue.SetMaintenance(i%2 == 0)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		job.SendTrigger(triggerExtern)
+		json.Marshal(queue)
+		time.Sleep(time.Millisecond)
+	}
+	<-done
+	if queue.InMaintenance() {
+		t.Error("Maintenance mode not left")
+	}
+}
@@ -0,0 +1,154 @@
+package jobs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//Ping are the urls of an external monitor (healthchecks.io, Uptime Kuma, Cronitor, ...) that are called around the
+//scheduled runs. The monitor raises the alarm when a run fails or when the pings stop, e.g. because the daemon died
+type Ping struct {
+	//healthchecks style: <URL>/start when a run starts, <URL> when it succeeded and <URL>/fail when it failed
+	URL string `json:"URL"`
+	//override the urls derived from URL, for monitors with other urls
+	Start   string `json:"Start"`
+	Success string `json:"Success"`
+	Fail    string `json:"Fail"`
+	//the number of lines of the output sent with the fail ping, default 20
+	TailLines int `json:"TailLines"`
+}
+
+//pingTimeout limits one attempt to ping
+const pingTimeout = 10 * time.Second
+
+//pingRetries is how often a ping that did not reach the monitor is tried again
+const pingRetries = 3
+
+//defaultTailLines is the number of lines of the output sent with the fail ping
+const defaultTailLines = 20
+
+//pingBackoff is the pause before the first retry of a ping, doubled for every further one
+var pingBackoff = 5 * time.Second
+
+var pingClient = &http.Client{Timeout: pingTimeout}
+
+//pingConfig returns the pings of the job, or of its repository profile if the job has none
+func (job *Job) pingConfig() *Ping {
+	if job.Ping != nil {
+		return job.Ping
+	}
+	if repo := job.repository(); repo != nil {
+		return repo.Ping
+	}
+	return nil
+}
+
+//pingURL returns the url of the kind (start, success or fail), empty if the monitor does not want that ping
+func (ping *Ping) pingURL(kind string) string {
+	explicit := map[string]string{"start": ping.Start, "success": ping.Success, "fail": ping.Fail}[kind]
+	if explicit != "" || ping.URL == "" {
+		return explicit
+	}
+	base := strings.TrimRight(ping.URL, "/")
+	if kind == "success" {
+		return base
+	}
+	return base + "/" + kind
+}
+
+//pingStart tells the monitor that a scheduled run starts, so it can measure the duration and notice runs that hang
+func (job *Job) pingStart() {
+	job.sendPing("start", "")
+}
+
+//pingFinished sends the result of the run. A partial success is a success with a note, everything else a failure
+//with the exit code and the end of the output
+func (job *Job) pingFinished(info *RunInfo) {
+	switch info.Result {
+	case ResultSuccess:
+		job.sendPing("success", fmt.Sprintf("exit code %d\nsnapshot %s\n", info.ExitCode, info.SnapshotID))
+	case ResultPartial:
+		job.sendPing("success", fmt.Sprintf("exit code %d, some files could not be read\nsnapshot %s\n", info.ExitCode, info.SnapshotID))
+	default:
+		job.sendPing("fail", fmt.Sprintf("%s, exit code %d\n\n%s", info.Result, info.ExitCode, job.outputTail(info.ID)))
+	}
+}
+
+//outputTail returns the last lines of the output of the run
+func (job *Job) outputTail(id int) string {
+	runLog := job.RunLog(id)
+	if runLog == nil {
+		return ""
+	}
+	lines, _, _, _, _ := runLog.Lines(0)
+	tail := defaultTailLines
+	if ping := job.pingConfig(); ping != nil && ping.TailLines > 0 {
+		tail = ping.TailLines
+	}
+	if len(lines) > tail {
+		lines = lines[len(lines)-tail:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+//sendPing pings in the background, so a slow monitor does not delay the run. The pings of a job are sent in order,
+//a success must not overtake the start of its run
+func (job *Job) sendPing(kind, body string) {
+	ping := job.pingConfig()
+	if ping == nil {
+		return
+	}
+	url := ping.pingURL(kind)
+	if url == "" {
+		return
+	}
+	url = job.expand(url, time.Now())
+	job.pings.Lock()
+	previous := job.pings.last
+	done := make(chan struct{})
+	job.pings.last = done
+	job.pings.Unlock()
+	//the run the ping is about, the next one may have started when it is sent
+	logger := job.logger()
+	go func() {
+		defer close(done)
+		if previous != nil {
+			<-previous
+		}
+		fields := log.Fields{"Ping": kind}
+		wait := pingBackoff
+		for attempt := 0; ; attempt++ {
+			err := postPing(url, body)
+			if err == nil {
+				logger.WithFields(fields).Debug("Pinged")
+				return
+			}
+			fields["Error"] = err.Error()
+			if attempt >= pingRetries {
+				logger.WithFields(fields).Warning("Ping failed")
+				return
+			}
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}()
+}
+
+func postPing(url, body string) error {
+	resp, err := pingClient.Post(url, "text/plain; charset=utf-8", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("answered %s", resp.Status)
+	}
+	return nil
+}
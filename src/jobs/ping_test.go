@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//waitForPings waits until the pings sent so far are done
+func (job *Job) waitForPings() {
+	job.pings.Lock()
+	last := job.pings.last
+	job.pings.Unlock()
+	if last != nil {
+		<-last
+	}
+}
+
+//monitor records the pings it gets as "path body"
+type monitor struct {
+	sync.Mutex
+	pings []string
+	fails int
+}
+
+func (mon *monitor) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	mon.Lock()
+	defer mon.Unlock()
+	if mon.fails > 0 {
+		mon.fails--
+		wr.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	mon.pings = append(mon.pings, strings.TrimSpace(r.URL.RequestURI()+" "+string(body)))
+}
+
+//scheduledRun runs the job like its loop does
+func scheduledRun(job *Job) {
+	job.pingStart()
+	job.run(nil)
+	job.pingFinished(job.LastRun)
+	job.waitForPings()
+}
+
+func TestPing(t *testing.T) {
+	mon := &monitor{}
+	server := httptest.NewServer(mon)
+	defer server.Close()
+	pingBackoff = time.Millisecond
+
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/sh"
+	job.ResticArguments = []string{"-c", "echo one; echo two; echo three; exit 1"}
+	job.Ping = &Ping{URL: server.URL + "/${job.name}/", TailLines: 2}
+	mon.fails = 1
+	scheduledRun(job)
+	expected := []string{"/A/start", "/A/fail failure, exit code 1\n\ntwo\nthree"}
+	if strings.Join(mon.pings, "|") != strings.Join(expected, "|") {
+		t.Errorf("Wrong pings %q", mon.pings)
+	}
+
+	//explicit urls, no start ping
+	mon.pings = nil
+	job.ResticArguments = []string{"-c", "exit 0"}
+	job.Ping = &Ping{Success: server.URL + "/push?status=up", Fail: server.URL + "/push?status=down"}
+	scheduledRun(job)
+	if len(mon.pings) != 1 || !strings.HasPrefix(mon.pings[0], "/push?status=up exit code 0") {
+		t.Errorf("Wrong pings %q", mon.pings)
+	}
+
+	//the pings of the repository profile
+	mon.pings = nil
+	RegisterRepositories(&Repository{Name: "pinged", Location: "/tmp/pinged", Ping: &Ping{URL: server.URL + "/repo"}})
+	script, err := ioutil.TempFile("", "partial*.sh")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(script.Name())
+	script.WriteString("#!/bin/sh\nexit 3\n")
+	script.Close()
+	os.Chmod(script.Name(), 0700)
+	job.Ping = nil
+	job.RepositoryName = "pinged"
+	job.ResticPath = script.Name()
+	job.ResticArguments = nil
+	scheduledRun(job)
+	if len(mon.pings) != 2 || mon.pings[0] != "/repo/start" || !strings.Contains(mon.pings[1], "some files could not be read") {
+		t.Errorf("Wrong pings %q", mon.pings)
+	}
+
+	//ad hoc runs are not pinged
+	mon.pings = nil
+	job.run(&AdHocRun{})
+	job.waitForPings()
+	if len(mon.pings) != 0 {
+		t.Errorf("Ad hoc run pinged %q", mon.pings)
+	}
+}
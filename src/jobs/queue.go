@@ -1,13 +1,26 @@
 package jobs
 
 import (
+	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"os"
-	"path"
 	"sync"
 
 	log "github.com/Sirupsen/logrus"
+
+	"github.com/killingspark/restic-cronned/src/logging"
+)
+
+//errors of the queue actions, so callers can tell them apart
+var (
+	ErrNoSuchJob       = errors.New("No such Job")
+	ErrNoSuchOperation = errors.New("No such operation")
+	ErrNoSuchRun       = errors.New("No such run")
+	ErrNoDefinition    = errors.New("File could not be found")
+	ErrJobPaused       = errors.New("Job is paused")
+	ErrNotRunning      = errors.New("Job is not running")
+	ErrNotStopped      = errors.New("Job is not stopped")
+	ErrInvalidArgument = errors.New("Invalid argument")
 )
 
 //JobStore can have your job
@@ -17,9 +30,68 @@ type JobStore interface {
 
 //JobQueue managing the jobs
 type JobQueue struct {
-	Jobs      []*Job `json:"Jobs"`
 	Wg        *sync.WaitGroup
 	Directory string
+	//in maintenance mode all jobs are paused. The job loops and the api read and change it, see InMaintenance
+	maintenance      bool
+	maintenanceMutex sync.Mutex
+
+	//the jobs of the queue, jobsMutex guards the list but not the jobs in it
+	jobs      []*Job
+	jobsMutex sync.RWMutex
+	//changes of the list are applied one after the other in the order they were made, also when they run in the background
+	changes struct {
+		sync.Mutex
+		last chan struct{}
+	}
+
+	//one lock per repository so jobs on the same repository run one after the other
+	repoLocks  map[string]*sync.Mutex
+	locksMutex sync.Mutex
+	//actions like cancel that run in the background and can be polled
+	operations operationStore
+	//serializes the changes of the definition files
+	definitionsMutex sync.Mutex
+	//the events of all jobs
+	events EventBus
+}
+
+//MarshalJSON encodes the jobs as they are listed at the moment
+func (queue *JobQueue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Jobs        []*Job `json:"Jobs"`
+		Maintenance bool   `json:"Maintenance"`
+	}{queue.List(), queue.InMaintenance()})
+}
+
+//List returns the jobs of the queue. The list is a copy, jobs that are added or removed later do not change it
+func (queue *JobQueue) List() []*Job {
+	queue.jobsMutex.RLock()
+	defer queue.jobsMutex.RUnlock()
+	jobs := make([]*Job, len(queue.jobs))
+	copy(jobs, queue.jobs)
+	return jobs
+}
+
+//reserveChange takes the next place in the order of the changes. The change has to wait for previous
+//and close done when it was applied
+func (queue *JobQueue) reserveChange() (previous, done chan struct{}) {
+	queue.changes.Lock()
+	defer queue.changes.Unlock()
+	previous = queue.changes.last
+	done = make(chan struct{})
+	queue.changes.last = done
+	return previous, done
+}
+
+//inOrder applies the change after all changes that were made before it
+func (queue *JobQueue) inOrder(change func()) {
+	previous, done := queue.reserveChange()
+	defer close(done)
+	if previous != nil {
+		<-previous
+	}
+	change()
 }
 
 //StartQueue starts all the jobs in the directory
@@ -43,107 +115,146 @@ func (queue *JobQueue) StopJob(name string) error {
 	if job != nil {
 		job.Stop()
 	} else {
-		return errors.New("No such Job")
+		return ErrNoSuchJob
 	}
 	return nil
 }
 
 //RemoveJob stops the job and then removes it from the queue
 func (queue *JobQueue) RemoveJob(name string) error {
-	for idx, job := range queue.Jobs {
-		if job.JobName == name {
-			job.Stop()
-			queue.Jobs = append(queue.Jobs[:idx], queue.Jobs[idx+1:]...)
-			return nil
+	var err error
+	queue.inOrder(func() { err = queue.removeJob(name) })
+	return err
+}
+
+//removeJob waits for the job to stop without locking the list, so the other jobs can still be found meanwhile
+func (queue *JobQueue) removeJob(name string) error {
+	job, _ := queue.FindJob(name)
+	if job == nil {
+		return ErrNoSuchJob
+	}
+	if job.currentStatus() != statusStopped {
+		job.Stop()
+	}
+	queue.jobsMutex.Lock()
+	for idx, listed := range queue.jobs {
+		if listed == job {
+			queue.jobs = append(queue.jobs[:idx], queue.jobs[idx+1:]...)
+			break
 		}
 	}
-	return errors.New("No such job")
+	queue.jobsMutex.Unlock()
+	logging.RemoveJob(name)
+	queue.Publish(Event{Type: EventJobRemoved, Job: name})
+	return nil
 }
 
 //TriggerJob triggers the job with the extern trigger so it doesnt trigger itself afterwards
 func (queue *JobQueue) TriggerJob(name string) error {
 	job, _ := queue.FindJob(name)
-	if job != nil {
-		job.SendTrigger(triggerExtern)
-	} else {
-		return errors.New("No such Job")
+	if job == nil {
+		return ErrNoSuchJob
+	}
+	if job.isPaused() || queue.InMaintenance() {
+		return ErrJobPaused
 	}
+	job.SendTrigger(triggerExtern)
 	return nil
 }
 
 //RestartJob restarts the job with this name if it is present and in the "stopped" State
 func (queue *JobQueue) RestartJob(name string) error {
 	job, _ := queue.FindJob(name)
-	if job != nil && job.Status == statusStopped {
-		job.Status = statusReady
-		err := queue.startJob(job)
-		if err != nil {
-			return err
-		}
-	} else {
-		return errors.New("No such Job")
+	if job == nil {
+		return ErrNoSuchJob
 	}
-	return nil
+	if job.currentStatus() != statusStopped {
+		return ErrNotStopped
+	}
+	job.setStatus(statusReady)
+	return queue.startJob(job)
 }
 
 //StopAllJobs can take a long time depending on the jobs
 func (queue *JobQueue) StopAllJobs() {
-	for _, job := range queue.Jobs {
-		job.Stop()
-	}
+	queue.inOrder(func() {
+		for _, job := range queue.List() {
+			//a stopped job has no loop left that could answer
+			if job.currentStatus() != statusStopped {
+				job.Stop()
+			}
+		}
+	})
 }
 
-//ReloadJob reloads the file (with all changes made to it) and replaces the old job with the new one.
+//ReloadJob reloads the file that defines the job (with all changes made to it) and replaces the old job with the new one.
+//Only the jobs of that file are built, the other jobs do not read their passwords again.
 //the old job is stopped (and waited for until stopped) before the new job is started
 func (queue *JobQueue) ReloadJob(name string) error {
 	oldJob, _ := queue.FindJob(name)
 
 	if oldJob == nil {
-		return errors.New("No such job")
+		return ErrNoSuchJob
+	}
+	if oldJob.SourceFile() == "" {
+		return ErrNoDefinition
 	}
 
-	files, err := ioutil.ReadDir(queue.Directory)
+	jobs, errs, err := loadFileJobs(queue.Directory, oldJob.SourceFile())
+	if os.IsNotExist(err) {
+		log.WithFields(log.Fields{"Job": name}).Warning("No file for job")
+		return ErrNoDefinition
+	}
 	if err != nil {
-		log.Error("Error opening the directory: " + err.Error())
+		return err
+	}
+	for _, err := range errs {
+		log.WithFields(log.Fields{"Error": err.Error()}).Warning("Decoding error")
 	}
 
-	for _, f := range files {
-		if f.IsDir() || !(f.Name() == name+".json") {
-			continue
-		}
-
-		file, err := os.Open(path.Join(queue.Directory, f.Name()))
-		if err != nil {
-			log.WithFields(log.Fields{"File": f.Name(), "Error": err.Error()}).Warning("File error")
-			continue
-		}
-
-		job, err := LoadJobFromFile(file)
-		if err != nil {
-			log.WithFields(log.Fields{"File": f.Name(), "Error": err.Error()}).Warning("Decoding error")
+	for _, job := range jobs {
+		if job.JobName != name {
 			continue
 		}
-		queue.replaceJob(job, oldJob)
-		err = queue.startJob(job)
+		queue.inOrder(func() {
+			queue.replaceJob(job, oldJob)
+			err = queue.startJob(job)
+		})
 		if err != nil {
-			print(err.Error())
+			log.WithFields(log.Fields{"Job": name, "Error": err.Error()}).Error("Reloaded job could not be started")
 		}
-		return nil
+		return err
 	}
 	log.WithFields(log.Fields{"Job": name}).Warning("No file for job")
-	return errors.New("File could not be found")
+	return ErrNoDefinition
 }
 
+//replaceJob stops the old job and puts the new one in its place. The list is only locked for the swap,
+//stopping waits for a running restic
 func (queue *JobQueue) replaceJob(newJob, oldJob *Job) {
-	if oldJob.Status != statusWaiting {
+	//a stopped job has no loop left that could answer
+	if oldJob.currentStatus() != statusStopped {
 		oldJob.Stop()
 	}
-	_, idx := queue.FindJob(oldJob.JobName)
-	queue.Jobs[idx] = newJob
+	queue.jobsMutex.Lock()
+	replaced := false
+	for idx, job := range queue.jobs {
+		if job == oldJob {
+			queue.jobs[idx] = newJob
+			replaced = true
+		}
+	}
+	if !replaced {
+		queue.jobs = append(queue.jobs, newJob)
+	}
+	queue.jobsMutex.Unlock()
+	queue.Publish(Event{Type: EventJobReloaded, Job: newJob.JobName})
 }
 
 func (queue *JobQueue) FindJob(name string) (*Job, int) {
-	for idx, job := range queue.Jobs {
+	queue.jobsMutex.RLock()
+	defer queue.jobsMutex.RUnlock()
+	for idx, job := range queue.jobs {
 		if job.JobName == name {
 			return job, idx
 		}
@@ -152,7 +263,7 @@ func (queue *JobQueue) FindJob(name string) (*Job, int) {
 }
 
 func (queue *JobQueue) startJob(job *Job) error {
-	if job.Status != statusReady {
+	if job.currentStatus() != statusReady {
 		return errors.New("Illegal state")
 	}
 	queue.Wg.Add(1)
@@ -168,11 +279,17 @@ func (queue *JobQueue) JobExists(name string) bool {
 
 //AddJobs adds the jobs to its list and starts them
 func (queue *JobQueue) AddJobs(jobs ...*Job) {
+	queue.inOrder(func() { queue.addJobs(jobs...) })
+}
+
+func (queue *JobQueue) addJobs(jobs ...*Job) {
 	for _, job := range jobs {
 		if oldJob, _ := queue.FindJob(job.JobName); oldJob != nil {
 			queue.replaceJob(job, oldJob)
 		} else {
-			queue.Jobs = append(queue.Jobs, job)
+			queue.jobsMutex.Lock()
+			queue.jobs = append(queue.jobs, job)
+			queue.jobsMutex.Unlock()
 		}
 		queue.startJob(job)
 	}
@@ -192,5 +309,7 @@ func NewJobQueue(path string) (*JobQueue, error) {
 	if dir := s.IsDir(); !dir {
 		return nil, errors.New(path + " is no directory")
 	}
-	return &JobQueue{Wg: new(sync.WaitGroup), Directory: path, Jobs: make([]*Job, 0)}, nil
+	queue := &JobQueue{Wg: new(sync.WaitGroup), Directory: path, jobs: make([]*Job, 0)}
+	queue.loadState()
+	return queue, nil
 }
@@ -16,18 +16,18 @@ type queueTestSuite struct {
 func (suite *queueTestSuite) SetupSuite() {
 	suite.job1 = newJob()
 	suite.job1.JobName = "A"
-	suite.job1.JobNameToTrigger = "B"
-	suite.job1.RegularTimer = -1
-	suite.job1.RetryTimer = -1
+	suite.job1.JobNameToTrigger = JobNames{"B"}
+	suite.job1.RegularTimer = ""
+	suite.job1.RetryTimer = ""
 
 	suite.job2 = newJob()
 	suite.job2.JobName = "B"
 	suite.job2.ResticArguments = []string{"aösdfhasödlk", "asdfwerrwefosdf"}
-	suite.job2.RegularTimer = -1
-	suite.job2.RetryTimer = -1
+	suite.job2.RegularTimer = ""
+	suite.job2.RetryTimer = ""
 	suite.job2.MaxFailedRetries = 2
 
-	suite.queue = &JobQueue{Wg: new(sync.WaitGroup), Jobs: make([]*Job, 0)}
+	suite.queue = &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
 }
 
 func (suite *queueTestSuite) TearDownSuite() {
@@ -42,8 +42,8 @@ func (suite *queueTestSuite) TearDownTest() {
 func (suite *queueTestSuite) TestAdd() {
 	suite.queue.AddJobs(suite.job1, suite.job2)
 	time.Sleep(1 * time.Millisecond)
-	for _, job := range suite.queue.Jobs {
-		if job.Status != statusWaiting {
+	for _, job := range suite.queue.List() {
+		if job.currentStatus() != statusWaiting {
 			suite.test.Error("Job not started: " + job.JobName)
 		}
 	}
@@ -65,7 +65,7 @@ func (suite *queueTestSuite) TestStop() {
 	time.Sleep(1 * time.Millisecond)
 	suite.queue.StopJob("A")
 	time.Sleep(1 * time.Millisecond)
-	if suite.job1.Status != statusStopped {
+	if suite.job1.currentStatus() != statusStopped {
 		suite.test.Error("Did not stop")
 	}
 }
@@ -75,7 +75,7 @@ func (suite *queueTestSuite) TestTrigger() {
 	time.Sleep(1 * time.Millisecond)
 	suite.queue.TriggerJob("B")
 	time.Sleep(1 * time.Millisecond)
-	if suite.job2.CurrentRetry != 1 {
+	if suite.job2.Snapshot().CurrentRetry != 1 {
 		suite.test.Error("Did not trigger")
 	}
 }
@@ -85,12 +85,12 @@ func (suite *queueTestSuite) TestRestart() {
 	time.Sleep(1 * time.Millisecond)
 	suite.queue.StopJob("A")
 	time.Sleep(1 * time.Millisecond)
-	if suite.job1.Status != statusStopped {
+	if suite.job1.currentStatus() != statusStopped {
 		suite.test.Error("Did not stop")
 	}
 	suite.queue.RestartJob("A")
 	time.Sleep(1 * time.Millisecond)
-	if suite.job1.Status != statusWaiting {
+	if suite.job1.currentStatus() != statusWaiting {
 		suite.test.Error("Did not restart")
 	}
 }
@@ -100,8 +100,8 @@ func (suite *queueTestSuite) TestStopAll() {
 	time.Sleep(1 * time.Millisecond)
 	suite.queue.StopAllJobs()
 	time.Sleep(1 * time.Millisecond)
-	for _, job := range suite.queue.Jobs {
-		if job.Status != statusStopped {
+	for _, job := range suite.queue.List() {
+		if job.currentStatus() != statusStopped {
 			suite.test.Error("Job not stop: " + job.JobName)
 		}
 	}
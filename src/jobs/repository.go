@@ -0,0 +1,265 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	keyring "github.com/zalando/go-keyring"
+)
+
+//Repository is a restic repository that can be shared by many jobs. Jobs reference it by its name
+type Repository struct {
+	Name string `json:"Name"`
+	//passed to restic as -r
+	Location string `json:"Location"`
+	//keyring reference for the repository password
+	Username string `json:"Username"`
+	Service  string `json:"Service"`
+	//environment variables set for every restic invocation on this repository
+	Env map[string]string `json:"Env"`
+	//environment variables whose values are read from the keyring (e.g. the credentials of the storage backend)
+	Secrets    map[string]KeyringRef `json:"Secrets"`
+	ResticPath string                `json:"ResticPath"`
+	//flags that are put in front of the ResticArguments of every job using this repository
+	Flags []string `json:"Flags"`
+	//pings of the jobs using this repository that have no Ping of their own
+	Ping *Ping `json:"Ping"`
+}
+
+//KeyringRef identifies an entry in the keyring
+type KeyringRef struct {
+	Username string `json:"Username"`
+	Service  string `json:"Service"`
+}
+
+var repositories = make(map[string]*Repository)
+var repositoriesMutex sync.RWMutex
+
+//RegisterRepositories makes the repositories available for jobs loaded afterwards. Already registered names are replaced
+func RegisterRepositories(repos ...*Repository) error {
+	for _, repo := range repos {
+		if repo.Name == "" {
+			return errors.New("Repository without a name")
+		}
+		if repo.Location == "" {
+			return fmt.Errorf("Repository %s has no Location", repo.Name)
+		}
+	}
+	repositoriesMutex.Lock()
+	defer repositoriesMutex.Unlock()
+	for _, repo := range repos {
+		repositories[repo.Name] = repo
+	}
+	return nil
+}
+
+//FindRepository returns the registered repository with this name or nil
+func FindRepository(name string) *Repository {
+	repositoriesMutex.RLock()
+	defer repositoriesMutex.RUnlock()
+	return repositories[name]
+}
+
+//RepositoryNames lists the names of all registered repositories
+func RepositoryNames() []string {
+	repositoriesMutex.RLock()
+	defer repositoriesMutex.RUnlock()
+	names := make([]string, 0, len(repositories))
+	for name := range repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//LoadRepositoriesFromConfig reads the "Repositories" section of the config file. It maps the name of a repository to its definition
+func LoadRepositoriesFromConfig(configFile string) ([]*Repository, error) {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseDocument(data, configFile)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("config file is not an object")
+	}
+	var section interface{}
+	for key, val := range obj {
+		if strings.EqualFold(key, "Repositories") {
+			section = val
+		}
+	}
+	if section == nil {
+		return make([]*Repository, 0), nil
+	}
+
+	var named map[string]*Repository
+	err = decodeStrict(section, &named)
+	if err != nil {
+		return nil, fmt.Errorf("Repositories: %s", err.Error())
+	}
+	repos := make([]*Repository, 0, len(named))
+	for name, repo := range named {
+		if repo.Name != "" && repo.Name != name {
+			return nil, fmt.Errorf("Repository %s has a different Name: %s", name, repo.Name)
+		}
+		repo.Name = name
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+//LoadRepositoriesFromDir loads all repository files (json, yaml, toml) in the directory.
+//A file can hold one repository or a "Repositories" list. A repository without a name is named after its file
+func LoadRepositoriesFromDir(dirPath string) ([]*Repository, error) {
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]*Repository, 0)
+	for _, f := range files {
+		if f.IsDir() || !isJobFile(f.Name()) {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(dirPath, f.Name()))
+		if err != nil {
+			log.WithFields(log.Fields{"File": f.Name(), "Error": err.Error()}).Warning("File error")
+			continue
+		}
+		defs, err := parseDefinitions(data, f.Name(), "Repositories")
+		if err != nil {
+			log.WithFields(log.Fields{"File": f.Name(), "Error": err.Error()}).Warning("Decoding error")
+			continue
+		}
+		for _, raw := range defs {
+			repo := &Repository{}
+			err = decodeStrict(raw, repo)
+			if err != nil {
+				log.WithFields(log.Fields{"File": f.Name(), "Error": err.Error()}).Warning("Decoding error")
+				continue
+			}
+			if repo.Name == "" && len(defs) == 1 {
+				repo.Name = strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+			}
+			repos = append(repos, repo)
+		}
+	}
+	return repos, nil
+}
+
+//environment returns the environment variables for restic, including the secrets from the keyring.
+//The values of Env are passed through expand, secrets are used as they are
+func (repo *Repository) environment(expand func(string) string) []string {
+	env := make([]string, 0, len(repo.Env)+len(repo.Secrets))
+	for key, val := range repo.Env {
+		env = append(env, key+"="+expand(val))
+	}
+	for key, ref := range repo.Secrets {
+		secret, err := keyring.Get(ref.Service, ref.Username)
+		if err != nil {
+			log.WithFields(log.Fields{"Repository": repo.Name, "Secret": key}).Warning("couldn't retrieve secret.")
+			continue
+		}
+		env = append(env, key+"="+secret)
+	}
+	sort.Strings(env)
+	return env
+}
+
+//arguments returns the restic arguments that select this repository
+func (repo *Repository) arguments() []string {
+	args := []string{"-r", repo.Location}
+	return append(args, repo.Flags...)
+}
+
+//repository returns the profile the job references or nil
+func (job *Job) repository() *Repository {
+	if job.RepositoryName == "" {
+		return nil
+	}
+	return FindRepository(job.RepositoryName)
+}
+
+//repositoryKey identifies the repository the job works on. Jobs with the same key must not run at the same time.
+//Jobs without a repository profile fall back to the -r argument
+func (job *Job) repositoryKey() string {
+	if job.RepositoryName != "" {
+		return "profile:" + job.RepositoryName
+	}
+	if repo := job.getRepo(); repo != "" {
+		return "location:" + repo
+	}
+	return ""
+}
+
+//RepositoryLabel names the repository of the job for reports: the name of its profile or its -r location
+func (job *Job) RepositoryLabel() string {
+	if job.RepositoryName != "" {
+		return job.RepositoryName
+	}
+	return job.getRepo()
+}
+
+//command builds the restic invocation for this job (binary, arguments and additional environment) with these restic arguments.
+//Variables in the arguments and env values are expanded at the time of the call
+func (job *Job) command(resticArgs []string) (string, []string, []string) {
+	now := time.Now()
+	expand := func(s string) string { return job.expand(s, now) }
+	binary := "restic"
+	args := make([]string, 0)
+	env := make([]string, 0)
+	if repo := job.repository(); repo != nil {
+		if repo.ResticPath != "" {
+			binary = repo.ResticPath
+		}
+		args = append(args, repo.arguments()...)
+		env = append(env, repo.environment(expand)...)
+	}
+	if job.ResticPath != "" {
+		binary = job.ResticPath
+	}
+	args = append(args, resticArgs...)
+	env = append(env, "RESTIC_PASSWORD="+job.password)
+	return binary, job.expandAll(args, now), env
+}
+
+//RepoLocker is implemented by stores that serialize the jobs working on the same repository
+type RepoLocker interface {
+	LockRepo(key string) func()
+}
+
+func (queue *JobQueue) repoMutex(key string) *sync.Mutex {
+	queue.locksMutex.Lock()
+	defer queue.locksMutex.Unlock()
+	if queue.repoLocks == nil {
+		queue.repoLocks = make(map[string]*sync.Mutex)
+	}
+	m, ok := queue.repoLocks[key]
+	if !ok {
+		m = new(sync.Mutex)
+		queue.repoLocks[key] = m
+	}
+	return m
+}
+
+//LockRepo blocks until no other job of this queue works on the repository and returns the function to release it again
+func (queue *JobQueue) LockRepo(key string) func() {
+	if key == "" {
+		return func() {}
+	}
+	m := queue.repoMutex(key)
+	m.Lock()
+	return m.Unlock
+}
@@ -0,0 +1,62 @@
+package jobs
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRepositoryProfiles(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{
+		"config.json": `{
+			"JobPath": "/tmp",
+			"Repositories": {
+				"nas": {
+					"Location": "sftp:nas:/backup",
+					"ResticPath": "/opt/restic",
+					"Flags": ["--limit-upload", "1000"],
+					"Env": {"RESTIC_CACHE_DIR": "/var/cache/restic"}
+				}
+			}
+		}`,
+	})
+	defer os.RemoveAll(dir)
+
+	repos, err := LoadRepositoriesFromConfig(path.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(repos) != 1 || repos[0].Name != "nas" {
+		t.Fatal("Repository not loaded from config")
+	}
+	err = RegisterRepositories(repos...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	job, err := loadJobFromString(t, `{"JobName": "A", "Repository": "nas", "ResticArguments": ["backup", "/home"]}`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	binary, args, env := job.command(job.ResticArguments)
+	if binary != "/opt/restic" {
+		t.Error("Restic binary of the repository not used: " + binary)
+	}
+	if strings.Join(args, " ") != "-r sftp:nas:/backup --limit-upload 1000 backup /home" {
+		t.Error("Wrong arguments: " + strings.Join(args, " "))
+	}
+	if env[0] != "RESTIC_CACHE_DIR=/var/cache/restic" {
+		t.Error("Repository env not set (or its case changed): " + env[0])
+	}
+
+	other, _ := loadJobFromString(t, `{"JobName": "B", "Repository": "nas", "ResticArguments": ["-r", "/elsewhere", "check"]}`)
+	if other.repositoryKey() != job.repositoryKey() {
+		t.Error("Jobs referencing the same profile are not on the same repository")
+	}
+
+	_, err = loadJobFromString(t, `{"JobName": "C", "Repository": "usb"}`)
+	if err == nil {
+		t.Error("Unknown repository accepted")
+	}
+}
@@ -0,0 +1,156 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//RunInfo describes one run of restic. It is passed to the follow up jobs of the run
+type RunInfo struct {
+	//counts the runs of the job
+	ID         int       `json:"ID"`
+	Job        string    `json:"Job"`
+	Result     string    `json:"Result"`
+	ExitCode   int       `json:"ExitCode"`
+	SnapshotID string    `json:"SnapshotID"`
+	Started    time.Time `json:"Started"`
+	Finished   time.Time `json:"Finished"`
+	//the run was started by hand with other arguments, it does not count for the schedule and follow ups
+	AdHoc bool `json:"AdHoc"`
+	//from the summary of restic backup --json
+	BytesAdded     uint64 `json:"BytesAdded"`
+	BytesProcessed uint64 `json:"BytesProcessed"`
+	FilesNew       uint64 `json:"FilesNew"`
+	FilesChanged   uint64 `json:"FilesChanged"`
+}
+
+//historySize is the number of runs kept in the history of a job
+const historySize = 20
+
+//recordRun adds the run to the history of the job
+func (job *Job) recordRun(info *RunInfo) {
+	job.loopMutex.Lock()
+	defer job.loopMutex.Unlock()
+	job.History = append(job.History, info)
+	if len(job.History) > historySize {
+		job.History = job.History[len(job.History)-historySize:]
+	}
+}
+
+//resultCancelled is the result of a run that was cancelled, no edge is followed for it
+const resultCancelled = "cancelled"
+
+//results of the runs as in RunInfo.Result
+const (
+	ResultSuccess   = edgeSuccess
+	ResultPartial   = edgePartial
+	ResultFailure   = edgeFailure
+	ResultCancelled = resultCancelled
+)
+
+//resultOf maps the return of run to the result seen by follow up jobs, which is also the kind of edge that is followed
+func resultOf(ret JobReturn) string {
+	switch ret {
+	case returnOk:
+		return edgeSuccess
+	case returnPartial:
+		return edgePartial
+	case returnCancelled:
+		return resultCancelled
+	default:
+		return edgeFailure
+	}
+}
+
+//resticMessage is a line of the output of restic --json. Only the fields needed here are decoded
+type resticMessage struct {
+	MessageType         string  `json:"message_type"`
+	PercentDone         float64 `json:"percent_done"`
+	SnapshotID          string  `json:"snapshot_id"`
+	DataAdded           uint64  `json:"data_added"`
+	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
+	FilesNew            uint64  `json:"files_new"`
+	FilesChanged        uint64  `json:"files_changed"`
+}
+
+//restic without --json prints "snapshot 1a2b3c4d saved"
+var snapshotSavedPattern = regexp.MustCompile(`^snapshot ([0-9a-f]+) saved`)
+
+//parseOutputLine extracts the progress and the snapshot id from a line restic printed on stdout
+func (job *Job) parseOutputLine(line string, info *RunInfo) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		var msg resticMessage
+		if json.Unmarshal([]byte(line), &msg) != nil {
+			return
+		}
+		switch msg.MessageType {
+		case "status":
+			//one event per percent is enough
+			if int(msg.PercentDone*100) != int(job.setProgress(msg.PercentDone*100)) {
+				job.emit(Event{Type: EventProgress, Progress: msg.PercentDone * 100})
+			}
+		case "summary":
+			if msg.SnapshotID != "" {
+				info.SnapshotID = msg.SnapshotID
+			}
+			info.BytesAdded = msg.DataAdded
+			info.BytesProcessed = msg.TotalBytesProcessed
+			info.FilesNew = msg.FilesNew
+			info.FilesChanged = msg.FilesChanged
+		}
+		return
+	}
+	if m := snapshotSavedPattern.FindStringSubmatch(line); m != nil {
+		info.SnapshotID = m[1]
+	}
+}
+
+//lineWriter calls fn for every complete line written to it
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+	for {
+		idx := bytes.IndexAny(lw.buf, "\n\r")
+		if idx < 0 {
+			break
+		}
+		if idx > 0 {
+			lw.fn(string(lw.buf[:idx]))
+		}
+		lw.buf = lw.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+//Flush passes the last line, if it was not terminated by a newline
+func (lw *lineWriter) Flush() {
+	if len(lw.buf) > 0 {
+		lw.fn(string(lw.buf))
+		lw.buf = nil
+	}
+}
+
+//triggerEnvironment returns the environment variables describing the run that triggered this job
+func (job *Job) triggerEnvironment() []string {
+	info := job.triggeredBy
+	if info == nil {
+		return []string{}
+	}
+	return []string{
+		"RC_TRIGGER_JOB=" + info.Job,
+		"RC_TRIGGER_RESULT=" + info.Result,
+		"RC_TRIGGER_EXIT_CODE=" + strconv.Itoa(info.ExitCode),
+		"RC_TRIGGER_SNAPSHOT=" + info.SnapshotID,
+		"RC_TRIGGER_STARTED=" + info.Started.Format(time.RFC3339),
+		"RC_TRIGGER_FINISHED=" + info.Finished.Format(time.RFC3339),
+	}
+}
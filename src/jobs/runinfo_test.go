@@ -0,0 +1,60 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseOutput(t *testing.T) {
+	job := newJob()
+	info := &RunInfo{}
+	job.parseOutputLine(`{"message_type":"status","percent_done":0.25}`, info)
+	if job.Progress != 25 {
+		t.Error("Progress not parsed")
+	}
+	job.parseOutputLine(`{"message_type":"summary","files_new":3,"snapshot_id":"5f1e8b3c"}`, info)
+	if info.SnapshotID != "5f1e8b3c" || info.FilesNew != 3 {
+		t.Error("Snapshot id not parsed from json summary")
+	}
+	job.parseOutputLine("snapshot 1a2b3c4d saved", info)
+	if info.SnapshotID != "1a2b3c4d" {
+		t.Error("Snapshot id not parsed from text output")
+	}
+}
+
+func TestFollowUpPayload(t *testing.T) {
+	dir := writeJobFiles(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	out := path.Join(dir, "out")
+
+	backup := newJob()
+	backup.JobName = "backup"
+	backup.ResticPath = "/bin/echo"
+	backup.ResticArguments = []string{"snapshot 1a2b3c4d saved"}
+	backup.JobNameToTrigger = JobNames{"check"}
+
+	check := newJob()
+	check.JobName = "check"
+	check.ResticPath = "/bin/sh"
+	check.ResticArguments = []string{"-c", "echo $RC_TRIGGER_JOB $RC_TRIGGER_RESULT ${trigger.snapshot} > " + out}
+
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	queue.AddJobs(backup, check)
+	defer queue.StopAllJobs()
+	time.Sleep(10 * time.Millisecond)
+
+	queue.TriggerJob("backup")
+	time.Sleep(200 * time.Millisecond)
+	content, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal("Follow up did not run: " + err.Error())
+	}
+	if strings.TrimSpace(string(content)) != "backup success 1a2b3c4d" {
+		t.Error("Wrong payload: " + string(content))
+	}
+}
@@ -0,0 +1,367 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//CurrentSchemaVersion is the version of the job format this build understands. Older files get upgraded on load
+const CurrentSchemaVersion = 1
+
+//SchemaID is the $id under which the job schema is published
+const SchemaID = "https://github.com/KillingSpark/restic-cronned/schema/job.schema.json"
+
+//StrictDecoding rejects job files that contain keys which are not part of the job format
+var StrictDecoding = true
+
+//schemaUpgrader migrates a raw job definition from one version to the next
+type schemaUpgrader func(raw map[string]interface{}) error
+
+//upgraders[i] upgrades a definition with SchemaVersion i to SchemaVersion i+1
+var upgraders = []schemaUpgrader{
+	upgradeV0ToV1,
+}
+
+//files written before versioning was introduced have no SchemaVersion but are otherwise identical to version 1
+func upgradeV0ToV1(raw map[string]interface{}) error {
+	return nil
+}
+
+//upgradeDefinition runs all upgraders needed to bring raw to CurrentSchemaVersion
+func upgradeDefinition(raw map[string]interface{}) error {
+	version := 0
+	if v, ok := raw["SchemaVersion"]; ok {
+		switch n := v.(type) {
+		case json.Number:
+			i, err := n.Int64()
+			if err != nil {
+				return errors.New("SchemaVersion must be an integer")
+			}
+			version = int(i)
+		case float64:
+			version = int(n)
+		case int:
+			version = n
+		case int64:
+			version = int(n)
+		default:
+			return errors.New("SchemaVersion must be an integer")
+		}
+	}
+	if version < 0 || version > CurrentSchemaVersion {
+		return fmt.Errorf("unsupported SchemaVersion %d (this build understands up to %d)", version, CurrentSchemaVersion)
+	}
+	for ; version < CurrentSchemaVersion; version++ {
+		err := upgraders[version](raw)
+		if err != nil {
+			return fmt.Errorf("upgrading from SchemaVersion %d: %s", version, err.Error())
+		}
+	}
+	raw["SchemaVersion"] = CurrentSchemaVersion
+	return nil
+}
+
+//decodeStrict decodes generic maps and lists into v, honoring StrictDecoding
+func decodeStrict(raw interface{}, v interface{}) error {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	//the keys are checked on a copy in the shape of json, yaml and toml can produce other maps and numbers
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err = dec.Decode(&generic); err != nil {
+		return friendlyDecodeError(err, reflect.TypeOf(v))
+	}
+	if err = checkKeys(generic, reflect.TypeOf(v)); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(generic); err != nil {
+		return err
+	}
+	dec = json.NewDecoder(bytes.NewReader(data))
+	if StrictDecoding {
+		dec.DisallowUnknownFields()
+	}
+	err = dec.Decode(v)
+	if err != nil {
+		return friendlyDecodeError(err, reflect.TypeOf(v))
+	}
+	return nil
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+//checkKeys compares the keys of raw with the keys of the schema of t. encoding/json would also take keys that differ
+//in case and keys of the runtime state. Such keys are errors with StrictDecoding. Without, keys that differ in case
+//are taken like encoding/json does and the others are dropped, both with a warning
+func checkKeys(raw interface{}, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) || t.Implements(schemaProviderType) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if list, ok := raw.([]interface{}); ok {
+			for _, item := range list {
+				if err := checkKeys(item, t.Elem()); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Map:
+		if obj, ok := raw.(map[string]interface{}); ok {
+			for _, val := range obj {
+				if err := checkKeys(val, t.Elem()); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Struct:
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			//the decoder reports the wrong type
+			return nil
+		}
+		fields := make(map[string]reflect.Type)
+		folded := make(map[string]string)
+		for i := 0; i < t.NumField(); i++ {
+			if name, ok := schemaFieldName(t.Field(i)); ok {
+				fields[name] = t.Field(i).Type
+				folded[strings.ToLower(name)] = name
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			val := obj[key]
+			fieldType, ok := fields[key]
+			if !ok {
+				if !StrictDecoding {
+					delete(obj, key)
+					name, found := folded[strings.ToLower(key)]
+					if _, exact := obj[name]; !found || exact {
+						log.WithFields(log.Fields{"Key": key}).Warning("Unknown key in job definition ignored")
+						continue
+					}
+					log.WithFields(log.Fields{"Key": key, "Field": name}).Warning("Key in job definition in the wrong case")
+					obj[name] = val
+					if err := checkKeys(val, fields[name]); err != nil {
+						return err
+					}
+					continue
+				}
+				if suggestion := closestKey(key, schemaKeys(t)); suggestion != "" {
+					return fmt.Errorf("unknown key %q (did you mean %q?)", key, suggestion)
+				}
+				return fmt.Errorf("unknown key %q", key)
+			}
+			if err := checkKeys(val, fieldType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//friendlyDecodeError turns the errors of encoding/json into messages that point at the offending key
+func friendlyDecodeError(err error, t reflect.Type) error {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		key := typeErr.Field
+		if key == "" {
+			key = "(top level)"
+		}
+		return fmt.Errorf("key %q must be %s, got %s", key, schemaTypeName(typeErr.Type), typeErr.Value)
+	}
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		return fmt.Errorf("syntax error at offset %d: %s", syntaxErr.Offset, syntaxErr.Error())
+	}
+
+	const unknownPrefix = "json: unknown field "
+	msg := err.Error()
+	if strings.HasPrefix(msg, unknownPrefix) {
+		key := strings.Trim(strings.TrimPrefix(msg, unknownPrefix), "\"")
+		if suggestion := closestKey(key, schemaKeys(t)); suggestion != "" {
+			return fmt.Errorf("unknown key %q (did you mean %q?)", key, suggestion)
+		}
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return err
+}
+
+//closestKey returns the known key that is most similar to key, or "" if none is close enough to be a typo
+func closestKey(key string, known []string) string {
+	best := ""
+	bestDist := len(key)/3 + 2
+	for _, k := range known {
+		d := levenshtein(strings.ToLower(key), strings.ToLower(k))
+		if d < bestDist {
+			best = k
+			bestDist = d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//schemaKeys lists all keys (recursively) that may appear in a definition of type t
+func schemaKeys(t reflect.Type) []string {
+	keys := make([]string, 0)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return keys
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := schemaFieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+		keys = append(keys, name)
+		keys = append(keys, schemaKeys(t.Field(i).Type)...)
+	}
+	return keys
+}
+
+//schemaFieldName returns the json key of a field and whether it belongs into the schema.
+//Fields tagged with `schema:"-"` are runtime state and not part of the file format
+func schemaFieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" || f.Tag.Get("schema") == "-" {
+		return "", false
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name, true
+}
+
+func schemaTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	default:
+		return "an object"
+	}
+}
+
+//schemaProvider is implemented by types that are decoded in a custom way and therefore need a custom schema
+type schemaProvider interface {
+	jsonSchema() map[string]interface{}
+}
+
+var schemaProviderType = reflect.TypeOf((*schemaProvider)(nil)).Elem()
+
+//typeSchema builds the JSON schema for the type t
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Implements(schemaProviderType) {
+		return reflect.Zero(t).Interface().(schemaProvider).jsonSchema()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		required := make([]string, 0)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, ok := schemaFieldName(f)
+			if !ok {
+				continue
+			}
+			props[name] = typeSchema(f.Type)
+			if f.Tag.Get("schema") == "required" {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+//JobSchema returns the JSON schema (draft-07) describing job files
+func JobSchema() ([]byte, error) {
+	schema := typeSchema(reflect.TypeOf(Job{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["$id"] = SchemaID
+	schema["title"] = "restic-cronned job"
+	props := schema["properties"].(map[string]interface{})
+	props["$schema"] = map[string]interface{}{"type": "string"}
+	props["SchemaVersion"] = map[string]interface{}{"type": "integer", "minimum": 0, "maximum": CurrentSchemaVersion}
+	return json.MarshalIndent(schema, "", "    ")
+}
@@ -0,0 +1,115 @@
+package jobs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func loadJobFromString(t *testing.T, content string) (*Job, error) {
+	f, err := ioutil.TempFile("", "job*.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(content)
+	f.Seek(0, 0)
+	defer f.Close()
+	return LoadJobFromFile(f)
+}
+
+func TestStrictDecoding(t *testing.T) {
+	StrictDecoding = true
+	_, err := loadJobFromString(t, `{"JobName": "A", "Preconditons": {}}`)
+	if err == nil {
+		t.Fatal("Misspelled key was accepted")
+	}
+	if !strings.Contains(err.Error(), `did you mean "Preconditions"`) {
+		t.Error("No suggestion for misspelled key: " + err.Error())
+	}
+
+	_, err = loadJobFromString(t, `{"JobName": "A", "maxFailedRetries": "three"}`)
+	if err == nil || !strings.Contains(err.Error(), "maxFailedRetries") {
+		t.Error("Type error doesnt name the key")
+	}
+
+	//encoding/json would take these
+	_, err = loadJobFromString(t, `{"JobName": "A", "regulartimer": "@every 1h"}`)
+	if err == nil || !strings.Contains(err.Error(), `did you mean "regularTimer"`) {
+		t.Errorf("Key in the wrong case accepted: %v", err)
+	}
+	_, err = loadJobFromString(t, `{"JobName": "A", "Ping": {"url": "https://hc-ping.com/x"}}`)
+	if err == nil || !strings.Contains(err.Error(), `"url"`) {
+		t.Errorf("Nested key in the wrong case accepted: %v", err)
+	}
+	for _, runtime := range []string{`"Paused": true`, `"CurrentRetry": 3`, `"WaitStart": 1`, `"status": "stopped"`} {
+		if _, err = loadJobFromString(t, `{"JobName": "A", `+runtime+`}`); err == nil {
+			t.Errorf("Runtime state set from the file: %s", runtime)
+		}
+	}
+
+	StrictDecoding = false
+	defer func() { StrictDecoding = true }()
+	job, err := loadJobFromString(t, `{"jobname": "A", "Preconditons": {}, "Paused": true, "regulartimer": "@every 1h", "Ping": {"url": "https://hc-ping.com/x"}}`)
+	if err != nil || job.JobName != "A" {
+		t.Fatalf("Unknown key rejected with strict decoding disabled: %v", err)
+	}
+	if job.Paused {
+		t.Error("Runtime state set from the file with strict decoding disabled")
+	}
+	//encoding/json took keys in any case before the keys were checked, old files must keep working
+	if job.RegularTimer != "@every 1h" || job.Ping == nil || job.Ping.URL != "https://hc-ping.com/x" {
+		t.Errorf("Keys in the wrong case dropped with strict decoding disabled: %q %+v", job.RegularTimer, job.Ping)
+	}
+	//the key in the right case wins
+	job, err = loadJobFromString(t, `{"JobName": "A", "regularTimer": "@every 2h", "regulartimer": "@every 1h"}`)
+	if err != nil || job.RegularTimer != "@every 2h" {
+		t.Errorf("Wrong key taken: %v", err)
+	}
+}
+
+func TestSchemaVersionUpgrade(t *testing.T) {
+	job, err := loadJobFromString(t, `{"JobName": "A", "regularTimer": "@every 1h"}`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if job.SchemaVersion != CurrentSchemaVersion {
+		t.Error("Unversioned job was not upgraded")
+	}
+
+	_, err = loadJobFromString(t, `{"JobName": "A", "SchemaVersion": 999}`)
+	if err == nil {
+		t.Error("Job from the future was accepted")
+	}
+}
+
+func TestPublishedSchemaUpToDate(t *testing.T) {
+	published, err := ioutil.ReadFile("../../schema/job.schema.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	generated, err := JobSchema()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(bytes.TrimSpace(published), bytes.TrimSpace(generated)) {
+		t.Error("schema/job.schema.json is outdated, regenerate it with rc-daemon --schema")
+	}
+}
+
+func TestJobNames(t *testing.T) {
+	for _, name := range []string{"../x", "a/b", `a\b`, "..", ".hidden"} {
+		if _, err := loadJobFromString(t, `{"JobName": "`+strings.Replace(name, `\`, `\\`, -1)+`"}`); err == nil {
+			t.Errorf("JobName %q accepted", name)
+		}
+	}
+	if _, err := loadJobFromString(t, `{"JobName": "backup-home.daily"}`); err != nil {
+		t.Error(err.Error())
+	}
+	queue := &JobQueue{Directory: os.TempDir()}
+	if _, _, _, err := queue.PutJob("../x", []byte(`{}`), ""); err == nil {
+		t.Error("PUT of a job outside the job directory accepted")
+	}
+}
@@ -0,0 +1,243 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//SnapshotFilter selects the snapshots of a job in its repository, passed to restic snapshots as --tag, --path and --host
+type SnapshotFilter struct {
+	Tags  []string `json:"Tags"`
+	Paths []string `json:"Paths"`
+	Host  string   `json:"Host"`
+}
+
+//Staleness tells if the newest backup of a job is older than its MaxAge (the recovery point objective)
+type Staleness struct {
+	MaxAge time.Duration
+	Stale  bool
+	//the newest backup, zero if there is none: the newest snapshot with MaxAgeSnapshots, the last success without
+	Newest time.Time
+	//when the job became stale
+	Since time.Time
+	//why the snapshots could not be listed the last time
+	Error string
+}
+
+//staleCheckInterval is how often the age of the newest backup is checked
+var staleCheckInterval = time.Minute
+
+//snapshotCheckInterval is how often the snapshots are listed, besides after every run
+var snapshotCheckInterval = time.Hour
+
+//snapshotTimeout limits the commands that only read the repository, like the listing of the snapshots
+const snapshotTimeout = 5 * time.Minute
+
+//staleState is what the checks of the MaxAge found out
+type staleState struct {
+	sync.Mutex
+	//when the job started to be watched, the age of a job that never made a backup counts from here
+	watching time.Time
+	stale    bool
+	since    time.Time
+	//the newest snapshot found, when the snapshots were last listed and why that failed
+	snapshot time.Time
+	found    bool
+	checked  time.Time
+	err      string
+}
+
+//watchStale checks the age of the newest backup until done is closed, when the job is stopped
+func (job *Job) watchStale(done chan struct{}) {
+	if job.maxAge <= 0 {
+		return
+	}
+	watching := job.watchingSince(time.Now())
+	job.staleness.Lock()
+	job.staleness.watching = watching
+	job.staleness.Unlock()
+	ticker := time.NewTicker(staleCheckInterval)
+	defer ticker.Stop()
+	for {
+		job.checkStale(time.Now())
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//watchingSince returns when the MaxAge of the job was first watched, now if never. It is persisted, a job that never
+//made a backup must become stale even if the daemon restarts more often than MaxAge
+func (job *Job) watchingSince(now time.Time) time.Time {
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	if job.state.WatchingSince.IsZero() {
+		job.state.WatchingSince = now
+		job.saveState()
+	}
+	return job.state.WatchingSince
+}
+
+//recheckSnapshots makes the next check list the snapshots again, a run may have made a new one
+func (job *Job) recheckSnapshots() {
+	job.staleness.Lock()
+	defer job.staleness.Unlock()
+	job.staleness.checked = time.Time{}
+}
+
+//checkStale compares the newest backup with the MaxAge and publishes an event when the job becomes stale or fresh again
+func (job *Job) checkStale(now time.Time) {
+	if job.MaxAgeSnapshots != nil {
+		job.staleness.Lock()
+		due := job.staleness.checked.IsZero() || now.Sub(job.staleness.checked) >= snapshotCheckInterval
+		job.staleness.Unlock()
+		if due {
+			newest, found, err := job.newestSnapshot()
+			job.staleness.Lock()
+			job.staleness.checked = now
+			job.staleness.err = ""
+			if err != nil {
+				job.staleness.err = err.Error()
+				job.logger().WithFields(log.Fields{"Error": err.Error()}).Warning("Snapshots could not be listed")
+			} else {
+				job.staleness.snapshot, job.staleness.found = newest, found
+			}
+			job.staleness.Unlock()
+		}
+	}
+
+	staleness := job.Staleness()
+	age := staleness.Newest
+	if age.IsZero() {
+		job.staleness.Lock()
+		age = job.staleness.watching
+		job.staleness.Unlock()
+	}
+	stale := now.Sub(age) > job.maxAge
+
+	job.staleness.Lock()
+	changed := stale != job.staleness.stale
+	job.staleness.stale = stale
+	if changed && stale {
+		job.staleness.since = age.Add(job.maxAge)
+	}
+	job.staleness.Unlock()
+	if !changed {
+		return
+	}
+	fields := log.Fields{"MaxAge": job.MaxAge, "Newest": staleness.Newest}
+	if stale {
+		job.logger().WithFields(fields).Warning("Stale, the newest backup is older than MaxAge")
+		job.emit(Event{Type: EventJobStale, At: staleness.Newest})
+	} else {
+		job.logger().WithFields(fields).Info("Fresh again")
+		job.emit(Event{Type: EventJobFresh, At: staleness.Newest})
+	}
+}
+
+//Staleness returns the result of the last check of the MaxAge. Jobs without MaxAge are never stale
+func (job *Job) Staleness() Staleness {
+	result := Staleness{MaxAge: job.maxAge}
+	if job.maxAge <= 0 {
+		return result
+	}
+	lastSuccess := job.lastSuccess()
+	job.staleness.Lock()
+	defer job.staleness.Unlock()
+	result.Stale = job.staleness.stale
+	result.Error = job.staleness.err
+	if result.Stale {
+		result.Since = job.staleness.since
+	}
+	switch {
+	case job.MaxAgeSnapshots == nil:
+		result.Newest = lastSuccess
+	case job.staleness.found:
+		result.Newest = job.staleness.snapshot
+	case job.staleness.checked.IsZero() || job.staleness.err != "":
+		//the snapshots were not listed yet, the last success is the best guess until then
+		result.Newest = lastSuccess
+	}
+	return result
+}
+
+//newestSnapshot asks restic for the time of the newest snapshot that matches the MaxAgeSnapshots of the job
+func (job *Job) newestSnapshot() (time.Time, bool, error) {
+	filter := job.MaxAgeSnapshots
+	now := time.Now()
+	args := []string{"snapshots", "--json", "--no-lock", "--latest", "1"}
+	for _, tag := range filter.Tags {
+		args = append(args, "--tag", job.expand(tag, now))
+	}
+	for _, p := range filter.Paths {
+		args = append(args, "--path", job.expand(p, now))
+	}
+	if filter.Host != "" {
+		args = append(args, "--host", job.expand(filter.Host, now))
+	}
+	out, err := job.query(args)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	var snapshots []struct {
+		Time time.Time `json:"time"`
+	}
+	if err = json.Unmarshal(out, &snapshots); err != nil {
+		return time.Time{}, false, fmt.Errorf("unexpected output of restic snapshots: %s", err.Error())
+	}
+	var newest time.Time
+	for _, snapshot := range snapshots {
+		if snapshot.Time.After(newest) {
+			newest = snapshot.Time
+		}
+	}
+	return newest, len(snapshots) > 0, nil
+}
+
+//query runs a restic command of the job that only reads the repository and returns what it printed
+func (job *Job) query(resticArgs []string) ([]byte, error) {
+	args := resticArgs
+	if job.repository() == nil {
+		if repo := job.getRepo(); repo != "" {
+			args = append([]string{"-r", repo}, args...)
+		}
+	}
+	binary, args, env := job.command(args)
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok && len(exitError.Stderr) > 0 {
+			return nil, fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(string(exitError.Stderr)))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+//RepositorySize asks restic how much data the repository of the job holds, as stats --mode raw-data counts it
+func (job *Job) RepositorySize() (uint64, error) {
+	out, err := job.query([]string{"stats", "--json", "--no-lock", "--mode", "raw-data"})
+	if err != nil {
+		return 0, err
+	}
+	var stats struct {
+		TotalSize *uint64 `json:"total_size"`
+	}
+	if err = json.Unmarshal(out, &stats); err != nil || stats.TotalSize == nil {
+		return 0, fmt.Errorf("unexpected output of restic stats: %s", strings.TrimSpace(string(out)))
+	}
+	return *stats.TotalSize, nil
+}
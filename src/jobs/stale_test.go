@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	valid := map[string]time.Duration{"26h": 26 * time.Hour, "7d": 7 * 24 * time.Hour, "1d12h": 36 * time.Hour, "90m": 90 * time.Minute}
+	for s, expected := range valid {
+		if dur, err := ParseDuration(s); err != nil || dur != expected {
+			t.Errorf("%s parsed as %v, %v", s, dur, err)
+		}
+	}
+	for _, s := range []string{"", "d", "7days"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("%s accepted", s)
+		}
+	}
+	for _, maxAge := range []string{"-1h", "0s"} {
+		if _, err := loadJobFromString(t, `{"JobName": "A", "MaxAge": "`+maxAge+`"}`); err == nil {
+			t.Errorf("MaxAge %s accepted", maxAge)
+		}
+	}
+	//the conditions of the follow ups take the same durations
+	job, err := loadJobFromString(t, `{"JobName": "A", "NextJob": "B", "FollowUpConditions": {"B": {"MinInterval": "1d12h"}}}`)
+	if err != nil || job.FollowUpConditions["B"].minInterval != 36*time.Hour {
+		t.Errorf("MinInterval not parsed: %v", err)
+	}
+}
+
+func TestStaleAcrossRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-stale")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	oldDir := StateDir
+	StateDir = dir
+	defer func() { StateDir = oldDir }()
+
+	start := time.Now().Add(-2 * time.Hour)
+	job := newJob()
+	job.JobName = "A"
+	if since := job.watchingSince(start); !since.Equal(start) {
+		t.Fatalf("Watching since %v", since)
+	}
+	//the daemon restarted, the job still counts from the first start
+	restarted := newJob()
+	restarted.JobName = "A"
+	restarted.loadState()
+	if since := restarted.watchingSince(time.Now()); !since.Equal(start) {
+		t.Errorf("Watching restarted at %v", since)
+	}
+}
+
+//staleEvents returns the types of the stale events published so far
+func staleEvents(queue *JobQueue) []string {
+	missed, _, cancel := queue.Events().Subscribe(0)
+	cancel()
+	types := make([]string, 0)
+	for _, event := range missed {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestStaleLastSuccess(t *testing.T) {
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	job := newJob()
+	job.JobName = "A"
+	job.jobstore = queue
+	job.MaxAge = "1h"
+	job.maxAge = time.Hour
+	start := time.Now()
+	job.staleness.watching = start
+
+	//a job that never succeeded is stale MaxAge after it started to be watched
+	job.checkStale(start.Add(30 * time.Minute))
+	if job.Staleness().Stale {
+		t.Error("Stale too early")
+	}
+	job.checkStale(start.Add(61 * time.Minute))
+	staleness := job.Staleness()
+	if !staleness.Stale || !staleness.Since.Equal(start.Add(time.Hour)) {
+		t.Errorf("Not stale: %+v", staleness)
+	}
+	job.checkStale(start.Add(62 * time.Minute))
+
+	job.recordSuccess(start.Add(90 * time.Minute))
+	job.checkStale(start.Add(91 * time.Minute))
+	if staleness := job.Staleness(); staleness.Stale || !staleness.Newest.Equal(start.Add(90*time.Minute)) {
+		t.Errorf("Still stale: %+v", staleness)
+	}
+	if types := staleEvents(queue); len(types) != 2 || types[0] != EventJobStale || types[1] != EventJobFresh {
+		t.Errorf("Wrong events %v", types)
+	}
+
+	noMaxAge := newJob()
+	noMaxAge.checkStale(time.Now().Add(1000 * time.Hour))
+	if noMaxAge.Staleness().Stale {
+		t.Error("Job without MaxAge is stale")
+	}
+}
+
+func TestStaleSnapshots(t *testing.T) {
+	script, err := ioutil.TempFile("", "snapshots*.sh")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(script.Name())
+	//prints the arguments to a file and the snapshots that are in the file SNAPSHOTS
+	script.WriteString("#!/bin/sh\necho \"$@\" > \"$ARGS\"\ncat \"$SNAPSHOTS\"\n")
+	script.Close()
+	os.Chmod(script.Name(), 0700)
+	dir, err := ioutil.TempDir("", "rc-stale")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("ARGS", dir+"/args")
+	os.Setenv("SNAPSHOTS", dir+"/snapshots")
+	defer os.Unsetenv("ARGS")
+	defer os.Unsetenv("SNAPSHOTS")
+
+	job := newJob()
+	job.JobName = "A"
+	job.jobstore = &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	job.ResticPath = script.Name()
+	job.ResticArguments = []string{"-r", "/srv/restic", "backup", "/home"}
+	job.MaxAgeSnapshots = &SnapshotFilter{Tags: []string{"${job.name}"}, Paths: []string{"/home"}}
+	job.maxAge = 24 * time.Hour
+	now := time.Now()
+	job.staleness.watching = now
+	//a success that made no snapshot of the paths does not count
+	job.recordSuccess(now)
+
+	old := now.Add(-48 * time.Hour).UTC().Format(time.RFC3339Nano)
+	ioutil.WriteFile(dir+"/snapshots", []byte(`[{"time": "`+old+`", "paths": ["/home"]}]`), 0600)
+	job.checkStale(now)
+	staleness := job.Staleness()
+	if !staleness.Stale || staleness.Newest.Format(time.RFC3339Nano) != old || staleness.Error != "" {
+		t.Errorf("Old snapshot not found: %+v", staleness)
+	}
+	args, _ := ioutil.ReadFile(dir + "/args")
+	if string(args) != "-r /srv/restic snapshots --json --no-lock --latest 1 --tag A --path /home\n" {
+		t.Errorf("Wrong arguments %q", args)
+	}
+
+	//the snapshots are listed again after a run, not at every check
+	ioutil.WriteFile(dir+"/snapshots", []byte(`[{"time": "`+now.UTC().Format(time.RFC3339Nano)+`"}]`), 0600)
+	job.checkStale(now.Add(time.Minute))
+	if !job.Staleness().Stale {
+		t.Error("Snapshots listed at every check")
+	}
+	job.recheckSnapshots()
+	job.checkStale(now.Add(2 * time.Minute))
+	if job.Staleness().Stale {
+		t.Error("New snapshot not found")
+	}
+
+	//an error keeps the snapshot found before
+	ioutil.WriteFile(dir+"/snapshots", []byte(`no json`), 0600)
+	job.recheckSnapshots()
+	job.checkStale(now.Add(3 * time.Minute))
+	if staleness := job.Staleness(); staleness.Stale || staleness.Error == "" {
+		t.Errorf("Error not reported: %+v", staleness)
+	}
+}
+
+func TestRepositorySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-stats")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	//prints the arguments to a file and the stats that are in the file stats
+	script := dir + "/stats.sh"
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+dir+"/args\ncat "+dir+"/stats\n"), 0700)
+
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = script
+	job.ResticArguments = []string{"-r", "/srv/restic", "backup", "/home"}
+	ioutil.WriteFile(dir+"/stats", []byte(`{"total_size":1048576,"total_file_count":3}`), 0600)
+	size, err := job.RepositorySize()
+	if err != nil || size != 1048576 {
+		t.Errorf("Wrong size %d: %v", size, err)
+	}
+	args, _ := ioutil.ReadFile(dir + "/args")
+	if string(args) != "-r /srv/restic stats --json --no-lock --mode raw-data\n" {
+		t.Errorf("Wrong arguments %q", args)
+	}
+
+	ioutil.WriteFile(dir+"/stats", []byte(`{}`), 0600)
+	if _, err := job.RepositorySize(); err == nil {
+		t.Error("Missing size not reported")
+	}
+}
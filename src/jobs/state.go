@@ -0,0 +1,177 @@
+package jobs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//StateDir is the directory the state of the jobs (counters, last success, ...) is persisted in, so it survives restarts.
+//Nothing is persisted if it is empty
+var StateDir = ""
+
+//jobState is the part of a job that is not defined in its file but changes while it runs and must survive restarts
+type jobState struct {
+	mutex sync.Mutex
+	//the last time the job succeeded
+	LastSuccess time.Time `json:"LastSuccess"`
+	//how often each follow up edge had the chance to fire and when it last fired. Keyed by "kind:job"
+	EdgeCounts    map[string]int       `json:"EdgeCounts"`
+	EdgeLastFired map[string]time.Time `json:"EdgeLastFired"`
+	//paused and skip-next, see pause.go
+	Paused   bool `json:"Paused"`
+	SkipNext bool `json:"SkipNext"`
+	//the id of the last run, see logs.go
+	LastRunID int `json:"LastRunID"`
+	//the last failed run and the failed runs since the last success, see metrics.go
+	LastFailure         time.Time `json:"LastFailure"`
+	ConsecutiveFailures int       `json:"ConsecutiveFailures"`
+	//when the MaxAge of the job was first watched, the age of a job that never made a backup counts from here
+	WatchingSince time.Time `json:"WatchingSince"`
+}
+
+func newJobState() *jobState {
+	return &jobState{
+		EdgeCounts:    make(map[string]int),
+		EdgeLastFired: make(map[string]time.Time),
+	}
+}
+
+func (job *Job) statePath() string {
+	if StateDir == "" {
+		return ""
+	}
+	return path.Join(StateDir, job.JobName+".state.json")
+}
+
+//loadState reads the persisted state of the job, if there is any
+func (job *Job) loadState() {
+	file := job.statePath()
+	if file == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			job.logger().WithFields(log.Fields{"Error": err.Error()}).Warning("Could not read state")
+		}
+		return
+	}
+	state := newJobState()
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		job.logger().WithFields(log.Fields{"Error": err.Error()}).Warning("Could not decode state")
+		return
+	}
+	if state.EdgeCounts == nil {
+		state.EdgeCounts = make(map[string]int)
+	}
+	if state.EdgeLastFired == nil {
+		state.EdgeLastFired = make(map[string]time.Time)
+	}
+	job.state = state
+	job.Paused = state.Paused
+	job.SkipNext = state.SkipNext
+}
+
+//saveState persists the state of the job. The caller must hold the state mutex
+func (job *Job) saveState() {
+	file := job.statePath()
+	if file == "" {
+		return
+	}
+	data, err := json.MarshalIndent(job.state, "", "    ")
+	if err != nil {
+		job.logger().WithFields(log.Fields{"Error": err.Error()}).Warning("Could not encode state")
+		return
+	}
+	err = os.MkdirAll(StateDir, 0700)
+	if err == nil {
+		//write to a temporary file first so a crash can not leave a half written state behind
+		err = ioutil.WriteFile(file+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+	if err != nil {
+		job.logger().WithFields(log.Fields{"Error": err.Error()}).Warning("Could not write state")
+	}
+}
+
+//recordSuccess remembers the time of the last success
+func (job *Job) recordSuccess(at time.Time) {
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	job.state.LastSuccess = at
+	job.saveState()
+}
+
+//lastSuccess returns the time the job last succeeded (zero if never)
+func (job *Job) lastSuccess() time.Time {
+	job.state.mutex.Lock()
+	defer job.state.mutex.Unlock()
+	return job.state.LastSuccess
+}
+
+//queueState is the state of the whole queue that must survive restarts
+type queueState struct {
+	Maintenance bool `json:"Maintenance"`
+}
+
+//queueStatePath can not clash with the state of a job, these all end in .state.json
+func queueStatePath() string {
+	if StateDir == "" {
+		return ""
+	}
+	return path.Join(StateDir, "queue.json")
+}
+
+//loadState reads the persisted state of the queue, if there is any
+func (queue *JobQueue) loadState() {
+	file := queueStatePath()
+	if file == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"Error": err.Error()}).Warning("Could not read queue state")
+		}
+		return
+	}
+	var state queueState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		log.WithFields(log.Fields{"Error": err.Error()}).Warning("Could not decode queue state")
+		return
+	}
+	queue.maintenanceMutex.Lock()
+	defer queue.maintenanceMutex.Unlock()
+	queue.maintenance = state.Maintenance
+}
+
+//saveState persists the state of the queue. The caller must hold the maintenance mutex
+func (queue *JobQueue) saveState() {
+	file := queueStatePath()
+	if file == "" {
+		return
+	}
+	data, err := json.MarshalIndent(queueState{Maintenance: queue.maintenance}, "", "    ")
+	if err == nil {
+		err = os.MkdirAll(StateDir, 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(file+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"Error": err.Error()}).Warning("Could not write queue state")
+	}
+}
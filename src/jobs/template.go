@@ -0,0 +1,294 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//definition is a raw (already upgraded) job definition and the file it was read from
+type definition struct {
+	raw  map[string]interface{}
+	file string
+}
+
+//name returns the JobName of the definition or "" if it has none
+func (def *definition) name() string {
+	name, _ := def.raw["JobName"].(string)
+	return name
+}
+
+//isTemplate checks if the definition is only there to be extended
+func (def *definition) isTemplate() bool {
+	template, _ := def.raw["Template"].(bool)
+	return template
+}
+
+//keys that are not passed down from a definition to the definitions extending it
+var notInherited = []string{"Template", "Extends"}
+
+//resolveExtends merges the definition with the chain of definitions it extends. Values of the extending definition win,
+//objects (like Preconditions) are merged key by key, lists are replaced as a whole
+func resolveExtends(def *definition, byName map[string]*definition) (map[string]interface{}, error) {
+	chain := []*definition{def}
+	seen := map[string]bool{def.name(): true}
+	current := def
+	for {
+		parentName, _ := current.raw["Extends"].(string)
+		if parentName == "" {
+			break
+		}
+		if seen[parentName] {
+			return nil, fmt.Errorf("Extends cycle at %q", parentName)
+		}
+		parent, ok := byName[parentName]
+		if !ok {
+			return nil, fmt.Errorf("Extends unknown template %q", parentName)
+		}
+		seen[parentName] = true
+		chain = append(chain, parent)
+		current = parent
+	}
+
+	merged := make(map[string]interface{})
+	for i := len(chain) - 1; i >= 0; i-- {
+		raw := deepCopy(chain[i].raw).(map[string]interface{})
+		if i > 0 {
+			for _, key := range notInherited {
+				delete(raw, key)
+			}
+		}
+		mergeInto(merged, raw)
+	}
+	return merged, nil
+}
+
+//mergeInto overwrites dst with the values of src. Maps present in both are merged recursively
+func mergeInto(dst, src map[string]interface{}) {
+	for key, val := range src {
+		srcMap, srcIsMap := val.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeInto(dstMap, srcMap)
+			continue
+		}
+		dst[key] = val
+	}
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for key, val := range t {
+			m[key] = deepCopy(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for idx, val := range t {
+			l[idx] = deepCopy(val)
+		}
+		return l
+	case []map[string]interface{}:
+		l := make([]interface{}, len(t))
+		for idx, val := range t {
+			l[idx] = deepCopy(val)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+var paramPattern = regexp.MustCompile(`\$\{param:([A-Za-z0-9_.-]+)\}`)
+
+//instantiate creates one definition per entry in "Instances" and per match of "InstancesGlob".
+//Glob matches provide the parameters "path" (the match) and "name" (its base name).
+//Definitions without instances are returned as they are
+func instantiate(raw map[string]interface{}) ([]map[string]interface{}, error) {
+	params := make([]map[string]string, 0)
+	if list, ok := raw["Instances"]; ok {
+		items, ok := list.([]interface{})
+		if !ok {
+			return nil, errors.New("Instances must be a list of objects")
+		}
+		for idx, item := range items {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Instances entry %d is not an object", idx)
+			}
+			p := make(map[string]string, len(obj))
+			for key, val := range obj {
+				p[key] = fmt.Sprint(val)
+			}
+			params = append(params, p)
+		}
+	}
+	if glob, ok := raw["InstancesGlob"].(string); ok && glob != "" {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, fmt.Errorf("InstancesGlob: %s", err.Error())
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			params = append(params, map[string]string{"path": match, "name": filepath.Base(match)})
+		}
+	}
+
+	_, hasInstances := raw["Instances"]
+	_, hasGlob := raw["InstancesGlob"]
+	if !hasInstances && !hasGlob {
+		if err := substituteParams(raw, nil); err != nil {
+			return nil, err
+		}
+		return []map[string]interface{}{raw}, nil
+	}
+
+	delete(raw, "Instances")
+	delete(raw, "InstancesGlob")
+	result := make([]map[string]interface{}, 0, len(params))
+	for _, p := range params {
+		instance := deepCopy(raw).(map[string]interface{})
+		err := substituteParams(instance, p)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, instance)
+	}
+	return result, nil
+}
+
+//substituteParams replaces ${param:NAME} in all strings of v. Unknown parameters are an error
+func substituteParams(v interface{}, params map[string]string) error {
+	var firstErr error
+	replace := func(s string) string {
+		return paramPattern.ReplaceAllStringFunc(s, func(m string) string {
+			key := paramPattern.FindStringSubmatch(m)[1]
+			val, ok := params[key]
+			if !ok && firstErr == nil {
+				known := make([]string, 0, len(params))
+				for k := range params {
+					known = append(known, k)
+				}
+				sort.Strings(known)
+				firstErr = fmt.Errorf("unknown parameter %q (known: %s)", key, strings.Join(known, ", "))
+			}
+			return val
+		})
+	}
+	var walk func(v interface{}) interface{}
+	walk = func(v interface{}) interface{} {
+		switch t := v.(type) {
+		case string:
+			return replace(t)
+		case map[string]interface{}:
+			for key, val := range t {
+				t[key] = walk(val)
+			}
+			return t
+		case []interface{}:
+			for idx, val := range t {
+				t[idx] = walk(val)
+			}
+			return t
+		default:
+			return v
+		}
+	}
+	walk(v)
+	return firstErr
+}
+
+//definitionError is an error in a definition, file is the file it comes from
+type definitionError struct {
+	file string
+	err  error
+}
+
+func (err *definitionError) Error() string {
+	return err.file + ": " + err.err.Error()
+}
+
+func (err *definitionError) Unwrap() error {
+	return err.err
+}
+
+//buildJobs resolves templates and instances of the definitions and creates the jobs.
+//Definitions that can not be turned into jobs are reported in the error list, the other jobs are still returned
+func buildJobs(defs []*definition) ([]*Job, []error) {
+	return buildJobsOf(defs, func(*definition) bool { return true })
+}
+
+//buildJobsOf builds only the jobs of the wanted definitions, the others are only there to be extended.
+//Building a job reads its password, the jobs that are not needed should not ask the keyring
+func buildJobsOf(defs []*definition, wanted func(*definition) bool) ([]*Job, []error) {
+	jobs := make([]*Job, 0, len(defs))
+	errs := make([]error, 0)
+
+	byName := make(map[string]*definition)
+	for _, def := range defs {
+		name := def.name()
+		if name == "" {
+			continue
+		}
+		if other, exists := byName[name]; exists {
+			errs = append(errs, &definitionError{def.file, fmt.Errorf("duplicate JobName %q (already defined in %s), ignoring", name, other.file)})
+			continue
+		}
+		byName[name] = def
+	}
+
+	names := make(map[string]bool)
+	for _, def := range defs {
+		if def.isTemplate() || !wanted(def) {
+			continue
+		}
+		if d, ok := byName[def.name()]; ok && d != def {
+			continue
+		}
+		merged, err := resolveExtends(def, byName)
+		if err != nil {
+			errs = append(errs, &definitionError{def.file, fmt.Errorf("%s: %s", def.name(), err.Error())})
+			continue
+		}
+		instances, err := instantiate(merged)
+		if err != nil {
+			errs = append(errs, &definitionError{def.file, fmt.Errorf("%s: %s", def.name(), err.Error())})
+			continue
+		}
+		for _, raw := range instances {
+			expanded := deepCopy(raw).(map[string]interface{})
+			job, err := newJobFromDefinition(raw)
+			if err != nil {
+				errs = append(errs, &definitionError{def.file, fmt.Errorf("%s: %s", def.name(), err.Error())})
+				continue
+			}
+			if names[job.JobName] {
+				errs = append(errs, &definitionError{def.file, fmt.Errorf("duplicate JobName %q, ignoring", job.JobName)})
+				continue
+			}
+			names[job.JobName] = true
+			job.sourceFile = def.file
+			job.definition = expanded
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, errs
+}
+
+//Definition returns the definition of the job after templates and instances were resolved
+func (job *Job) Definition() map[string]interface{} {
+	if job.definition == nil {
+		return nil
+	}
+	return deepCopy(job.definition).(map[string]interface{})
+}
+
+//SourceFile returns the file that defines the job
+func (job *Job) SourceFile() string {
+	return job.sourceFile
+}
@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	srv := writeJobFiles(t, map[string]string{"www.txt": "", "git.txt": ""})
+	defer os.RemoveAll(srv)
+
+	dir := writeJobFiles(t, map[string]string{
+		"templates.yaml": `
+- JobName: backup-template
+  Template: true
+  regularTimer: "0 0 2 * * *"
+  retryTimer: "0 0 * * * *"
+  maxFailedRetries: 3
+  Preconditions:
+    HostsMustRoute: [nas]
+    PathesMust: [/mnt/nas]
+  ResticArguments: [backup, "${param:path}"]
+`,
+		"jobs.yaml": `
+- JobName: home
+  Extends: backup-template
+  regularTimer: "0 30 3 * * *"
+  Preconditions:
+    PathesMust: [/home]
+  ResticArguments: [backup, /home]
+- JobName: "dir-${param:name}"
+  Extends: backup-template
+  Instances:
+    - {name: etc, path: /etc}
+    - {name: opt, path: /opt}
+- JobName: "srv-${param:name}"
+  Extends: backup-template
+  InstancesGlob: "` + path.Join(srv, "*.txt") + `"
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	js, err := FindJobs(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	found := make(map[string]*Job)
+	for _, j := range js {
+		found[j.JobName] = j
+	}
+	if found["backup-template"] != nil {
+		t.Error("Template was loaded as a job")
+	}
+
+	home := found["home"]
+	if home == nil {
+		t.Fatal("Job extending a template not loaded")
+	}
+	if home.RegularTimer != "0 30 3 * * *" || home.RetryTimer != "0 0 * * * *" || home.MaxFailedRetries != 3 {
+		t.Error("Fields not correctly inherited/overridden")
+	}
+	if len(home.Preconditions.HostsMustRoute) != 1 || home.Preconditions.PathesMust[0] != "/home" {
+		t.Error("Preconditions not merged")
+	}
+
+	etc := found["dir-etc"]
+	if etc == nil || found["dir-opt"] == nil {
+		t.Fatal("Instances not generated")
+	}
+	if strings.Join(etc.ResticArguments, " ") != "backup /etc" {
+		t.Error("Parameters not substituted: " + strings.Join(etc.ResticArguments, " "))
+	}
+	if etc.Definition()["JobName"] != "dir-etc" {
+		t.Error("Expanded definition not stored")
+	}
+
+	git := found["srv-git.txt"]
+	if git == nil || found["srv-www.txt"] == nil {
+		t.Fatal("Glob instances not generated")
+	}
+	if git.ResticArguments[1] != path.Join(srv, "git.txt") {
+		t.Error("Glob match not substituted: " + git.ResticArguments[1])
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	_, err := loadJobFromString(t, `[{"JobName": "A", "Extends": "B"}, {"JobName": "B", "Extends": "A"}]`)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Error("Extends cycle not detected")
+	}
+	_, err = loadJobFromString(t, `{"JobName": "A", "Extends": "nothing"}`)
+	if err == nil {
+		t.Error("Unknown template accepted")
+	}
+	_, err = loadJobFromString(t, `{"JobName": "A-${param:name}", "Instances": [{"path": "/etc"}]}`)
+	if err == nil || !strings.Contains(err.Error(), "unknown parameter") {
+		t.Error("Unknown parameter accepted")
+	}
+}
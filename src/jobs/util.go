@@ -1,55 +1,230 @@
 package jobs
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/robfig/cron"
 	"io/ioutil"
 	"os"
 	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
 
-//LoadJobFromFile loads  job from a file
+//LoadJobFromFile loads  job from a file. The file must contain exactly one job
 func LoadJobFromFile(file *os.File) (*Job, error) {
+	jobs, err := LoadJobsFromFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 1 {
+		return nil, fmt.Errorf("expected exactly one job in %s, found %d", file.Name(), len(jobs))
+	}
+	return jobs[0], nil
+}
+
+//LoadJobsFromFile loads all jobs defined in a file. The format is chosen by the file extension (json, yaml/yml, toml).
+//Templates can only be extended by jobs in the same file, use FindJobs to resolve them across files
+func LoadJobsFromFile(file *os.File) ([]*Job, error) {
+	defs, err := readDefinitions(file)
+	if err != nil {
+		return nil, err
+	}
+	jobs, errs := buildJobs(defs)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return jobs, nil
+}
+
+//readDefinitions reads and upgrades all job definitions in the file
+func readDefinitions(file *os.File) ([]*definition, error) {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	raws, err := parseDefinitions(data, file.Name(), "Jobs")
+	if err != nil {
+		return nil, err
+	}
+
+	defs := make([]*definition, 0, len(raws))
+	for idx, raw := range raws {
+		//editors use this key to find the schema, it is not part of the job itself
+		delete(raw, "$schema")
+		err = upgradeDefinition(raw)
+		if err != nil {
+			if len(raws) > 1 {
+				return nil, fmt.Errorf("job %d: %s", idx, err.Error())
+			}
+			return nil, err
+		}
+		defs = append(defs, &definition{raw: raw, file: file.Name()})
+	}
+	return defs, nil
+}
+
+var daysPattern = regexp.MustCompile(`^(\d+)d(.*)$`)
+
+//ParseDuration parses go durations like "26h", and also days which time.ParseDuration does not know: "7d", "1d12h"
+func ParseDuration(s string) (time.Duration, error) {
+	var days time.Duration
+	if match := daysPattern.FindStringSubmatch(s); match != nil {
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			return 0, err
+		}
+		days = time.Duration(n) * 24 * time.Hour
+		if s = match[2]; s == "" {
+			return days, nil
+		}
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return dur + days, nil
+}
+
+//validateJobName rejects names that can not be used in file names. The name is part of the paths of the state,
+//the definition and the logs of the job, it must not lead out of their directories
+func validateJobName(name string) error {
+	if name == "" {
+		return errors.New("JobName is missing")
+	}
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("JobName %q can not be used in file names, it must not contain / or \\ or start with a dot", name)
+	}
+	return nil
+}
+
+//newJobFromDefinition decodes one raw job definition and prepares the job to be started
+func newJobFromDefinition(raw map[string]interface{}) (*Job, error) {
 	var job = newJob()
-	jsonParser := json.NewDecoder(file)
-	err := jsonParser.Decode(job)
+	err := decodeStrict(raw, job)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateJobName(job.JobName); err != nil {
+		return nil, err
+	}
+	if job.RepositoryName != "" {
+		if job.repository() == nil {
+			return nil, fmt.Errorf("unknown Repository %q (known: %s)", job.RepositoryName, strings.Join(RepositoryNames(), ", "))
+		}
+		if job.getRepo() != "" {
+			job.logger().Warning("Job references a Repository but also passes -r")
+		}
+	}
+	err = job.validateConditions()
+	if err != nil {
+		return nil, err
+	}
+	err = job.validateAllVariables()
 	if err != nil {
 		return nil, err
 	}
 	job.retrieveAndStorePassword()
+	if job.MaxAge != "" {
+		job.maxAge, err = ParseDuration(job.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("MaxAge: %s", err.Error())
+		}
+		if job.maxAge <= 0 {
+			return nil, errors.New("MaxAge must be positive")
+		}
+	}
+	if job.LogLevel != "" {
+		job.logLevel, err = log.ParseLevel(job.LogLevel)
+		if err != nil {
+			return nil, fmt.Errorf("LogLevel: %s", err.Error())
+		}
+	}
 	if len(job.RegularTimer) > 0 {
 		job.regTimerSchedule, err = cron.Parse(job.RegularTimer)
 		if err != nil {
-			log.WithFields(log.Fields{"Job": job.JobName, "Error": err.Error()}).Warning("Decoding error for the RegularTimer")
+			job.logger().WithFields(log.Fields{"Error": err.Error()}).Warning("Decoding error for the RegularTimer")
 			return nil, err
 		}
 	}
 	if len(job.RetryTimer) > 0 {
 		job.retryTimerSchedule, err = cron.Parse(job.RetryTimer)
 		if err != nil {
-			log.WithFields(log.Fields{"Job": job.JobName, "Error": err.Error()}).Warning("Decoding error for the RetryTimer")
+			job.logger().WithFields(log.Fields{"Error": err.Error()}).Warning("Decoding error for the RetryTimer")
 			return nil, err
 		}
 	}
 	return job, nil
 }
 
-//FindJobs loads all jobs from the path
+//FindJobs loads all jobs from the path. Templates are resolved across all files. If two jobs share a name only the first one found is used
 func FindJobs(dirPath string) ([]*Job, error) {
-	files, err := ioutil.ReadDir(dirPath)
+	defs, err := readDirDefinitions(dirPath)
 	if err != nil {
 		log.Fatal("Error opening the directory: " + err.Error())
-		return make([]*Job, 0), errors.New(dirPath + " is no directory")
+		return make([]*Job, 0), err
 	}
 
-	jobs := make([]*Job, 0)
+	jobs, errs := buildJobs(defs)
+	for _, err := range errs {
+		log.WithFields(log.Fields{"Error": err.Error()}).Warning("Decoding error")
+	}
+	jobs, errs = validateGraph(jobs)
+	for _, err := range errs {
+		log.WithFields(log.Fields{"Error": err.Error()}).Warning("Follow up graph error")
+	}
+	return jobs, nil
+}
+
+//loadFileJobs loads the jobs of one file. The templates it extends from other files are looked up in the directory,
+//but only the jobs of the file are built
+func loadFileJobs(dirPath, file string) ([]*Job, []error, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defs, err := readDefinitions(f)
+	f.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	defined := make(map[string]bool)
+	for _, def := range defs {
+		defined[def.name()] = true
+	}
+	for _, def := range defs {
+		if parent, _ := def.raw["Extends"].(string); parent != "" && !defined[parent] {
+			dirDefs, err := readDirDefinitions(dirPath)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, dirDef := range dirDefs {
+				if dirDef.file != file {
+					defs = append(defs, dirDef)
+				}
+			}
+			break
+		}
+	}
+	jobs, errs := buildJobsOf(defs, func(def *definition) bool { return def.file == file })
+	return jobs, errs, nil
+}
+
+//readDirDefinitions reads the definitions of all job files in the directory. Files that can not be read are skipped
+func readDirDefinitions(dirPath string) ([]*definition, error) {
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, errors.New(dirPath + " is no directory")
+	}
+
+	defs := make([]*definition, 0)
 
 	for _, f := range files {
-		if f.IsDir() || !(strings.HasSuffix(f.Name(), ".json")) {
+		if f.IsDir() || !isJobFile(f.Name()) {
 			continue
 		}
 
@@ -59,14 +234,13 @@ func FindJobs(dirPath string) ([]*Job, error) {
 			continue
 		}
 
-		job, err := LoadJobFromFile(file)
+		fileDefs, err := readDefinitions(file)
+		file.Close()
 		if err != nil {
 			log.WithFields(log.Fields{"File": f.Name(), "Error": err.Error()}).Warning("Decoding error")
 			continue
-		} else {
-			jobs = append(jobs, job)
 		}
+		defs = append(defs, fileDefs...)
 	}
-
-	return jobs, nil
+	return defs, nil
 }
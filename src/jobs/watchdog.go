@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+)
+
+//heartbeatInterval is how often the loop of a job waiting for its trigger shows that it is alive
+var heartbeatInterval = 5 * time.Second
+
+//heartbeat is when the loop of a job last showed that it is alive, and if it is busy with a run or its preconditions
+type heartbeat struct {
+	sync.Mutex
+	last time.Time
+	busy bool
+}
+
+func (job *Job) beat(busy bool) {
+	job.heartbeat.Lock()
+	defer job.heartbeat.Unlock()
+	job.heartbeat.last = time.Now()
+	job.heartbeat.busy = busy
+}
+
+//nextTrigger waits for the next trigger of the job, false if the job was stopped instead. Meanwhile it beats
+func (job *Job) nextTrigger() (jobTrigger, bool) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		job.beat(false)
+		select {
+		case trig := <-job.trigger:
+			return trig, true
+		case <-job.stop:
+			return jobTrigger{}, false
+		case <-ticker.C:
+		}
+	}
+}
+
+//Stuck returns the jobs whose loop did not beat for maxSilence although it is not busy, and "queue" if the locks of
+//the queue could not be taken in that time. A watchdog uses it to find out if the daemon hangs
+func (queue *JobQueue) Stuck(maxSilence time.Duration) []string {
+	stuck := make([]string, 0)
+	now := time.Now()
+	for _, job := range queue.List() {
+		job.heartbeat.Lock()
+		silent := !job.heartbeat.last.IsZero() && !job.heartbeat.busy && now.Sub(job.heartbeat.last) > maxSilence
+		job.heartbeat.Unlock()
+		if silent && job.currentStatus() != statusStopped {
+			stuck = append(stuck, job.JobName)
+		}
+	}
+	locked := make(chan struct{})
+	go func() {
+		queue.locksMutex.Lock()
+		queue.locksMutex.Unlock()
+		queue.events.mutex.Lock()
+		queue.events.mutex.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(maxSilence):
+		stuck = append(stuck, "queue")
+	}
+	return stuck
+}
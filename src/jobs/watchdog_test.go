@@ -0,0 +1,89 @@
+package jobs
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStuck(t *testing.T) {
+	job := newJob()
+	job.JobName = "A"
+	job.ResticPath = "/bin/true"
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	queue.AddJobs(job)
+	defer queue.StopAllJobs()
+	time.Sleep(10 * time.Millisecond)
+	if stuck := queue.Stuck(time.Second); len(stuck) != 0 {
+		t.Errorf("Stuck: %v", stuck)
+	}
+
+	//a loop that is not busy and does not beat hangs
+	job.heartbeat.Lock()
+	job.heartbeat.last = time.Now().Add(-time.Minute)
+	job.heartbeat.Unlock()
+	if stuck := queue.Stuck(time.Second); len(stuck) != 1 || stuck[0] != "A" {
+		t.Errorf("Hanging loop not found: %v", stuck)
+	}
+	job.heartbeat.Lock()
+	job.heartbeat.busy = true
+	job.heartbeat.Unlock()
+	if stuck := queue.Stuck(time.Second); len(stuck) != 0 {
+		t.Errorf("Busy loop taken as stuck: %v", stuck)
+	}
+
+	queue.locksMutex.Lock()
+	stuck := queue.Stuck(10 * time.Millisecond)
+	queue.locksMutex.Unlock()
+	if len(stuck) != 1 || stuck[0] != "queue" {
+		t.Errorf("Locked queue not found: %v", stuck)
+	}
+}
+
+//deadlockStore makes the loops of A and B wait for each other when they publish that they failed
+type deadlockStore struct {
+	inbox   map[string]chan Event
+	release chan struct{}
+}
+
+func (store *deadlockStore) FindJob(name string) (*Job, int) {
+	return nil, 0
+}
+
+func (store *deadlockStore) Publish(event Event) {
+	if event.Type != EventJobFailed {
+		return
+	}
+	other := map[string]string{"A": "B", "B": "A"}[event.Job]
+	select {
+	case store.inbox[other] <- event:
+	case <-store.release:
+	}
+}
+
+func TestStuckAfterRun(t *testing.T) {
+	store := &deadlockStore{inbox: map[string]chan Event{"A": make(chan Event), "B": make(chan Event)}, release: make(chan struct{})}
+	queue := &JobQueue{Wg: new(sync.WaitGroup), jobs: make([]*Job, 0)}
+	for _, name := range []string{"A", "B"} {
+		job := newJob()
+		job.JobName = name
+		job.ResticPath = "/bin/false"
+		job.start(store, func() {})
+		queue.jobs = append(queue.jobs, job)
+	}
+	defer func() {
+		close(store.release)
+		for _, job := range queue.jobs {
+			job.Stop()
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	for _, job := range queue.jobs {
+		job.SendTrigger(triggerExtern)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if stuck := queue.Stuck(100 * time.Millisecond); len(stuck) != 2 {
+		t.Errorf("Loops waiting for each other after their run not found: %v", stuck)
+	}
+}
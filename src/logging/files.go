@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rshmelev/lumberjack"
+)
+
+//JobFiles is a hook that writes the entries of every loaded job into a log file of its own as well,
+//Dir/jobs/<job>/<job>.log, rotated like the log of the daemon. The jobs have a directory of their own in Dir,
+//so a job name can not clash with the other files the daemon keeps there
+type JobFiles struct {
+	Dir string
+	//megabytes
+	MaxSize int
+	//days
+	MaxAge    int
+	formatter log.Formatter
+	mutex     sync.Mutex
+	files     map[string]*lumberjack.Logger
+}
+
+//jobsDir is the directory in Dir with the directories of the jobs
+const jobsDir = "jobs"
+
+//NewJobFiles returns the hook, the files are written in the format of the log of the daemon
+func NewJobFiles(dir, format string, maxSize, maxAge int) (*JobFiles, error) {
+	formatter, err := NewFormatter(format)
+	if err != nil {
+		return nil, err
+	}
+	return &JobFiles{Dir: dir, MaxSize: maxSize, MaxAge: maxAge, formatter: formatter, files: make(map[string]*lumberjack.Logger)}, nil
+}
+
+//Levels are all of them, the levels of the jobs are checked when an entry is fired
+func (hook *JobFiles) Levels() []log.Level {
+	return log.AllLevels
+}
+
+//Fire writes the entry into the file of its job, entries without a job are left out
+func (hook *JobFiles) Fire(entry *log.Entry) error {
+	job, ok := entry.Data["Job"].(string)
+	if !ok || !isJob(job) || strings.ContainsAny(job, `/\`) || strings.HasPrefix(job, ".") {
+		return nil
+	}
+	line, err := hook.formatter.Format(entry)
+	if err != nil || len(line) == 0 {
+		return err
+	}
+	hook.mutex.Lock()
+	defer hook.mutex.Unlock()
+	_, err = hook.file(job).Write(line)
+	return err
+}
+
+//file returns the log file of the job, opened on first use. The mutex must be held
+func (hook *JobFiles) file(job string) *lumberjack.Logger {
+	file, ok := hook.files[job]
+	if !ok {
+		dir := path.Join(hook.Dir, jobsDir, job)
+		os.MkdirAll(dir, 0700)
+		file = &lumberjack.Logger{
+			Filename: path.Join(dir, job+".log"),
+			MaxSize:  hook.MaxSize,
+			MaxAge:   hook.MaxAge,
+		}
+		hook.files[job] = file
+	}
+	return file
+}
+
+//Close closes the files of all jobs
+func (hook *JobFiles) Close() error {
+	hook.mutex.Lock()
+	defer hook.mutex.Unlock()
+	for job, file := range hook.files {
+		file.Close()
+		delete(hook.files, job)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+//Package logging sets up the log of the daemon: the format, the level of the daemon and of single jobs and the
+//log files of the jobs
+package logging
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//the formats of the log
+const (
+	//FormatText is for people reading the log: time, level, job and run, message and the other fields
+	FormatText = "text"
+	//FormatLogfmt is key=value pairs, the format the log always had
+	FormatLogfmt = "logfmt"
+	//FormatJSON is one object per line for log collectors
+	FormatJSON = "json"
+)
+
+//levels holds the global level, the levels of the jobs that log more or less than that and the jobs that are loaded
+var levels = struct {
+	sync.RWMutex
+	global log.Level
+	jobs   map[string]log.Level
+	known  map[string]bool
+}{global: log.InfoLevel, jobs: make(map[string]log.Level), known: make(map[string]bool)}
+
+//NewFormatter returns the formatter of the format, which leaves out the entries that are not enabled
+func NewFormatter(format string) (log.Formatter, error) {
+	var formatter log.Formatter
+	switch format {
+	case FormatText:
+		formatter = &textFormatter{}
+	case FormatLogfmt, "":
+		formatter = &log.TextFormatter{DisableColors: true, FullTimestamp: true, QuoteEmptyFields: true}
+	case FormatJSON:
+		formatter = &log.JSONFormatter{}
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use text, logfmt or json", format)
+	}
+	return &filteringFormatter{formatter}, nil
+}
+
+//SetLevel sets the level of the entries that do not belong to a job, and of the jobs without their own level
+func SetLevel(level log.Level) {
+	levels.Lock()
+	defer levels.Unlock()
+	levels.global = level
+	updateLevel()
+}
+
+//SetJobLevel sets the level of the entries of the job, overriding the global level in both directions
+func SetJobLevel(job string, level log.Level) {
+	levels.Lock()
+	defer levels.Unlock()
+	levels.jobs[job] = level
+	updateLevel()
+}
+
+//ResetJobLevel makes the job log with the global level again
+func ResetJobLevel(job string) {
+	levels.Lock()
+	defer levels.Unlock()
+	delete(levels.jobs, job)
+	updateLevel()
+}
+
+//AddJob registers a loaded job, only loaded jobs get a log file. Entries can name other jobs, e.g. a request for a
+//job that does not exist
+func AddJob(job string) {
+	levels.Lock()
+	defer levels.Unlock()
+	levels.known[job] = true
+}
+
+//RemoveJob forgets a removed job and its level
+func RemoveJob(job string) {
+	levels.Lock()
+	defer levels.Unlock()
+	delete(levels.known, job)
+	delete(levels.jobs, job)
+	updateLevel()
+}
+
+//isJob tells if the job is loaded
+func isJob(job string) bool {
+	levels.RLock()
+	defer levels.RUnlock()
+	return levels.known[job]
+}
+
+//updateLevel lets logrus through the most verbose level anyone wants, the rest is filtered by Enabled.
+//levels must be locked
+func updateLevel() {
+	level := levels.global
+	for _, jobLevel := range levels.jobs {
+		if jobLevel > level {
+			level = jobLevel
+		}
+	}
+	log.SetLevel(level)
+}
+
+//Enabled tells if the entry is logged, by the level of its job or the global one
+func Enabled(entry *log.Entry) bool {
+	levels.RLock()
+	defer levels.RUnlock()
+	level := levels.global
+	if job, ok := entry.Data["Job"].(string); ok {
+		if jobLevel, ok := levels.jobs[job]; ok {
+			level = jobLevel
+		}
+	}
+	return entry.Level <= level
+}
+
+//filteringFormatter drops the entries that are not enabled
+type filteringFormatter struct {
+	log.Formatter
+}
+
+func (f *filteringFormatter) Format(entry *log.Entry) ([]byte, error) {
+	if !Enabled(entry) {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
+
+//Filter fires the hook only for the entries that are enabled
+func Filter(hook log.Hook) log.Hook {
+	return &filteringHook{hook}
+}
+
+type filteringHook struct {
+	log.Hook
+}
+
+func (hook *filteringHook) Fire(entry *log.Entry) error {
+	if !Enabled(entry) {
+		return nil
+	}
+	return hook.Hook.Fire(entry)
+}
@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//testLogger logs into a buffer with the format and the global level
+func testLogger(t *testing.T, format string, level log.Level) (*log.Logger, *bytes.Buffer) {
+	formatter, err := NewFormatter(format)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+	logger.Formatter = formatter
+	logger.Level = log.DebugLevel
+	SetLevel(level)
+	return logger, &buf
+}
+
+func TestLevels(t *testing.T) {
+	defer SetLevel(log.InfoLevel)
+	defer ResetJobLevel("noisy")
+	defer ResetJobLevel("quiet")
+	logger, buf := testLogger(t, FormatLogfmt, log.InfoLevel)
+	SetJobLevel("noisy", log.DebugLevel)
+	SetJobLevel("quiet", log.WarnLevel)
+	if log.GetLevel() != log.DebugLevel {
+		t.Error("logrus filters the debug entries of noisy")
+	}
+
+	logger.Debug("daemon debug")
+	logger.Info("daemon info")
+	logger.WithField("Job", "noisy").Debug("noisy debug")
+	logger.WithField("Job", "quiet").Info("quiet info")
+	logger.WithField("Job", "quiet").Warning("quiet warning")
+	logger.WithField("Job", "other").Debug("other debug")
+
+	for _, msg := range []string{"daemon info", "noisy debug", "quiet warning"} {
+		if !strings.Contains(buf.String(), msg) {
+			t.Errorf("%s missing", msg)
+		}
+	}
+	for _, msg := range []string{"daemon debug", "quiet info", "other debug"} {
+		if strings.Contains(buf.String(), msg) {
+			t.Errorf("%s logged", msg)
+		}
+	}
+	RemoveJob("noisy")
+	if log.GetLevel() != log.InfoLevel {
+		t.Errorf("Level still %s", log.GetLevel())
+	}
+}
+
+func TestFormats(t *testing.T) {
+	defer SetLevel(log.InfoLevel)
+	at := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	logger, buf := testLogger(t, FormatText, log.InfoLevel)
+	logger.WithFields(log.Fields{"Job": "backup", "Run": 12, "Log": "/var/log/12.log", "Error": "exit status 1"}).WithTime(at).Warning("error")
+	expected := "2018-06-01 12:00:00 WARN  backup#12: error Error=\"exit status 1\" Log=/var/log/12.log\n"
+	if buf.String() != expected {
+		t.Errorf("Wrong text %q", buf.String())
+	}
+
+	logger, buf = testLogger(t, FormatJSON, log.InfoLevel)
+	logger.WithFields(log.Fields{"Job": "backup", "Run": 12}).Info("Run restic")
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err.Error())
+	}
+	if entry["Job"] != "backup" || entry["Run"] != 12.0 || entry["msg"] != "Run restic" {
+		t.Errorf("Wrong json %v", entry)
+	}
+
+	logger, buf = testLogger(t, FormatLogfmt, log.InfoLevel)
+	logger.WithFields(log.Fields{"Job": "backup", "Run": 12}).Info("Run restic")
+	if !strings.Contains(buf.String(), `msg="Run restic" Job=backup Run=12`) {
+		t.Errorf("Wrong logfmt %q", buf.String())
+	}
+
+	if _, err := NewFormatter("xml"); err == nil {
+		t.Error("Unknown format accepted")
+	}
+}
+
+func TestJobFiles(t *testing.T) {
+	defer SetLevel(log.InfoLevel)
+	dir, err := ioutil.TempDir("", "rc-logging")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	files, err := NewJobFiles(dir, FormatText, 1, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer files.Close()
+	logger, buf := testLogger(t, FormatText, log.InfoLevel)
+	logger.Hooks.Add(files)
+	AddJob("home")
+	AddJob("etc")
+	AddJob("../escape")
+	defer RemoveJob("home")
+	defer RemoveJob("etc")
+	defer RemoveJob("../escape")
+
+	logger.Info("Started")
+	logger.WithFields(log.Fields{"Job": "home", "Run": 3}).Info("Run restic")
+	logger.WithFields(log.Fields{"Job": "etc", "Run": 7}).Info("Run restic")
+	logger.WithFields(log.Fields{"Job": "home"}).Debug("Not logged")
+	logger.WithFields(log.Fields{"Job": "unknown"}).Info("Request for a job that does not exist")
+	logger.WithFields(log.Fields{"Job": "../escape"}).Info("Not in a file")
+
+	home, err := ioutil.ReadFile(path.Join(dir, "jobs", "home", "home.log"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if lines := strings.Split(strings.TrimSpace(string(home)), "\n"); len(lines) != 1 || !strings.HasSuffix(lines[0], "home#3: Run restic") {
+		t.Errorf("Wrong file of home %q", home)
+	}
+	if etc, _ := ioutil.ReadFile(path.Join(dir, "jobs", "etc", "etc.log")); !strings.Contains(string(etc), "etc#7") {
+		t.Errorf("Wrong file of etc %q", etc)
+	}
+	if entries, _ := ioutil.ReadDir(path.Join(dir, "jobs")); len(entries) != 2 {
+		t.Errorf("Files for jobs that are not loaded: %d directories", len(entries))
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Files outside of the directory of the jobs: %d entries", len(entries))
+	}
+	if strings.Count(buf.String(), "\n") != 5 {
+		t.Errorf("The main log misses entries %q", buf.String())
+	}
+}
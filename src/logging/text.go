@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//textFormatter writes lines like "2018-06-01 12:00:00 INFO  backup#12: Finished running restic Log=...", the job
+//and the run in front and the other fields sorted behind the message
+type textFormatter struct{}
+
+func (f *textFormatter) Format(entry *log.Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(entry.Time.Format("2006-01-02 15:04:05"))
+	level := strings.ToUpper(entry.Level.String())
+	if len(level) > 5 {
+		level = level[:4]
+	}
+	fmt.Fprintf(&buf, " %-5s ", level)
+	if job, ok := entry.Data["Job"]; ok {
+		fmt.Fprint(&buf, job)
+		if run, ok := entry.Data["Run"]; ok {
+			fmt.Fprintf(&buf, "#%v", run)
+		}
+		buf.WriteString(": ")
+	}
+	buf.WriteString(entry.Message)
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		if key != "Job" && key != "Run" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := fmt.Sprint(entry.Data[key])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		buf.WriteString(" " + key + "=" + value)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
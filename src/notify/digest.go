@@ -0,0 +1,359 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/robfig/cron"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//DigestConfig schedules a summary of all jobs, sent by an email target
+type DigestConfig struct {
+	//cron style like the timers of the jobs, e.g. "@weekly" or "0 0 8 * * *". Empty sends no digest
+	Schedule string
+	//templates of a Digest, HTMLBody has a default too
+	Subject  string
+	Body     string
+	HTMLBody string
+}
+
+//JobSummary is what a job did in the time of a digest
+type JobSummary struct {
+	Job        string
+	Repository string
+	Runs       int
+	Successes  int
+	Partial    int
+	Failures   int
+	Cancelled  int
+	//the times the job used up its retries
+	GaveUp int
+	//the data the runs added as restic reports it at the end of a backup. Forgets and prunes are not subtracted,
+	//see the Growth of the repository for that
+	BytesAdded uint64
+	//the result of the last run in the time of the digest, empty without runs
+	LastResult string
+	LastRun    time.Time
+	//the last success ever, zero if the job never succeeded
+	LastSuccess time.Time
+}
+
+//RepositorySummary is how much the jobs added to a repository and how much it grew in the time of a digest
+type RepositorySummary struct {
+	Repository string
+	Jobs       []string
+	Runs       int
+	BytesAdded uint64
+	//the data in the repository at the end of the digest as restic stats --mode raw-data counts it, zero if it could not be measured
+	Size uint64
+	//how much Size changed since the last digest, negative if more was pruned than added. Only set if GrowthKnown,
+	//which needs a Size from the last digest too
+	Growth      int64
+	GrowthKnown bool
+}
+
+//Digest is what the templates of digests get
+type Digest struct {
+	From         time.Time
+	To           time.Time
+	Jobs         []JobSummary
+	Repositories []RepositorySummary
+	Runs         int
+	Failures     int
+	BytesAdded   uint64
+}
+
+const defaultDigestSubject = `[restic-cronned] {{.Runs}} runs, {{.Failures}} failed since {{.From.Format "2006-01-02"}}`
+
+const defaultDigestBody = `restic-cronned summary from {{.From.Format "2006-01-02 15:04"}} to {{.To.Format "2006-01-02 15:04"}}
+
+{{.Runs}} runs, {{.Failures}} failed, {{bytes .BytesAdded}} added by the runs
+{{range .Jobs}}
+{{.Job}}{{if .Repository}} ({{.Repository}}){{end}}
+  runs: {{.Runs}}, succeeded: {{.Successes}}, partial: {{.Partial}}, failed: {{.Failures}}, cancelled: {{.Cancelled}}{{if .GaveUp}}, gave up: {{.GaveUp}}{{end}}
+  added by the runs: {{bytes .BytesAdded}}
+  last success: {{if .LastSuccess.IsZero}}never{{else}}{{.LastSuccess.Format "2006-01-02 15:04"}}{{end}}
+{{- end}}
+{{- if .Repositories}}
+
+Repositories
+{{- range .Repositories}}
+  {{.Repository}}: {{bytes .BytesAdded}} added by {{.Runs}} runs{{if .GrowthKnown}}, grew by {{growth .Growth}} to {{bytes .Size}}{{else if .Size}}, holds {{bytes .Size}}{{end}}
+{{- end}}
+{{- end}}
+`
+
+const defaultDigestHTML = `<html><body style="font-family: sans-serif">
+<h2>restic-cronned summary</h2>
+<p>{{.From.Format "2006-01-02 15:04"}} to {{.To.Format "2006-01-02 15:04"}}: {{.Runs}} runs, {{.Failures}} failed, {{bytes .BytesAdded}} added by the runs</p>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Job</th><th>Repository</th><th>Runs</th><th>Succeeded</th><th>Partial</th><th>Failed</th><th>Cancelled</th><th>Added by the runs</th><th>Last success</th></tr>
+{{- range .Jobs}}
+<tr{{if or .Failures .GaveUp}} style="background: #fdd"{{end}}><td>{{.Job}}</td><td>{{.Repository}}</td><td>{{.Runs}}</td><td>{{.Successes}}</td><td>{{.Partial}}</td><td>{{.Failures}}</td><td>{{.Cancelled}}</td><td>{{bytes .BytesAdded}}</td><td>{{if .LastSuccess.IsZero}}never{{else}}{{.LastSuccess.Format "2006-01-02 15:04"}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- if .Repositories}}
+<h3>Repositories</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Repository</th><th>Jobs</th><th>Runs</th><th>Added by the runs</th><th>Growth</th><th>Size</th></tr>
+{{- range .Repositories}}
+<tr><td>{{.Repository}}</td><td>{{range $idx, $job := .Jobs}}{{if $idx}}, {{end}}{{$job}}{{end}}</td><td>{{.Runs}}</td><td>{{bytes .BytesAdded}}</td><td>{{if .GrowthKnown}}{{growth .Growth}}{{end}}</td><td>{{if .Size}}{{bytes .Size}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body></html>
+`
+
+//formatBytes makes sizes readable, e.g. 1.5 GiB
+func formatBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+//formatGrowth makes a change of a size readable, e.g. +1.5 GiB or -200 B
+func formatGrowth(change int64) string {
+	if change < 0 {
+		return "-" + formatBytes(uint64(-change))
+	}
+	return "+" + formatBytes(uint64(change))
+}
+
+//digest collects the runs for one email target until its schedule sends them
+type digest struct {
+	mail     *email
+	schedule cron.Schedule
+	from     time.Time
+	jobs     map[string]*JobSummary
+	//the sizes of the repositories at the last digest, keyed by the label of the repository
+	sizes map[string]uint64
+	//where the collected runs are persisted, so a restart does not lose them. Empty persists nothing
+	file string
+}
+
+//digestState is the part of a digest that must survive restarts
+type digestState struct {
+	From  time.Time              `json:"From"`
+	Jobs  map[string]*JobSummary `json:"Jobs"`
+	Sizes map[string]uint64      `json:"Sizes"`
+}
+
+func newDigest(mail *email, now time.Time) (*digest, error) {
+	schedule, err := cron.Parse(mail.config.Digest.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("Digest Schedule: %s", err.Error())
+	}
+	d := &digest{mail: mail, schedule: schedule, from: now, jobs: make(map[string]*JobSummary), sizes: make(map[string]uint64)}
+	if jobs.StateDir != "" {
+		//can not clash with the state of a job, these all end in .state.json
+		d.file = path.Join(jobs.StateDir, "digest-"+strings.Replace(mail.name(), "/", "_", -1)+".json")
+		d.loadState()
+	}
+	return d, nil
+}
+
+//loadState continues the digest from before the restart, if there is one
+func (d *digest) loadState() {
+	data, err := ioutil.ReadFile(d.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"Target": d.mail.name(), "Error": err.Error()}).Warning("Could not read digest state")
+		}
+		return
+	}
+	var state digestState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		log.WithFields(log.Fields{"Target": d.mail.name(), "Error": err.Error()}).Warning("Could not decode digest state")
+		return
+	}
+	if !state.From.IsZero() {
+		d.from = state.From
+	}
+	if state.Jobs != nil {
+		d.jobs = state.Jobs
+	}
+	if state.Sizes != nil {
+		d.sizes = state.Sizes
+	}
+}
+
+//saveState persists the collected runs. The notifier holds its mutex
+func (d *digest) saveState() {
+	if d.file == "" {
+		return
+	}
+	data, err := json.MarshalIndent(digestState{From: d.from, Jobs: d.jobs, Sizes: d.sizes}, "", "    ")
+	if err == nil {
+		err = os.MkdirAll(path.Dir(d.file), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(d.file+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(d.file+".tmp", d.file)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"Target": d.mail.name(), "Error": err.Error()}).Warning("Could not write digest state")
+	}
+}
+
+func (d *digest) summary(job string) *JobSummary {
+	summary, ok := d.jobs[job]
+	if !ok {
+		summary = &JobSummary{Job: job}
+		d.jobs[job] = summary
+	}
+	return summary
+}
+
+//record counts the finished runs and the failed jobs. The notifier holds its mutex
+func (d *digest) record(event jobs.Event) {
+	//ad hoc runs like a manual check are no runs of the job, like for the notifications
+	if event.Run != nil && event.Run.AdHoc {
+		return
+	}
+	switch event.Type {
+	case jobs.EventRunFinished:
+		summary := d.summary(event.Job)
+		summary.Runs++
+		switch event.Run.Result {
+		case jobs.ResultSuccess:
+			summary.Successes++
+		case jobs.ResultPartial:
+			summary.Partial++
+		case jobs.ResultCancelled:
+			summary.Cancelled++
+		default:
+			summary.Failures++
+		}
+		summary.BytesAdded += event.Run.BytesAdded
+		summary.LastResult = event.Run.Result
+		summary.LastRun = event.Run.Finished
+	case jobs.EventJobFailed:
+		d.summary(event.Job).GaveUp++
+	default:
+		return
+	}
+	d.saveState()
+}
+
+//take builds the digest of the collected runs, the current jobs and the sizes of their repositories and starts collecting anew
+func (d *digest) take(list []*jobs.Job, now time.Time, sizes map[string]uint64) Digest {
+	result := Digest{From: d.from, To: now}
+	collected := d.jobs
+	previousSizes := d.sizes
+	d.jobs = make(map[string]*JobSummary)
+	d.from = now
+	d.sizes = sizes
+	d.saveState()
+
+	repositories := make(map[string]*RepositorySummary)
+	add := func(summary JobSummary) {
+		result.Jobs = append(result.Jobs, summary)
+		result.Runs += summary.Runs
+		result.Failures += summary.Failures
+		result.BytesAdded += summary.BytesAdded
+		if summary.Repository == "" {
+			return
+		}
+		repo, ok := repositories[summary.Repository]
+		if !ok {
+			repo = &RepositorySummary{Repository: summary.Repository}
+			repositories[summary.Repository] = repo
+		}
+		repo.Jobs = append(repo.Jobs, summary.Job)
+		repo.Runs += summary.Runs
+		repo.BytesAdded += summary.BytesAdded
+	}
+	for _, job := range list {
+		if job.Template {
+			continue
+		}
+		summary := JobSummary{Job: job.JobName}
+		if collectedSummary, ok := collected[job.JobName]; ok {
+			summary = *collectedSummary
+			delete(collected, job.JobName)
+		}
+		summary.Repository = job.RepositoryLabel()
+		summary.LastSuccess = job.Metrics().LastSuccess
+		add(summary)
+	}
+	//jobs that were removed in the meantime
+	for _, summary := range collected {
+		add(*summary)
+	}
+	sort.Slice(result.Jobs, func(i, j int) bool { return result.Jobs[i].Job < result.Jobs[j].Job })
+	for _, repo := range repositories {
+		sort.Strings(repo.Jobs)
+		if size, ok := sizes[repo.Repository]; ok {
+			repo.Size = size
+			if previous, ok := previousSizes[repo.Repository]; ok {
+				repo.Growth = int64(size) - int64(previous)
+				repo.GrowthKnown = true
+			}
+		}
+		result.Repositories = append(result.Repositories, *repo)
+	}
+	sort.Slice(result.Repositories, func(i, j int) bool { return result.Repositories[i].Repository < result.Repositories[j].Repository })
+	return result
+}
+
+//runDigest sends the digest of the target whenever its schedule says so. It does not return
+func (notifier *Notifier) runDigest(d *digest, list func() []*jobs.Job) {
+	for {
+		now := notifier.now()
+		time.Sleep(d.schedule.Next(now).Sub(now))
+		notifier.wg.Add(1)
+		notifier.sendDigest(d, list())
+	}
+}
+
+//sendDigest sends what the digest collected up to now, trying again like the notifications
+func (notifier *Notifier) sendDigest(d *digest, list []*jobs.Job) {
+	defer notifier.wg.Done()
+	//restic runs before the lock, the events must not wait for it
+	sizes := notifier.repositorySizes(list)
+	notifier.mutex.Lock()
+	summary := d.take(list, notifier.now(), sizes)
+	notifier.mutex.Unlock()
+	fields := log.Fields{"Target": d.mail.name(), "Kind": "digest"}
+	notifier.retry(fields, d.mail.filter().retries, func() error { return d.mail.sendDigest(summary) })
+}
+
+//repositorySizes measures the repositories of the jobs, each with the first job on it. The repositories that could not be
+//measured are left out, their growth is unknown until the next digest after the one that measured them again
+func (notifier *Notifier) repositorySizes(list []*jobs.Job) map[string]uint64 {
+	sizes := make(map[string]uint64)
+	measured := make(map[string]bool)
+	for _, job := range list {
+		label := job.RepositoryLabel()
+		if job.Template || label == "" || measured[label] {
+			continue
+		}
+		measured[label] = true
+		size, err := notifier.repositorySize(job)
+		if err != nil {
+			log.WithFields(log.Fields{"Job": job.JobName, "Repository": label, "Error": err.Error()}).Warning("Repository could not be measured for the digest")
+			continue
+		}
+		sizes[label] = size
+	}
+	return sizes
+}
@@ -0,0 +1,341 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/zalando/go-keyring"
+)
+
+//EmailConfig is a mail server the notifications and digests are sent over. The password of Username is taken
+//from the keyring under Service, like the passwords of the repositories
+type EmailConfig struct {
+	Name string
+	Host string
+	//default 587 for starttls, 465 for tls and 25 for none
+	Port int
+	//starttls (default), tls for implicit tls or none
+	Security string
+	Username string
+	Service  string
+	From     string
+	To       []string
+	//templates of a Notification. HTMLBody is optional, the mail is plain text without it
+	Subject  string
+	Body     string
+	HTMLBody string
+	//send only the digest, no notifications
+	DigestOnly bool
+	Digest     DigestConfig
+	Filter     `mapstructure:",squash"`
+}
+
+//security modes of the connection to the mail server
+const (
+	securityStartTLS = "starttls"
+	securityTLS      = "tls"
+	securityNone     = "none"
+)
+
+//smtpTimeout limits one attempt to deliver a mail
+const smtpTimeout = time.Minute
+
+const defaultEmailSubject = `[restic-cronned] {{.Job}}: {{.Kind}}`
+
+const defaultEmailBody = `{{.Message}}
+
+Job:  {{.Job}}
+Time: {{.Time.Format "2006-01-02 15:04:05 MST"}}
+{{- if .Run}}
+Run:  {{.Run.ID}}, {{.Run.Result}}, exit code {{.Run.ExitCode}}
+{{- if .Run.SnapshotID}}
+Snapshot: {{.Run.SnapshotID}}
+{{- end}}
+{{- end}}
+{{- if eq .Kind "failure"}}
+Retries: {{.Retries}}
+{{- end}}
+`
+
+//mailTemplates render the subject and the bodies of one kind of mail
+type mailTemplates struct {
+	subject *template.Template
+	body    *template.Template
+	html    *htmltemplate.Template
+}
+
+func newMailTemplates(subject, body, html, defaultSubject, defaultBody, defaultHTML string) (*mailTemplates, error) {
+	if subject == "" {
+		subject = defaultSubject
+	}
+	if body == "" {
+		body = defaultBody
+	}
+	if html == "" {
+		html = defaultHTML
+	}
+	tmpls := &mailTemplates{}
+	var err error
+	if tmpls.subject, err = template.New("subject").Funcs(templateFuncs).Parse(subject); err != nil {
+		return nil, err
+	}
+	if tmpls.body, err = template.New("body").Funcs(templateFuncs).Parse(body); err != nil {
+		return nil, err
+	}
+	if html != "" {
+		if tmpls.html, err = htmltemplate.New("html").Funcs(htmltemplate.FuncMap(templateFuncs)).Parse(html); err != nil {
+			return nil, err
+		}
+	}
+	return tmpls, nil
+}
+
+//render executes the templates with data. html is empty without a html template
+func (tmpls *mailTemplates) render(data interface{}) (subject, text, html string, err error) {
+	var buf bytes.Buffer
+	if err = tmpls.subject.Execute(&buf, data); err != nil {
+		return
+	}
+	//a line break would end the header
+	subject = strings.Join(strings.Fields(buf.String()), " ")
+	buf.Reset()
+	if err = tmpls.body.Execute(&buf, data); err != nil {
+		return
+	}
+	text = buf.String()
+	if tmpls.html != nil {
+		buf.Reset()
+		if err = tmpls.html.Execute(&buf, data); err != nil {
+			return
+		}
+		html = buf.String()
+	}
+	return
+}
+
+type email struct {
+	config        EmailConfig
+	f             *filter
+	notifications *mailTemplates
+	digest        *mailTemplates
+	//nil uses the roots of the system, the tests trust their own certificate
+	tlsConfig *tls.Config
+}
+
+func newEmail(config EmailConfig) (*email, error) {
+	if config.Host == "" {
+		return nil, errors.New("Host is missing")
+	}
+	if config.From == "" || len(config.To) == 0 {
+		return nil, errors.New("From and To are needed")
+	}
+	if config.Username != "" && config.Service == "" {
+		return nil, errors.New("Service is needed to get the password of Username from the keyring")
+	}
+	if config.Security == "" {
+		config.Security = securityStartTLS
+	}
+	if config.Port == 0 {
+		switch config.Security {
+		case securityTLS:
+			config.Port = 465
+		case securityNone:
+			config.Port = 25
+		default:
+			config.Port = 587
+		}
+	}
+	switch config.Security {
+	case securityStartTLS, securityTLS, securityNone:
+	default:
+		return nil, fmt.Errorf("unknown Security %q, use starttls, tls or none", config.Security)
+	}
+	//mails are for the things that need attention, not for every partial run
+	if len(config.Events) == 0 {
+		config.Events = []string{KindFailure, KindRecovery}
+	}
+	f, err := newFilter(config.Filter)
+	if err != nil {
+		return nil, err
+	}
+	mail := &email{config: config, f: f}
+	if mail.notifications, err = newMailTemplates(config.Subject, config.Body, config.HTMLBody, defaultEmailSubject, defaultEmailBody, ""); err != nil {
+		return nil, err
+	}
+	if config.Digest.Schedule != "" {
+		if mail.digest, err = newMailTemplates(config.Digest.Subject, config.Digest.Body, config.Digest.HTMLBody, defaultDigestSubject, defaultDigestBody, defaultDigestHTML); err != nil {
+			return nil, fmt.Errorf("Digest: %s", err.Error())
+		}
+	}
+	return mail, nil
+}
+
+func (mail *email) name() string {
+	if mail.config.Name != "" {
+		return mail.config.Name
+	}
+	return mail.config.Host
+}
+
+func (mail *email) filter() *filter {
+	return mail.f
+}
+
+func (mail *email) send(n Notification) error {
+	return mail.sendTemplates(mail.notifications, n)
+}
+
+func (mail *email) sendDigest(d Digest) error {
+	return mail.sendTemplates(mail.digest, d)
+}
+
+func (mail *email) sendTemplates(tmpls *mailTemplates, data interface{}) error {
+	subject, text, html, err := tmpls.render(data)
+	if err != nil {
+		return permanentError{err}
+	}
+	return mail.deliver(buildMessage(mail.config.From, mail.config.To, subject, text, html, time.Now()))
+}
+
+//dial connects to the mail server and secures the connection as configured
+func (mail *email) dial() (*smtp.Client, error) {
+	address := net.JoinHostPort(mail.config.Host, strconv.Itoa(mail.config.Port))
+	tlsConfig := &tls.Config{ServerName: mail.config.Host}
+	if mail.tlsConfig != nil {
+		tlsConfig = mail.tlsConfig.Clone()
+	}
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	if mail.config.Security == securityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, mail.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if mail.config.Security == securityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, permanentError{fmt.Errorf("%s does not support STARTTLS", mail.config.Host)}
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+//deliver sends the message to all recipients in one session
+func (mail *email) deliver(msg []byte) error {
+	client, err := mail.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if mail.config.Username != "" {
+		password, err := keyring.Get(mail.config.Service, mail.config.Username)
+		if err != nil {
+			return fmt.Errorf("password of %s: %s", mail.config.Username, err.Error())
+		}
+		if err = client.Auth(smtp.PlainAuth("", mail.config.Username, password, mail.config.Host)); err != nil {
+			return smtpError(err)
+		}
+	}
+	if err = client.Mail(mail.config.From); err != nil {
+		return smtpError(err)
+	}
+	for _, to := range mail.config.To {
+		if err = client.Rcpt(to); err != nil {
+			return smtpError(err)
+		}
+	}
+	wr, err := client.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err = wr.Write(msg); err != nil {
+		return err
+	}
+	if err = wr.Close(); err != nil {
+		return smtpError(err)
+	}
+	return client.Quit()
+}
+
+//smtpError marks rejections (5xx) as permanent, trying again would only be rejected again
+func smtpError(err error) error {
+	if protoErr, ok := err.(*textproto.Error); ok && protoErr.Code >= 500 {
+		return permanentError{err}
+	}
+	return err
+}
+
+//buildMessage assembles the mail, with html as multipart/alternative
+func buildMessage(from string, to []string, subject, text, html string, now time.Time) []byte {
+	var msg bytes.Buffer
+	header := func(key, value string) {
+		msg.WriteString(key + ": " + value + "\r\n")
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "localhost"
+	}
+	header("From", from)
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%d.restic-cronned@%s>", now.UnixNano(), host))
+	header("MIME-Version", "1.0")
+	if html == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		msg.WriteString("\r\n")
+		writeQuotedPrintable(&msg, text)
+		return msg.Bytes()
+	}
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{{"text/plain", text}, {"text/html", html}} {
+		wr, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		writeQuotedPrintable(wr, part.content)
+	}
+	parts.Close()
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes()
+}
+
+func writeQuotedPrintable(wr io.Writer, content string) {
+	qp := quotedprintable.NewWriter(wr)
+	content = strings.Replace(content, "\r\n", "\n", -1)
+	qp.Write([]byte(strings.Replace(content, "\n", "\r\n", -1)))
+	qp.Close()
+}
@@ -0,0 +1,415 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zalando/go-keyring"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//smtpServer is a minimal mail server that keeps what it got
+type smtpServer struct {
+	sync.Mutex
+	listener  net.Listener
+	tlsConfig *tls.Config
+	auths     []string
+	rcpts     []string
+	mails     []string
+}
+
+//newSMTPServer listens on localhost. With a tlsConfig it offers STARTTLS
+func newSMTPServer(t *testing.T, tlsConfig *tls.Config) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server := &smtpServer{listener: listener, tlsConfig: tlsConfig}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *smtpServer) port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (server *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO":
+			if server.tlsConfig != nil {
+				if _, secure := conn.(*tls.Conn); !secure {
+					reply("250-localhost")
+					reply("250 STARTTLS")
+					continue
+				}
+			}
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 go ahead")
+			conn = tls.Server(conn, server.tlsConfig)
+			reader = bufio.NewReader(conn)
+		case "AUTH":
+			server.Lock()
+			server.auths = append(server.auths, strings.TrimPrefix(line, "AUTH PLAIN "))
+			server.Unlock()
+			reply("235 ok")
+		case "MAIL":
+			reply("250 ok")
+		case "RCPT":
+			server.Lock()
+			server.rcpts = append(server.rcpts, line)
+			server.Unlock()
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			server.Lock()
+			server.mails = append(server.mails, data.String())
+			server.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+//testTLS returns a certificate for the server and a client config that trusts it
+func testTLS() (*tls.Config, *tls.Config) {
+	httpServer := httptest.NewTLSServer(nil)
+	httpServer.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(httpServer.Certificate())
+	return httpServer.TLS, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+}
+
+func TestEmail(t *testing.T) {
+	serverTLS, clientTLS := testTLS()
+	server := newSMTPServer(t, serverTLS)
+	defer server.listener.Close()
+	keyring.MockInit()
+	keyring.Set("smtp", "backup@example.com", "secret")
+
+	notifier, err := New(Config{Emails: []EmailConfig{{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "backup@example.com",
+		Service:  "smtp",
+		From:     "backup@example.com",
+		To:       []string{"admin@example.com", "team@example.com"},
+	}}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	notifier.targets[0].(*email).tlsConfig = clientTLS
+
+	notifier.handle(jobs.Event{Type: jobs.EventJobFailed, Job: "backup", Time: time.Now(), Retry: 2, Run: run(jobs.ResultFailure, false)})
+	notifier.Wait()
+	//partial is not one of the default events of mails
+	notifier.handle(jobs.Event{Type: jobs.EventRunFinished, Job: "backup", Time: time.Now(), Run: run(jobs.ResultPartial, false)})
+	notifier.Wait()
+
+	if len(server.mails) != 2 {
+		t.Fatalf("Got %d mails, expected the failure and the recovery", len(server.mails))
+	}
+	if len(server.rcpts) != 4 {
+		t.Errorf("Wrong recipients: %v", server.rcpts)
+	}
+	auth, _ := base64.StdEncoding.DecodeString(server.auths[0])
+	if string(auth) != "\x00backup@example.com\x00secret" {
+		t.Errorf("Wrong credentials %q", auth)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(server.mails[0]))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if subject := msg.Header.Get("Subject"); subject != "[restic-cronned] backup: failure" {
+		t.Errorf("Wrong subject %q", subject)
+	}
+	body, _ := ioutil.ReadAll(msg.Body)
+	if !strings.Contains(string(body), "backup failed after 2 retries, exit code 1") {
+		t.Errorf("Wrong body %s", body)
+	}
+	if !strings.Contains(server.mails[1], "backup succeeded again") {
+		t.Errorf("Recovery missing: %s", server.mails[1])
+	}
+}
+
+func TestEmailRejected(t *testing.T) {
+	notifier, err := New(Config{Emails: []EmailConfig{{Host: "127.0.0.1", Port: 1, Security: "none", From: "a@example.com", To: []string{"b@example.com"}}}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	//no STARTTLS offered
+	server := newSMTPServer(t, nil)
+	defer server.listener.Close()
+	mail := notifier.targets[0].(*email)
+	mail.config.Security = securityStartTLS
+	mail.config.Port = server.port()
+	err = mail.send(Notification{Kind: KindFailure, Job: "backup"})
+	if _, permanent := err.(permanentError); !permanent {
+		t.Errorf("Sent without STARTTLS: %v", err)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	server := newSMTPServer(t, nil)
+	defer server.listener.Close()
+	notifier, err := New(Config{Emails: []EmailConfig{{
+		Host:       "127.0.0.1",
+		Port:       server.port(),
+		Security:   "none",
+		From:       "backup@example.com",
+		To:         []string{"admin@example.com"},
+		DigestOnly: true,
+		Digest:     DigestConfig{Schedule: "@weekly"},
+	}}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(notifier.targets) != 0 || len(notifier.digests) != 1 {
+		t.Fatal("Digest only target gets notifications")
+	}
+	finished := func(job string, result string, added uint64) {
+		notifier.handle(jobs.Event{Type: jobs.EventRunFinished, Job: job, Run: &jobs.RunInfo{Job: job, Result: result, BytesAdded: added}})
+	}
+	finished("home", jobs.ResultSuccess, 1024)
+	finished("home", jobs.ResultFailure, 0)
+	finished("home", jobs.ResultSuccess, 2048)
+	finished("etc", jobs.ResultPartial, 10)
+	finished("<removed>", jobs.ResultSuccess, 1)
+	//a failed manual check is no failed backup
+	notifier.handle(jobs.Event{Type: jobs.EventRunFinished, Job: "home", Run: &jobs.RunInfo{Job: "home", Result: jobs.ResultFailure, AdHoc: true}})
+	notifier.handle(jobs.Event{Type: jobs.EventJobFailed, Job: "prune"})
+	notifier.Wait()
+
+	dir, err := ioutil.TempDir("", "rc-digest")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	for name, args := range map[string]string{"home": `"-r", "/srv/nas", "backup"`, "etc": `"-r", "/srv/nas", "backup"`, "prune": `"-r", "/srv/restic", "forget"`, "idle": `"backup"`} {
+		job := `{"JobName": "` + name + `", "ResticPath": "/bin/false", "regularTimer": "@every 1000h", "ResticArguments": [` + args + `]}`
+		ioutil.WriteFile(path.Join(dir, name+".json"), []byte(job), 0600)
+	}
+	queue, err := jobs.NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.StartQueue()
+	defer queue.StopAllJobs()
+	list := queue.List()
+	d := notifier.digests[0]
+	summary := d.take(list, time.Now(), map[string]uint64{"/srv/nas": 1024, "/srv/restic": 2048})
+	if summary.Runs != 5 || summary.Failures != 1 || summary.BytesAdded != 3083 || len(summary.Jobs) != 5 {
+		t.Errorf("Wrong totals %+v", summary)
+	}
+	if len(summary.Repositories) != 2 || summary.Repositories[0].Repository != "/srv/nas" || summary.Repositories[0].BytesAdded != 3082 {
+		t.Errorf("Wrong repositories %+v", summary.Repositories)
+	}
+	//the first digest has nothing to compare the sizes with
+	if repo := summary.Repositories[0]; repo.Size != 1024 || repo.GrowthKnown {
+		t.Errorf("Wrong size %+v", repo)
+	}
+	if len(d.jobs) != 0 {
+		t.Error("Digest not reset")
+	}
+
+	finished("home", jobs.ResultSuccess, 4096)
+	//the repository grew less than the run added, a prune removed data
+	notifier.repositorySize = func(job *jobs.Job) (uint64, error) {
+		if job.RepositoryLabel() == "/srv/nas" {
+			return 4096, nil
+		}
+		return 0, errors.New("locked")
+	}
+	notifier.wg.Add(1)
+	notifier.sendDigest(d, list)
+	if len(server.mails) != 1 {
+		t.Fatal("Digest not sent")
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(server.mails[0]))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(part)
+		bodies = append(bodies, string(body))
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("Expected text and html, got %d parts", len(bodies))
+	}
+	if !strings.Contains(bodies[0], "/srv/nas: 4.0 KiB added by 1 runs, grew by +3.0 KiB to 4.0 KiB\r") ||
+		!strings.Contains(bodies[0], "/srv/restic: 0 B added by 0 runs") {
+		t.Errorf("Wrong text %s", bodies[0])
+	}
+	if !strings.Contains(bodies[1], "<td>home</td><td>/srv/nas</td><td>1</td>") || !strings.Contains(bodies[1], "3.0 KiB</td><td>4.0 KiB</td>") {
+		t.Errorf("Wrong html %s", bodies[1])
+	}
+}
+
+//the runs collected for a digest survive a restart
+func TestDigestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-digest")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	jobs.StateDir = dir
+	defer func() { jobs.StateDir = "" }()
+	config := Config{Emails: []EmailConfig{{Name: "admin", Host: "127.0.0.1", Security: "none", From: "backup@example.com", To: []string{"admin@example.com"}, DigestOnly: true, Digest: DigestConfig{Schedule: "@weekly"}}}}
+	notifier, err := New(config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	from := notifier.digests[0].from
+	notifier.handle(jobs.Event{Type: jobs.EventRunFinished, Job: "home", Run: &jobs.RunInfo{Job: "home", Result: jobs.ResultSuccess, BytesAdded: 1024}})
+	notifier.Wait()
+
+	restarted, err := New(config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	d := restarted.digests[0]
+	if !d.from.Equal(from) {
+		t.Errorf("Digest started anew at %v instead of %v", d.from, from)
+	}
+	if summary, ok := d.jobs["home"]; !ok || summary.Runs != 1 || summary.BytesAdded != 1024 {
+		t.Fatalf("Runs lost in the restart %+v", d.jobs)
+	}
+	d.take(nil, time.Now(), map[string]uint64{"/srv/nas": 4096})
+
+	restarted, err = New(config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(restarted.digests[0].jobs) != 0 {
+		t.Errorf("Sent runs are collected again %+v", restarted.digests[0].jobs)
+	}
+	if restarted.digests[0].sizes["/srv/nas"] != 4096 {
+		t.Errorf("Sizes of the repositories not persisted %v", restarted.digests[0].sizes)
+	}
+}
+
+//the digest is taken while the jobs run and are replaced, go test -race finds reads without the locks of the jobs
+func TestDigestWhileJobsRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-digest")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(path.Join(dir, "home.json"), []byte(`{"JobName": "home", "ResticPath": "/bin/true", "regularTimer": "@every 1000h"}`), 0600)
+	queue, err := jobs.NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.StartQueue()
+	defer queue.StopAllJobs()
+
+	d := &digest{jobs: make(map[string]*JobSummary)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			queue.TriggerJob("home")
+			if i%5 == 0 {
+				queue.ReloadJob("home")
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		if summary := d.take(queue.List(), time.Now(), nil); len(summary.Jobs) != 1 {
+			t.Fatalf("Job missing in the digest %+v", summary)
+		}
+	}
+}
+
+func TestEmailConfig(t *testing.T) {
+	valid := EmailConfig{Host: "localhost", From: "a@example.com", To: []string{"b@example.com"}}
+	invalid := []func(config *EmailConfig){
+		func(config *EmailConfig) { config.Host = "" },
+		func(config *EmailConfig) { config.To = nil },
+		func(config *EmailConfig) { config.Security = "ssl" },
+		func(config *EmailConfig) { config.Username = "a@example.com" },
+		func(config *EmailConfig) { config.Digest.Schedule = "every monday" },
+		func(config *EmailConfig) { config.Digest = DigestConfig{Schedule: "@daily", HTMLBody: "{{.Nope"} },
+	}
+	if _, err := New(Config{Emails: []EmailConfig{valid}}); err != nil {
+		t.Fatal(err.Error())
+	}
+	for idx, change := range invalid {
+		config := valid
+		change(&config)
+		if _, err := New(Config{Emails: []EmailConfig{config}}); err == nil {
+			t.Errorf("Invalid config %d accepted", idx)
+		}
+	}
+	if formatBytes(1536) != "1.5 KiB" || formatBytes(100) != "100 B" || formatGrowth(-1536) != "-1.5 KiB" || formatGrowth(0) != "+0 B" {
+		t.Error("Wrong sizes")
+	}
+}
@@ -0,0 +1,376 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//kinds of notifications, used in the Events of the filters
+const (
+	KindFailure       = "failure"
+	KindRecovery      = "recovery"
+	KindPartial       = "partial"
+	KindPreconditions = "preconditions"
+	KindStale         = "stale"
+)
+
+//defaults of the filters
+const (
+	defaultDedup   = 6 * time.Hour
+	defaultRetries = 3
+	//the first retry of a notification waits this long, every further one twice as long up to maxBackoff
+	defaultBackoff = 10 * time.Second
+	maxBackoff     = 10 * time.Minute
+)
+
+//Notification is what the templates of the targets get
+type Notification struct {
+	Kind    string
+	Job     string
+	Time    time.Time
+	Message string
+	//the run that failed, recovered or was partial. nil for preconditions
+	Run *jobs.RunInfo
+	//failure: the retries that failed before the job gave up
+	Retries int
+	//preconditions: since when they fail. stale: the time of the newest backup, zero if there is none
+	Since time.Time
+}
+
+//Config configures all notification targets
+type Config struct {
+	Webhooks []WebhookConfig
+	Emails   []EmailConfig
+}
+
+//Filter selects the notifications a target gets and how often
+type Filter struct {
+	//the kinds of notifications: failure, recovery, partial, preconditions, stale. Default is all
+	Events []string
+	//only these jobs, default is all
+	Jobs []string
+	//preconditions notifications are sent once the preconditions failed for this long, e.g. "6h". Default is at once
+	PreconditionsFailingFor string
+	//the same notification of a job is sent at most once in this time, e.g. "12h". Default is 6h
+	Dedup string
+	//how often a notification that could not be delivered is tried again. Default is 3
+	Retries *int
+}
+
+//filter is a Filter with its values parsed
+type filter struct {
+	kinds            map[string]bool
+	jobs             map[string]bool
+	preconditionsFor time.Duration
+	dedup            time.Duration
+	retries          int
+}
+
+func newFilter(config Filter) (*filter, error) {
+	f := &filter{kinds: make(map[string]bool), jobs: make(map[string]bool), dedup: defaultDedup, retries: defaultRetries}
+	for _, kind := range config.Events {
+		switch kind {
+		case KindFailure, KindRecovery, KindPartial, KindPreconditions, KindStale:
+			f.kinds[kind] = true
+		default:
+			return nil, fmt.Errorf("unknown event %q", kind)
+		}
+	}
+	for _, job := range config.Jobs {
+		f.jobs[job] = true
+	}
+	var err error
+	if config.PreconditionsFailingFor != "" {
+		if f.preconditionsFor, err = jobs.ParseDuration(config.PreconditionsFailingFor); err != nil {
+			return nil, fmt.Errorf("PreconditionsFailingFor: %s", err.Error())
+		}
+	}
+	if config.Dedup != "" {
+		if f.dedup, err = jobs.ParseDuration(config.Dedup); err != nil {
+			return nil, fmt.Errorf("Dedup: %s", err.Error())
+		}
+	}
+	if config.Retries != nil {
+		if *config.Retries < 0 {
+			return nil, errors.New("Retries can not be negative")
+		}
+		f.retries = *config.Retries
+	}
+	return f, nil
+}
+
+func (f *filter) accepts(n Notification, now time.Time) bool {
+	if len(f.kinds) > 0 && !f.kinds[n.Kind] {
+		return false
+	}
+	if len(f.jobs) > 0 && !f.jobs[n.Job] {
+		return false
+	}
+	return n.Kind != KindPreconditions || now.Sub(n.Since) >= f.preconditionsFor
+}
+
+//target delivers notifications somewhere
+type target interface {
+	name() string
+	filter() *filter
+	//send tries to deliver once. Errors that will not go away by trying again are permanentErrors
+	send(n Notification) error
+}
+
+//permanentError stops the retries of a notification
+type permanentError struct {
+	err error
+}
+
+func (pe permanentError) Error() string {
+	return pe.err.Error()
+}
+
+//sent is the last notification sent to a target about a job
+type sent struct {
+	kind string
+	at   time.Time
+}
+
+//Notifier turns the events of the jobs into notifications and sends them to its targets
+type Notifier struct {
+	targets []target
+	digests []*digest
+
+	mutex sync.Mutex
+	//jobs that failed and did not succeed since
+	failing map[string]bool
+	//since when the preconditions of the jobs fail
+	precondsSince map[string]time.Time
+	//jobs whose newest backup is older than their MaxAge
+	stale map[string]bool
+	//keyed by target and job
+	lastSent map[string]sent
+
+	now     func() time.Time
+	backoff time.Duration
+	//measures the repositories for the digests
+	repositorySize func(job *jobs.Job) (uint64, error)
+	//the deliveries in progress
+	wg sync.WaitGroup
+}
+
+//New sets up the targets of the config
+func New(config Config) (*Notifier, error) {
+	notifier := &Notifier{
+		failing:        make(map[string]bool),
+		precondsSince:  make(map[string]time.Time),
+		stale:          make(map[string]bool),
+		lastSent:       make(map[string]sent),
+		now:            time.Now,
+		backoff:        defaultBackoff,
+		repositorySize: (*jobs.Job).RepositorySize,
+	}
+	for idx, webhookConfig := range config.Webhooks {
+		webhook, err := newWebhook(webhookConfig)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %s", targetName(webhookConfig.Name, "webhook", idx), err.Error())
+		}
+		notifier.targets = append(notifier.targets, webhook)
+	}
+	for idx, emailConfig := range config.Emails {
+		mail, err := newEmail(emailConfig)
+		if err == nil && mail.digest != nil {
+			var d *digest
+			if d, err = newDigest(mail, notifier.now()); err == nil {
+				notifier.digests = append(notifier.digests, d)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("email %s: %s", targetName(emailConfig.Name, "email", idx), err.Error())
+		}
+		if !emailConfig.DigestOnly {
+			notifier.targets = append(notifier.targets, mail)
+		}
+	}
+	return notifier, nil
+}
+
+func targetName(name, kind string, idx int) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprintf("%s#%d", kind, idx)
+}
+
+//Run sends the notifications for the events of the queue and the digests of its jobs. It does not return
+func (notifier *Notifier) Run(queue *jobs.JobQueue) {
+	for _, d := range notifier.digests {
+		go notifier.runDigest(d, queue.List)
+	}
+	bus := queue.Events()
+	var lastID uint64
+	for {
+		missed, events, cancel := bus.Subscribe(lastID)
+		//the events from before the start were already there when the daemon started, only the ones after a drop are missed
+		if lastID != 0 {
+			for _, event := range missed {
+				lastID = event.ID
+				notifier.handle(event)
+			}
+		} else if len(missed) > 0 {
+			lastID = missed[len(missed)-1].ID
+		}
+		for event := range events {
+			lastID = event.ID
+			notifier.handle(event)
+		}
+		//the channel is closed if the notifier lagged behind, it resumes after the last event it got
+		cancel()
+	}
+}
+
+//handle sends the notifications of the event to the targets that want them
+func (notifier *Notifier) handle(event jobs.Event) {
+	notifier.mutex.Lock()
+	for _, d := range notifier.digests {
+		d.record(event)
+	}
+	notifier.mutex.Unlock()
+	for _, n := range notifier.notifications(event) {
+		for _, t := range notifier.targets {
+			if notifier.shouldSend(t, n) {
+				notifier.wg.Add(1)
+				go notifier.deliver(t, n)
+			}
+		}
+	}
+}
+
+//notifications turns the event into notifications and keeps track of failing jobs and preconditions
+func (notifier *Notifier) notifications(event jobs.Event) []Notification {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	if event.Run != nil && event.Run.AdHoc {
+		//ad hoc runs do not count for the schedule
+		return nil
+	}
+	n := Notification{Job: event.Job, Time: event.Time, Run: event.Run}
+	switch event.Type {
+	case jobs.EventJobFailed:
+		notifier.failing[event.Job] = true
+		n.Kind = KindFailure
+		n.Retries = event.Retry
+		n.Message = fmt.Sprintf("%s failed after %d retries", event.Job, event.Retry)
+		if event.Run != nil {
+			n.Message += fmt.Sprintf(", exit code %d", event.Run.ExitCode)
+		}
+		return []Notification{n}
+	case jobs.EventRunStarted:
+		delete(notifier.precondsSince, event.Job)
+	case jobs.EventRunFinished:
+		result := []Notification{}
+		if event.Run.Result == jobs.ResultPartial {
+			partial := n
+			partial.Kind = KindPartial
+			partial.Message = event.Job + " could not read all files, the snapshot is incomplete"
+			result = append(result, partial)
+		}
+		if (event.Run.Result == jobs.ResultSuccess || event.Run.Result == jobs.ResultPartial) && notifier.failing[event.Job] {
+			delete(notifier.failing, event.Job)
+			recovery := n
+			recovery.Kind = KindRecovery
+			recovery.Message = event.Job + " succeeded again"
+			result = append(result, recovery)
+		}
+		return result
+	case jobs.EventPreconditionsFailed:
+		since, ok := notifier.precondsSince[event.Job]
+		if !ok {
+			since = event.Time
+			notifier.precondsSince[event.Job] = since
+		}
+		n.Kind = KindPreconditions
+		n.Since = since
+		n.Message = fmt.Sprintf("The preconditions of %s fail since %s", event.Job, since.Format(time.RFC3339))
+		return []Notification{n}
+	case jobs.EventJobStale:
+		notifier.stale[event.Job] = true
+		n.Kind = KindStale
+		n.Since = event.At
+		if event.At.IsZero() {
+			n.Message = event.Job + " has no backup yet"
+		} else {
+			n.Message = fmt.Sprintf("The newest backup of %s is from %s, it is older than its MaxAge", event.Job, event.At.Format(time.RFC3339))
+		}
+		return []Notification{n}
+	case jobs.EventJobFresh:
+		if !notifier.stale[event.Job] {
+			return nil
+		}
+		delete(notifier.stale, event.Job)
+		n.Kind = KindRecovery
+		n.Message = event.Job + " has a recent backup again"
+		return []Notification{n}
+	case jobs.EventJobRemoved:
+		delete(notifier.failing, event.Job)
+		delete(notifier.precondsSince, event.Job)
+		delete(notifier.stale, event.Job)
+	}
+	return nil
+}
+
+//shouldSend applies the filter of the target and drops the notifications it already got lately
+func (notifier *Notifier) shouldSend(t target, n Notification) bool {
+	now := notifier.now()
+	if !t.filter().accepts(n, now) {
+		return false
+	}
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	key := t.name() + "\x00" + n.Job
+	if last, ok := notifier.lastSent[key]; ok && last.kind == n.Kind && now.Sub(last.at) < t.filter().dedup {
+		log.WithFields(log.Fields{"Job": n.Job, "Target": t.name(), "Kind": n.Kind}).Debug("Notification suppressed, it was sent lately")
+		return false
+	}
+	notifier.lastSent[key] = sent{kind: n.Kind, at: now}
+	return true
+}
+
+//deliver sends the notification, trying again with growing pauses if that fails
+func (notifier *Notifier) deliver(t target, n Notification) {
+	defer notifier.wg.Done()
+	fields := log.Fields{"Job": n.Job, "Target": t.name(), "Kind": n.Kind}
+	notifier.retry(fields, t.filter().retries, func() error { return t.send(n) })
+}
+
+//retry calls send until it succeeds, fails permanently or used up the retries
+func (notifier *Notifier) retry(fields log.Fields, retries int, send func() error) {
+	wait := notifier.backoff
+	for attempt := 0; ; attempt++ {
+		err := send()
+		if err == nil {
+			log.WithFields(fields).Info("Notification sent")
+			return
+		}
+		attemptFields := log.Fields{"Attempt": attempt + 1, "Error": err.Error()}
+		for key, value := range fields {
+			attemptFields[key] = value
+		}
+		if _, permanent := err.(permanentError); permanent || attempt >= retries {
+			log.WithFields(attemptFields).Error("Notification could not be sent")
+			return
+		}
+		log.WithFields(attemptFields).Warning("Notification could not be sent, trying again")
+		time.Sleep(wait)
+		if wait *= 2; wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+}
+
+//Wait waits for the deliveries in progress
+func (notifier *Notifier) Wait() {
+	notifier.wg.Wait()
+}
@@ -0,0 +1,191 @@
+package notify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//recorder is a webhook endpoint that answers with the given status codes, then 200
+type recorder struct {
+	sync.Mutex
+	bodies   []string
+	attempts int
+	statuses []int
+}
+
+func (rec *recorder) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	rec.Lock()
+	defer rec.Unlock()
+	rec.attempts++
+	if len(rec.statuses) > 0 {
+		status := rec.statuses[0]
+		rec.statuses = rec.statuses[1:]
+		wr.WriteHeader(status)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	rec.bodies = append(rec.bodies, string(body))
+}
+
+func newTestNotifier(t *testing.T, config WebhookConfig) (*Notifier, *recorder, func()) {
+	rec := &recorder{}
+	server := httptest.NewServer(rec)
+	config.URL = server.URL
+	notifier, err := New(Config{Webhooks: []WebhookConfig{config}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	notifier.backoff = time.Millisecond
+	return notifier, rec, server.Close
+}
+
+func run(result string, adHoc bool) *jobs.RunInfo {
+	return &jobs.RunInfo{Job: "backup", Result: result, ExitCode: 1, AdHoc: adHoc}
+}
+
+func TestWebhook(t *testing.T) {
+	notifier, rec, done := newTestNotifier(t, WebhookConfig{
+		Body:   `{"text": {{json .Message}}, "kind": "{{.Kind}}"}`,
+		Filter: Filter{Events: []string{KindFailure, KindRecovery}},
+	})
+	defer done()
+
+	send := func(event jobs.Event) {
+		event.Job = "backup"
+		event.Time = time.Now()
+		notifier.handle(event)
+		notifier.Wait()
+	}
+	send(jobs.Event{Type: jobs.EventJobFailed, Retry: 2, Run: run(jobs.ResultFailure, false)})
+	send(jobs.Event{Type: jobs.EventJobFailed, Retry: 2, Run: run(jobs.ResultFailure, false)})
+	send(jobs.Event{Type: jobs.EventRunFinished, Run: run(jobs.ResultSuccess, true)})
+	send(jobs.Event{Type: jobs.EventRunFinished, Run: run(jobs.ResultPartial, false)})
+	send(jobs.Event{Type: jobs.EventRunFinished, Run: run(jobs.ResultSuccess, false)})
+	send(jobs.Event{Type: jobs.EventJobFailed, Run: run(jobs.ResultFailure, false)})
+
+	expected := []string{
+		`{"text": "backup failed after 2 retries, exit code 1", "kind": "failure"}`,
+		`{"text": "backup succeeded again", "kind": "recovery"}`,
+		`{"text": "backup failed after 0 retries, exit code 1", "kind": "failure"}`,
+	}
+	if len(rec.bodies) != len(expected) {
+		t.Fatalf("Wrong notifications: %v", rec.bodies)
+	}
+	for idx, body := range expected {
+		if rec.bodies[idx] != body {
+			t.Errorf("Got %s, expected %s", rec.bodies[idx], body)
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	retries := 1
+	notifier, rec, done := newTestNotifier(t, WebhookConfig{Filter: Filter{Retries: &retries}})
+	defer done()
+	failed := jobs.Event{Type: jobs.EventJobFailed, Job: "backup", Run: run(jobs.ResultFailure, false)}
+
+	rec.statuses = []int{http.StatusBadGateway}
+	notifier.handle(failed)
+	notifier.Wait()
+	if rec.attempts != 2 || len(rec.bodies) != 1 {
+		t.Errorf("Not retried: %d attempts", rec.attempts)
+	}
+
+	rec.attempts = 0
+	rec.statuses = []int{http.StatusBadGateway, http.StatusBadGateway}
+	failed.Job = "check"
+	notifier.handle(failed)
+	notifier.Wait()
+	if rec.attempts != 2 {
+		t.Errorf("Retried too often: %d attempts", rec.attempts)
+	}
+
+	rec.attempts = 0
+	rec.statuses = []int{http.StatusNotFound}
+	failed.Job = "prune"
+	notifier.handle(failed)
+	notifier.Wait()
+	if rec.attempts != 1 {
+		t.Errorf("Client error retried: %d attempts", rec.attempts)
+	}
+}
+
+func TestPreconditionsFailingFor(t *testing.T) {
+	notifier, rec, done := newTestNotifier(t, WebhookConfig{Filter: Filter{PreconditionsFailingFor: "1h", Dedup: "1m"}})
+	defer done()
+	start := time.Now()
+	notifier.now = func() time.Time { return start }
+	failed := jobs.Event{Type: jobs.EventPreconditionsFailed, Job: "backup", Time: start}
+
+	notifier.handle(failed)
+	notifier.Wait()
+	if len(rec.bodies) != 0 {
+		t.Error("Sent before the preconditions failed long enough")
+	}
+
+	notifier.now = func() time.Time { return start.Add(2 * time.Hour) }
+	failed.Time = start.Add(2 * time.Hour)
+	notifier.handle(failed)
+	notifier.Wait()
+	if len(rec.bodies) != 1 {
+		t.Fatal("Not sent after the preconditions failed long enough")
+	}
+
+	//a run resets the time
+	notifier.handle(jobs.Event{Type: jobs.EventRunStarted, Job: "backup", Run: run("", false)})
+	notifier.now = func() time.Time { return start.Add(3 * time.Hour) }
+	failed.Time = start.Add(3 * time.Hour)
+	notifier.handle(failed)
+	notifier.Wait()
+	if len(rec.bodies) != 1 {
+		t.Error("Sent although the job ran in between")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if _, err := New(Config{Webhooks: []WebhookConfig{{URL: "http://localhost", Filter: Filter{Events: []string{"success"}}}}}); err == nil {
+		t.Error("Unknown event accepted")
+	}
+	if _, err := New(Config{Webhooks: []WebhookConfig{{URL: "http://localhost", Body: "{{.Nope"}}}); err == nil {
+		t.Error("Invalid template accepted")
+	}
+	if _, err := New(Config{Webhooks: []WebhookConfig{{}}}); err == nil {
+		t.Error("Webhook without URL accepted")
+	}
+	//days like for the MaxAge of the jobs
+	notifier, err := New(Config{Webhooks: []WebhookConfig{{URL: "http://localhost", Filter: Filter{Dedup: "1d", PreconditionsFailingFor: "1d12h"}}}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if f := notifier.targets[0].filter(); f.dedup != 24*time.Hour || f.preconditionsFor != 36*time.Hour {
+		t.Errorf("Wrong durations %v %v", f.dedup, f.preconditionsFor)
+	}
+}
+
+func TestStale(t *testing.T) {
+	notifier, rec, done := newTestNotifier(t, WebhookConfig{Body: "{{.Kind}}: {{.Message}}"})
+	defer done()
+	newest := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, event := range []jobs.Event{
+		{Type: jobs.EventJobFresh, Job: "backup"},
+		{Type: jobs.EventJobStale, Job: "backup", At: newest},
+		{Type: jobs.EventJobFresh, Job: "backup"},
+	} {
+		notifier.handle(event)
+		notifier.Wait()
+	}
+	expected := []string{
+		"stale: The newest backup of backup is from 2026-01-02T03:04:05Z, it is older than its MaxAge",
+		"recovery: backup has a recent backup again",
+	}
+	if len(rec.bodies) != len(expected) || rec.bodies[0] != expected[0] || rec.bodies[1] != expected[1] {
+		t.Errorf("Wrong notifications %q", rec.bodies)
+	}
+}
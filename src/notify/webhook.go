@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+//WebhookConfig is a http endpoint the notifications are sent to. Body is a text/template of a Notification,
+//so it can be shaped for slack, matrix, ntfy, ... Without Body the notification is sent as json
+type WebhookConfig struct {
+	Name    string
+	URL     string
+	Method  string
+	Headers map[string]string
+	Body    string
+	Filter  `mapstructure:",squash"`
+}
+
+//webhookTimeout limits one attempt to deliver a notification
+const webhookTimeout = 30 * time.Second
+
+type webhook struct {
+	config WebhookConfig
+	body   *template.Template
+	f      *filter
+	client *http.Client
+}
+
+//templateFuncs are available in the templates. json quotes a value, so it can be put into json bodies safely,
+//bytes makes a size readable
+var templateFuncs = template.FuncMap{
+	"bytes":  formatBytes,
+	"growth": formatGrowth,
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func newWebhook(config WebhookConfig) (*webhook, error) {
+	if config.URL == "" {
+		return nil, errors.New("URL is missing")
+	}
+	if config.Method == "" {
+		config.Method = "POST"
+	}
+	f, err := newFilter(config.Filter)
+	if err != nil {
+		return nil, err
+	}
+	hook := &webhook{config: config, f: f, client: &http.Client{Timeout: webhookTimeout}}
+	if config.Body != "" {
+		if hook.body, err = template.New("body").Funcs(templateFuncs).Parse(config.Body); err != nil {
+			return nil, err
+		}
+	}
+	return hook, nil
+}
+
+func (hook *webhook) name() string {
+	if hook.config.Name != "" {
+		return hook.config.Name
+	}
+	return hook.config.URL
+}
+
+func (hook *webhook) filter() *filter {
+	return hook.f
+}
+
+func (hook *webhook) render(n Notification) ([]byte, error) {
+	if hook.body == nil {
+		return json.Marshal(n)
+	}
+	var body bytes.Buffer
+	if err := hook.body.Execute(&body, n); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+func (hook *webhook) send(n Notification) error {
+	body, err := hook.render(n)
+	if err != nil {
+		return permanentError{err}
+	}
+	req, err := http.NewRequest(hook.config.Method, hook.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	if hook.body == nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range hook.config.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := hook.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s answered %s", hook.name(), resp.Status)
+	//client errors will not go away, except for timeouts and rate limits
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
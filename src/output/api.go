@@ -0,0 +1,364 @@
+package output
+
+import (
+	_ "embed" //for the openapi document
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//apiPrefix is the prefix of all endpoints of the versioned api
+const apiPrefix = "/api/v1"
+
+//openAPIDocument describes the api, keep it in sync with the routes
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+//apiHandlerFunc handles a request. params holds the values of the {placeholders} in the path
+type apiHandlerFunc func(wr http.ResponseWriter, r *http.Request, params map[string]string)
+
+type apiRoute struct {
+	method  string
+	pattern string
+	handler apiHandlerFunc
+}
+
+//apiServer serves the versioned api of the queue
+type apiServer struct {
+	queue  *jobs.JobQueue
+	routes []apiRoute
+}
+
+func newAPIServer(queue *jobs.JobQueue) *apiServer {
+	api := &apiServer{queue: queue}
+	api.routes = []apiRoute{
+		{"GET", "/jobs", api.listJobs},
+		{"GET", "/jobs/{name}", api.getJob},
+		{"PUT", "/jobs/{name}", api.putJob},
+		{"DELETE", "/jobs/{name}", api.deleteJob},
+		{"GET", "/jobs/{name}/definition", api.getDefinition},
+		{"GET", "/jobs/{name}/output", api.getOutput},
+		{"GET", "/jobs/{name}/runs", api.listRuns},
+		{"GET", "/jobs/{name}/runs/{id}/log", api.getRunLog},
+		{"POST", "/jobs/{name}/trigger", api.jobAction(api.queue.TriggerJob)},
+		{"POST", "/jobs/{name}/restart", api.jobAction(api.queue.RestartJob)},
+		{"POST", "/jobs/{name}/reload", api.jobAction(api.queue.ReloadJob)},
+		{"POST", "/jobs/{name}/pause", api.jobAction(api.queue.PauseJob)},
+		{"POST", "/jobs/{name}/resume", api.jobAction(api.queue.ResumeJob)},
+		{"POST", "/jobs/{name}/skip-next", api.jobAction(api.queue.SkipNextJob)},
+		{"POST", "/jobs/{name}/stop", api.jobOperation(api.queue.StartStop)},
+		{"POST", "/jobs/{name}/cancel", api.jobOperation(api.queue.CancelJob)},
+		{"POST", "/jobs/{name}/adhoc", api.startAdHoc},
+		{"POST", "/stop-all", api.stopAll},
+		{"GET", "/graph", api.getGraph},
+		{"GET", "/events", api.getEvents},
+		{"GET", "/maintenance", api.getMaintenance},
+		{"PUT", "/maintenance", api.setMaintenance},
+		{"GET", "/operations/{id}", api.getOperation},
+		{"GET", "/openapi.json", api.getOpenAPI},
+	}
+	return api
+}
+
+//matchPath matches the path against a pattern like /jobs/{name} and returns the values of the placeholders
+func matchPath(pattern, path string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for idx, part := range patternParts {
+		value, err := url.PathUnescape(pathParts[idx])
+		if err != nil {
+			return nil, false
+		}
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if value == "" {
+				return nil, false
+			}
+			params[strings.Trim(part, "{}")] = value
+		} else if part != value {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (api *apiServer) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	//the escaped path keeps %2F in job names from splitting the path
+	path := strings.TrimPrefix(r.URL.EscapedPath(), apiPrefix)
+	allowed := make([]string, 0)
+	for _, route := range api.routes {
+		params, ok := matchPath(route.pattern, path)
+		if !ok {
+			continue
+		}
+		if route.method == r.Method {
+			route.handler(wr, r, params)
+			return
+		}
+		allowed = append(allowed, route.method)
+	}
+	if len(allowed) > 0 {
+		wr.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(wr, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed here")
+		return
+	}
+	writeError(wr, http.StatusNotFound, "not_found", "No such endpoint")
+}
+
+func writeJSON(wr http.ResponseWriter, status int, v interface{}) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(status)
+	json.NewEncoder(wr).Encode(v)
+}
+
+func writeError(wr http.ResponseWriter, status int, code, message string) {
+	writeJSON(wr, status, ErrorDTO{Error: ErrorBodyDTO{Code: code, Message: message}})
+}
+
+//writeQueueError maps the errors of the queue to status codes
+func writeQueueError(wr http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNoSuchJob), errors.Is(err, jobs.ErrNoSuchOperation), errors.Is(err, jobs.ErrNoDefinition),
+		errors.Is(err, jobs.ErrNoSuchRun):
+		writeError(wr, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, jobs.ErrJobPaused), errors.Is(err, jobs.ErrNotRunning), errors.Is(err, jobs.ErrNotStopped), errors.Is(err, jobs.ErrSharedFile):
+		writeError(wr, http.StatusConflict, "conflict", err.Error())
+	case errors.Is(err, jobs.ErrETagRequired):
+		writeError(wr, http.StatusPreconditionRequired, "precondition_required", err.Error())
+	case errors.Is(err, jobs.ErrETagMismatch):
+		writeError(wr, http.StatusPreconditionFailed, "precondition_failed", err.Error())
+	case errors.Is(err, jobs.ErrInvalidArgument):
+		writeError(wr, http.StatusBadRequest, "bad_request", err.Error())
+	default:
+		writeError(wr, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
+
+//readJSON decodes the body strictly, unknown keys are an error
+func readJSON(wr http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(wr, http.StatusBadRequest, "bad_request", "Invalid body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (api *apiServer) writeJob(wr http.ResponseWriter, name string) {
+	job, _ := api.queue.FindJob(name)
+	if job == nil {
+		writeQueueError(wr, jobs.ErrNoSuchJob)
+		return
+	}
+	writeJSON(wr, http.StatusOK, newJobDTO(job))
+}
+
+func (api *apiServer) writeOperation(wr http.ResponseWriter, op jobs.Operation) {
+	wr.Header().Set("Location", apiPrefix+"/operations/"+op.ID)
+	writeJSON(wr, http.StatusAccepted, newOperationDTO(op))
+}
+
+func (api *apiServer) listJobs(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	list := api.queue.List()
+	dtos := make([]JobDTO, 0, len(list))
+	for _, job := range list {
+		dtos = append(dtos, newJobDTO(job))
+	}
+	writeJSON(wr, http.StatusOK, dtos)
+}
+
+func (api *apiServer) getJob(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	api.writeJob(wr, params["name"])
+}
+
+//maxDefinitionSize limits the body of a PUT of a definition
+const maxDefinitionSize = 1 << 20
+
+//definitionWait is how long a PUT or DELETE of a definition waits for the old job to stop before it answers with the operation
+const definitionWait = 2 * time.Second
+
+func (api *apiServer) putJob(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	content, err := ioutil.ReadAll(io.LimitReader(r.Body, maxDefinitionSize))
+	if err != nil {
+		writeError(wr, http.StatusBadRequest, "bad_request", "Invalid body: "+err.Error())
+		return
+	}
+	etag, created, op, err := api.queue.PutJob(params["name"], content, r.Header.Get("If-Match"))
+	if err != nil {
+		writeQueueError(wr, err)
+		return
+	}
+	wr.Header().Set("ETag", etag)
+	//the old job is still running, the new one starts when it finished
+	if op, err = api.queue.WaitOperation(op.ID, definitionWait); err == nil && op.Pending() {
+		api.writeOperation(wr, op)
+		return
+	}
+	job, _ := api.queue.FindJob(params["name"])
+	if job == nil {
+		writeQueueError(wr, jobs.ErrNoSuchJob)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		wr.Header().Set("Location", apiPrefix+"/jobs/"+url.PathEscape(params["name"]))
+	}
+	writeJSON(wr, status, newJobDTO(job))
+}
+
+//deleteJob archives the definition of the job, or deletes it with ?archive=false, and stops the job
+func (api *apiServer) deleteJob(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	archive := r.URL.Query().Get("archive") != "false"
+	op, err := api.queue.DeleteJob(params["name"], archive, r.Header.Get("If-Match"))
+	if err != nil {
+		writeQueueError(wr, err)
+		return
+	}
+	if op, err = api.queue.WaitOperation(op.ID, definitionWait); err == nil && op.Pending() {
+		api.writeOperation(wr, op)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+//getDefinition serves the file that defines the job with its ETag, or with ?resolved=true the definition after resolving templates and instances
+func (api *apiServer) getDefinition(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	job, _ := api.queue.FindJob(params["name"])
+	if job == nil {
+		writeQueueError(wr, jobs.ErrNoSuchJob)
+		return
+	}
+	if r.URL.Query().Get("resolved") == "true" {
+		writeJSON(wr, http.StatusOK, job.Definition())
+		return
+	}
+	source, err := api.queue.Source(params["name"])
+	if err != nil {
+		writeQueueError(wr, err)
+		return
+	}
+	contentType := "application/json"
+	switch filepath.Ext(source.File) {
+	case ".yaml", ".yml":
+		contentType = "application/yaml"
+	case ".toml":
+		contentType = "application/toml"
+	}
+	wr.Header().Set("Content-Type", contentType)
+	wr.Header().Set("ETag", source.ETag)
+	wr.Write(source.Content)
+}
+
+//getOutput serves the last lines restic printed in the current or the last run of the job
+func (api *apiServer) getOutput(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	job, _ := api.queue.FindJob(params["name"])
+	if job == nil {
+		writeQueueError(wr, jobs.ErrNoSuchJob)
+		return
+	}
+	writeJSON(wr, http.StatusOK, newOutputDTO(job.Output()))
+}
+
+//jobAction wraps a synchronous action of the queue. The response is the job after the action
+func (api *apiServer) jobAction(action func(name string) error) apiHandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+		if err := action(params["name"]); err != nil {
+			writeQueueError(wr, err)
+			return
+		}
+		api.writeJob(wr, params["name"])
+	}
+}
+
+//jobOperation wraps an action that runs in the background. The response is the operation
+func (api *apiServer) jobOperation(action func(name string) (jobs.Operation, error)) apiHandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+		op, err := action(params["name"])
+		if err != nil {
+			writeQueueError(wr, err)
+			return
+		}
+		api.writeOperation(wr, op)
+	}
+}
+
+//startAdHoc runs the job once in the background. With ?wait=true the output of restic is streamed as plain text instead,
+//followed by the result
+func (api *apiServer) startAdHoc(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	var req AdHocRequestDTO
+	if !readJSON(wr, r, &req) {
+		return
+	}
+	adHoc := &jobs.AdHocRun{Arguments: req.Arguments, Replace: req.Replace}
+	if r.URL.Query().Get("wait") == "true" {
+		wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		adHoc.Output = &flushWriter{wr: wr}
+		info, err := api.queue.RunAdHoc(params["name"], adHoc)
+		if err != nil {
+			writeQueueError(wr, err)
+			return
+		}
+		fmt.Fprintf(wr, "Result: %s (exit code %d)\n", info.Result, info.ExitCode)
+		return
+	}
+	op, err := api.queue.StartAdHoc(params["name"], adHoc)
+	if err != nil {
+		writeQueueError(wr, err)
+		return
+	}
+	api.writeOperation(wr, op)
+}
+
+func (api *apiServer) stopAll(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	api.writeOperation(wr, api.queue.StartStopAll())
+}
+
+func (api *apiServer) getGraph(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeJSON(wr, http.StatusOK, newGraphDTO(api.queue.Graph()))
+}
+
+func (api *apiServer) getEvents(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	serveEvents(api.queue)(wr, r)
+}
+
+func (api *apiServer) getMaintenance(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	writeJSON(wr, http.StatusOK, MaintenanceDTO{Enabled: api.queue.InMaintenance()})
+}
+
+func (api *apiServer) setMaintenance(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	var req MaintenanceDTO
+	if !readJSON(wr, r, &req) {
+		return
+	}
+	api.queue.SetMaintenance(req.Enabled)
+	writeJSON(wr, http.StatusOK, MaintenanceDTO{Enabled: api.queue.InMaintenance()})
+}
+
+func (api *apiServer) getOperation(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	op, err := api.queue.Operation(params["id"])
+	if err != nil {
+		writeQueueError(wr, err)
+		return
+	}
+	writeJSON(wr, http.StatusOK, newOperationDTO(op))
+}
+
+func (api *apiServer) getOpenAPI(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.Write(openAPIDocument)
+}
@@ -0,0 +1,217 @@
+package output
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+func newTestQueue(t *testing.T) (*jobs.JobQueue, func()) {
+	dir, err := ioutil.TempDir("", "rc-api")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	job := `{"JobName": "backup", "ResticPath": "/bin/true", "NextJob": "check"}`
+	ioutil.WriteFile(path.Join(dir, "backup.json"), []byte(job), 0600)
+	queue, err := jobs.NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.StartQueue()
+	return queue, func() {
+		queue.StopAllJobs()
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestAPI(t *testing.T) (*httptest.Server, func()) {
+	queue, cleanup := newTestQueue(t)
+	server := httptest.NewServer(newAPIServer(queue))
+	return server, func() {
+		server.Close()
+		cleanup()
+	}
+}
+
+func request(t *testing.T, method, url, body string, v interface{}) *http.Response {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Errorf("%s %s: body is no json: %s", method, url, err.Error())
+		}
+	}
+	return resp
+}
+
+func TestAPI(t *testing.T) {
+	server, cleanup := newTestAPI(t)
+	defer cleanup()
+	base := server.URL + apiPrefix
+
+	var list []JobDTO
+	request(t, "GET", base+"/jobs", "", &list)
+	if len(list) != 1 || list[0].Name != "backup" || list[0].NextJobs[0] != "check" {
+		t.Error("Wrong job list")
+	}
+
+	var apiErr ErrorDTO
+	resp := request(t, "GET", base+"/jobs/nope", "", &apiErr)
+	if resp.StatusCode != http.StatusNotFound || apiErr.Error.Code != "not_found" {
+		t.Errorf("Unknown job: %d %v", resp.StatusCode, apiErr)
+	}
+	resp = request(t, "POST", base+"/jobs/backup", "", &apiErr)
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, PUT, DELETE" {
+		t.Errorf("Wrong method: %d %s", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	resp = request(t, "POST", base+"/jobs/backup/cancel", "", &apiErr)
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Cancel of an idle job: %d", resp.StatusCode)
+	}
+	resp = request(t, "POST", base+"/jobs/backup/adhoc", `{"Arguments": ["${nope}"]}`, &apiErr)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Ad hoc with an unknown variable: %d", resp.StatusCode)
+	}
+
+	var job JobDTO
+	resp = request(t, "POST", base+"/jobs/backup/pause", "", &job)
+	if resp.StatusCode != http.StatusOK || !job.Paused {
+		t.Error("Pause did not return the paused job")
+	}
+
+	var op OperationDTO
+	resp = request(t, "POST", base+"/jobs/backup/adhoc", `{"Arguments": ["--dry-run"]}`, &op)
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Location") != apiPrefix+"/operations/"+op.ID {
+		t.Errorf("Ad hoc run: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp = request(t, "GET", base+"/operations/"+op.ID, "", &op)
+	if resp.StatusCode != http.StatusOK || op.Action != "adhoc" {
+		t.Error("Operation not found")
+	}
+
+	resp = request(t, "PUT", base+"/jobs/check", `{"ResticPath": "/bin/true"}`, &job)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("ETag") == "" || job.Name != "check" {
+		t.Errorf("Job not created: %d", resp.StatusCode)
+	}
+	etag := resp.Header.Get("ETag")
+	resp = request(t, "PUT", base+"/jobs/check", `{"ResticPath": "/bin/false"}`, &apiErr)
+	if resp.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("Update without If-Match: %d", resp.StatusCode)
+	}
+	resp = request(t, "GET", base+"/jobs/check/definition", "", nil)
+	if resp.Header.Get("ETag") != etag {
+		t.Error("Definition served with another ETag")
+	}
+
+	var output OutputDTO
+	resp = request(t, "GET", base+"/jobs/backup/output", "", &output)
+	if resp.StatusCode != http.StatusOK || output.Lines == nil {
+		t.Errorf("Output not served: %d", resp.StatusCode)
+	}
+}
+
+func TestAdHocWait(t *testing.T) {
+	server, cleanup := newTestAPI(t)
+	defer cleanup()
+
+	resp := request(t, "POST", server.URL+apiPrefix+"/jobs/backup/adhoc?wait=true", `{"Arguments": ["--dry-run"]}`, nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Ad hoc run not waited for: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+//the old endpoints only change something on POST
+func TestLegacyRoutes(t *testing.T) {
+	queue, cleanup := newTestQueue(t)
+	defer cleanup()
+	server := httptest.NewServer(newMux(queue))
+	defer server.Close()
+
+	resp := request(t, "GET", server.URL+"/pause?name=backup", "", nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "POST" || resp.Header.Get("Deprecation") != "true" {
+		t.Errorf("GET changed something: %d %s", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	if job, _ := queue.FindJob("backup"); job.Snapshot().Paused {
+		t.Error("Job paused by a GET")
+	}
+	resp = request(t, "POST", server.URL+"/pause?name=backup", "", nil)
+	if job, _ := queue.FindJob("backup"); resp.StatusCode != http.StatusOK || !job.Snapshot().Paused {
+		t.Errorf("POST did not pause the job: %d", resp.StatusCode)
+	}
+	resp = request(t, "GET", server.URL+"/maintenance?state=on", "", nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed || queue.InMaintenance() {
+		t.Errorf("Maintenance changed by a GET: %d", resp.StatusCode)
+	}
+	for _, p := range []string{"/maintenance", "/queue", "/graph"} {
+		if resp = request(t, "GET", server.URL+p, "", nil); resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: %d", p, resp.StatusCode)
+		}
+	}
+}
+
+//every route must be described in the openapi document
+func TestOpenAPIDocument(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		t.Fatal(err.Error())
+	}
+	api := newAPIServer(nil)
+	for _, route := range api.routes {
+		if doc.Paths[route.pattern][strings.ToLower(route.method)] == nil {
+			t.Errorf("%s %s is missing in openapi.json", route.method, route.pattern)
+		}
+	}
+}
+
+//the jobs are listed while a backup reports its progress, go test -race finds reads without the locks of the jobs
+func TestListJobsWhileRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-api")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	//a file, the arguments of a job are expanded
+	script := path.Join(dir, "restic.sh")
+	ioutil.WriteFile(script, []byte(`for i in 1 2 3 4 5 6 7 8 9; do echo "{\"message_type\":\"status\",\"percent_done\":0.$i}"; sleep 0.01; done`), 0600)
+	job := `{"JobName": "backup", "ResticPath": "/bin/sh", "ResticArguments": ["` + script + `"]}`
+	ioutil.WriteFile(path.Join(dir, "backup.json"), []byte(job), 0600)
+	queue, err := jobs.NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.StartQueue()
+	defer queue.StopAllJobs()
+	server := httptest.NewServer(newAPIServer(queue))
+	defer server.Close()
+
+	queue.TriggerJob("backup")
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		var list []JobDTO
+		request(t, "GET", server.URL+apiPrefix+"/jobs", "", &list)
+		if len(list) != 1 {
+			t.Fatalf("Wrong job list %v", list)
+		}
+		if _, err := json.Marshal(queue); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if found, _ := queue.FindJob("backup"); found.Snapshot().Progress != 90 {
+		t.Errorf("Wrong progress %v", found.Snapshot().Progress)
+	}
+}
@@ -0,0 +1,208 @@
+package output
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	keyring "github.com/zalando/go-keyring"
+)
+
+//roles of the credentials. read may only look, admin may also change things
+const (
+	RoleRead  = "read"
+	RoleAdmin = "admin"
+)
+
+//Secret is a token or password. It is given directly, read from a file or taken from the keyring (Service and Username)
+type Secret struct {
+	Value    string
+	File     string
+	Service  string
+	Username string
+}
+
+func (secret *Secret) resolve() (string, error) {
+	switch {
+	case secret.Value != "":
+		return secret.Value, nil
+	case secret.File != "":
+		data, err := ioutil.ReadFile(secret.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case secret.Service != "" || secret.Username != "":
+		return keyring.Get(secret.Service, secret.Username)
+	}
+	return "", errors.New("secret needs a Value, a File or a Service and Username for the keyring")
+}
+
+//Credential grants a role to a bearer token, or to a User with a Password for basic auth
+type Credential struct {
+	Name     string
+	Role     string
+	User     string
+	Token    *Secret
+	Password *Secret
+}
+
+//resolvedCredential is a credential with its secret read
+type resolvedCredential struct {
+	name   string
+	role   string
+	user   string
+	secret string
+	basic  bool
+}
+
+//authenticator checks the credentials of the requests. Without credentials every request is allowed
+type authenticator struct {
+	credentials []resolvedCredential
+	basic       bool
+}
+
+func newAuthenticator(credentials []Credential) (*authenticator, error) {
+	auth := &authenticator{}
+	for idx, cred := range credentials {
+		name := cred.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", idx)
+		}
+		if cred.Role != RoleRead && cred.Role != RoleAdmin {
+			return nil, fmt.Errorf("credential %s: Role must be %q or %q", name, RoleRead, RoleAdmin)
+		}
+		resolved := resolvedCredential{name: name, role: cred.Role, user: cred.User}
+		var secret *Secret
+		switch {
+		case cred.Token != nil && cred.Password == nil:
+			secret = cred.Token
+		case cred.Password != nil && cred.Token == nil && cred.User != "":
+			secret = cred.Password
+			resolved.basic = true
+			auth.basic = true
+		default:
+			return nil, fmt.Errorf("credential %s: needs either a Token or a User with a Password", name)
+		}
+		value, err := secret.resolve()
+		if err != nil {
+			return nil, fmt.Errorf("credential %s: %s", name, err.Error())
+		}
+		if value == "" {
+			return nil, fmt.Errorf("credential %s: the secret is empty", name)
+		}
+		resolved.secret = value
+		auth.credentials = append(auth.credentials, resolved)
+	}
+	return auth, nil
+}
+
+func secretsEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+//authenticate finds the credential the request was made with
+func (auth *authenticator) authenticate(r *http.Request) *resolvedCredential {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		for idx := range auth.credentials {
+			cred := &auth.credentials[idx]
+			if !cred.basic && secretsEqual(cred.secret, token) {
+				return cred
+			}
+		}
+		return nil
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		for idx := range auth.credentials {
+			cred := &auth.credentials[idx]
+			if cred.basic && cred.user == user && secretsEqual(cred.secret, password) {
+				return cred
+			}
+		}
+	}
+	return nil
+}
+
+//readOnlyEndpoints are the old endpoints that only show things
+var readOnlyEndpoints = map[string]bool{
+	"/queue":      true,
+	"/graph":      true,
+	"/definition": true,
+	"/operation":  true,
+	"/events":     true,
+	"/metrics":    true,
+}
+
+//needsAdmin reports if the request changes something. The api and the dashboard use the methods, the old endpoints are all GET
+func needsAdmin(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, apiPrefix+"/") || strings.HasPrefix(r.URL.Path, dashboardPrefix) || r.URL.Path == "/" {
+		return r.Method != "GET" && r.Method != "HEAD"
+	}
+	if r.URL.Path == "/maintenance" {
+		return r.URL.Query().Get("state") != ""
+	}
+	return !readOnlyEndpoints[r.URL.Path]
+}
+
+//denied answers with a json error for the api and plain text for the old endpoints
+func denied(wr http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+		writeError(wr, status, code, message)
+		return
+	}
+	http.Error(wr, message, status)
+}
+
+//wrap lets only authenticated requests through to next, and requests that change something only with the admin role
+func (auth *authenticator) wrap(next http.Handler) http.Handler {
+	if len(auth.credentials) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		cred := auth.authenticate(r)
+		if cred == nil {
+			log.WithFields(log.Fields{"Remote": r.RemoteAddr, "Path": r.URL.Path}).Warning("Unauthenticated request")
+			wr.Header().Add("WWW-Authenticate", `Bearer realm="restic-cronned"`)
+			if auth.basic {
+				wr.Header().Add("WWW-Authenticate", `Basic realm="restic-cronned"`)
+			}
+			denied(wr, r, http.StatusUnauthorized, "unauthorized", "Authentication required")
+			return
+		}
+		serveWithRole(wr, r, next, cred.role, cred.name)
+	})
+}
+
+//serveWithRole passes the request to next if the role allows it
+func serveWithRole(wr http.ResponseWriter, r *http.Request, next http.Handler, role, name string) {
+	if role == "" || (needsAdmin(r) && role != RoleAdmin) {
+		log.WithFields(log.Fields{"Remote": r.RemoteAddr, "Path": r.URL.Path, "Credential": name}).Warning("Forbidden request")
+		if role == "" {
+			denied(wr, r, http.StatusForbidden, "forbidden", "Not allowed to use this server")
+		} else {
+			denied(wr, r, http.StatusForbidden, "forbidden", "The admin role is needed for this")
+		}
+		return
+	}
+	next.ServeHTTP(wr, r)
+}
+
+//isLocalAddress reports if the server only listens on the loopback interface
+func isLocalAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
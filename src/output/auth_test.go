@@ -0,0 +1,73 @@
+package output
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "rc-token")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString("admintoken\n")
+	tokenFile.Close()
+
+	auth, err := newAuthenticator([]Credential{
+		{Name: "admin", Role: RoleAdmin, Token: &Secret{File: tokenFile.Name()}},
+		{Name: "viewer", Role: RoleRead, User: "viewer", Password: &Secret{Value: "secret"}},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	handler := auth.wrap(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {}))
+
+	check := func(method, path string, set func(r *http.Request), expected int) {
+		r := httptest.NewRequest(method, path, nil)
+		if set != nil {
+			set(r)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != expected {
+			t.Errorf("%s %s: got %d, expected %d", method, path, rec.Code, expected)
+		}
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	viewer := func(r *http.Request) { r.SetBasicAuth("viewer", "secret") }
+
+	check("GET", "/queue", nil, http.StatusUnauthorized)
+	check("GET", "/queue", bearer("wrong"), http.StatusUnauthorized)
+	check("GET", "/queue", bearer("admintoken"), http.StatusOK)
+	check("GET", "/queue", viewer, http.StatusOK)
+	check("GET", "/stopall", viewer, http.StatusForbidden)
+	check("GET", "/maintenance", viewer, http.StatusOK)
+	check("GET", "/maintenance?state=on", viewer, http.StatusForbidden)
+	check("GET", apiPrefix+"/jobs", viewer, http.StatusOK)
+	check("GET", dashboardPrefix+"app.js", viewer, http.StatusOK)
+	check("POST", apiPrefix+"/jobs/a/trigger", viewer, http.StatusForbidden)
+	check("POST", apiPrefix+"/jobs/a/trigger", bearer("admintoken"), http.StatusOK)
+
+	_, err = newAuthenticator([]Credential{{Role: "root", Token: &Secret{Value: "x"}}})
+	if err == nil {
+		t.Error("Unknown role accepted")
+	}
+	_, err = newAuthenticator([]Credential{{Role: RoleRead, Password: &Secret{Value: "x"}}})
+	if err == nil {
+		t.Error("Password without user accepted")
+	}
+}
+
+func TestLocalAddress(t *testing.T) {
+	for address, local := range map[string]bool{"localhost:8080": true, "127.0.0.1:80": true, "[::1]:80": true, ":8080": false, "0.0.0.0:8080": false} {
+		if isLocalAddress(address) != local {
+			t.Errorf("%s: expected local=%v", address, local)
+		}
+	}
+}
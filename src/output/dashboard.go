@@ -0,0 +1,38 @@
+package output
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+//dashboardPrefix is where the web dashboard is served
+const dashboardPrefix = "/ui/"
+
+//webFiles are the static files of the dashboard. They are embedded so the binary needs nothing else
+//
+//go:embed web
+var webFiles embed.FS
+
+//newDashboardHandler serves the dashboard under /ui/ and redirects / to it
+func newDashboardHandler() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	fileServer := http.StripPrefix(dashboardPrefix, http.FileServer(http.FS(files)))
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			http.Redirect(wr, r, dashboardPrefix, http.StatusFound)
+		case r.Method != "GET" && r.Method != "HEAD":
+			wr.Header().Set("Allow", "GET, HEAD")
+			http.Error(wr, r.Method+" is not allowed here", http.StatusMethodNotAllowed)
+		case strings.HasPrefix(r.URL.Path, dashboardPrefix):
+			fileServer.ServeHTTP(wr, r)
+		default:
+			http.NotFound(wr, r)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package output
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	server := httptest.NewServer(newDashboardHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	index, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Request.URL.Path != dashboardPrefix || !strings.Contains(string(index), "app.js") {
+		t.Error("/ does not lead to the dashboard")
+	}
+
+	//the dashboard has to work offline, so it may not load anything from other hosts
+	external := regexp.MustCompile(`(src|href)="(https?:)?//`)
+	for _, file := range []string{"", "app.js", "style.css"} {
+		resp, err := http.Get(server.URL + dashboardPrefix + file)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		content, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: %d", file, resp.StatusCode)
+		}
+		if external.Match(content) || strings.Contains(string(content), "@import") {
+			t.Errorf("%s loads something from another host", file)
+		}
+	}
+
+	resp, _ = http.Get(server.URL + "/nothing")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("Unknown path not answered with 404")
+	}
+}
@@ -0,0 +1,232 @@
+package output
+
+import (
+	"time"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//The types in this file are what the api serves. They are kept separate from the internal types so these can change
+//without breaking clients. All times are RFC 3339 strings, empty if not set
+
+//JobDTO is a job as served by the api
+type JobDTO struct {
+	Name             string   `json:"Name"`
+	Status           string   `json:"Status"`
+	Paused           bool     `json:"Paused"`
+	SkipNext         bool     `json:"SkipNext"`
+	Progress         float64  `json:"Progress"`
+	Retries          int      `json:"Retries"`
+	MaxFailedRetries int      `json:"MaxFailedRetries"`
+	NextRun          string   `json:"NextRun,omitempty"`
+	Repository       string   `json:"Repository,omitempty"`
+	SourceFile       string   `json:"SourceFile,omitempty"`
+	NextJobs         []string `json:"NextJobs"`
+	OnFailureJobs    []string `json:"OnFailureJobs"`
+	OnPartialJobs    []string `json:"OnPartialJobs"`
+	AfterAll         []string `json:"AfterAll"`
+	MaxAge           string   `json:"MaxAge,omitempty"`
+	Stale            bool     `json:"Stale"`
+	StaleSince       string   `json:"StaleSince,omitempty"`
+	NewestBackup     string   `json:"NewestBackup,omitempty"`
+	StaleError       string   `json:"StaleError,omitempty"`
+	LastRun          *RunDTO  `json:"LastRun,omitempty"`
+	History          []RunDTO `json:"History"`
+}
+
+//RunDTO is one run of a job
+type RunDTO struct {
+	ID         int    `json:"ID"`
+	Result     string `json:"Result"`
+	ExitCode   int    `json:"ExitCode"`
+	SnapshotID string `json:"SnapshotID,omitempty"`
+	Started    string `json:"Started"`
+	Finished   string `json:"Finished"`
+	AdHoc      bool   `json:"AdHoc"`
+	//only set for backups with --json
+	BytesAdded     uint64 `json:"BytesAdded"`
+	BytesProcessed uint64 `json:"BytesProcessed"`
+	FilesNew       uint64 `json:"FilesNew"`
+	FilesChanged   uint64 `json:"FilesChanged"`
+}
+
+//OutputDTO is the tail of the output of the current or the last run of a job
+type OutputDTO struct {
+	RunID   int      `json:"RunID,omitempty"`
+	Running bool     `json:"Running"`
+	Started string   `json:"Started,omitempty"`
+	Lines   []string `json:"Lines"`
+}
+
+//EventDTO is an event of a job, sent on the event stream. Trigger, At, Progress, Retry and Run are only set for some types
+type EventDTO struct {
+	ID       uint64  `json:"ID"`
+	Type     string  `json:"Type"`
+	Job      string  `json:"Job"`
+	Time     string  `json:"Time"`
+	Trigger  string  `json:"Trigger,omitempty"`
+	At       string  `json:"At,omitempty"`
+	Progress float64 `json:"Progress,omitempty"`
+	Retry    int     `json:"Retry,omitempty"`
+	Run      *RunDTO `json:"Run,omitempty"`
+}
+
+//OperationDTO is an action that runs in the background
+type OperationDTO struct {
+	ID       string  `json:"ID"`
+	Action   string  `json:"Action"`
+	Job      string  `json:"Job,omitempty"`
+	Status   string  `json:"Status"`
+	Error    string  `json:"Error,omitempty"`
+	Started  string  `json:"Started"`
+	Finished string  `json:"Finished,omitempty"`
+	Run      *RunDTO `json:"Run,omitempty"`
+}
+
+//GraphNodeDTO is a job in the follow-up graph
+type GraphNodeDTO struct {
+	Name          string   `json:"Name"`
+	NextJobs      []string `json:"NextJobs"`
+	OnFailureJobs []string `json:"OnFailureJobs"`
+	OnPartialJobs []string `json:"OnPartialJobs"`
+	AfterAll      []string `json:"AfterAll"`
+	TriggeredBy   []string `json:"TriggeredBy"`
+}
+
+//MaintenanceDTO is the maintenance mode of the daemon
+type MaintenanceDTO struct {
+	Enabled bool `json:"Enabled"`
+}
+
+//AdHocRequestDTO is the body of an ad hoc run
+type AdHocRequestDTO struct {
+	Arguments []string `json:"Arguments"`
+	Replace   bool     `json:"Replace"`
+}
+
+//ErrorDTO is the body of every error response
+type ErrorDTO struct {
+	Error ErrorBodyDTO `json:"Error"`
+}
+
+//ErrorBodyDTO describes the error. Code is stable and meant for programs, Message for humans
+type ErrorBodyDTO struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func stringList(l []string) []string {
+	if l == nil {
+		return []string{}
+	}
+	return l
+}
+
+func newRunDTO(info *jobs.RunInfo) *RunDTO {
+	if info == nil {
+		return nil
+	}
+	return &RunDTO{
+		ID:         info.ID,
+		Result:     info.Result,
+		ExitCode:   info.ExitCode,
+		SnapshotID: info.SnapshotID,
+		Started:    formatTime(info.Started),
+		Finished:   formatTime(info.Finished),
+		AdHoc:      info.AdHoc,
+
+		BytesAdded:     info.BytesAdded,
+		BytesProcessed: info.BytesProcessed,
+		FilesNew:       info.FilesNew,
+		FilesChanged:   info.FilesChanged,
+	}
+}
+
+func newJobDTO(job *jobs.Job) JobDTO {
+	snapshot := job.Snapshot()
+	dto := JobDTO{
+		Name:             job.JobName,
+		Status:           string(snapshot.Status),
+		Paused:           snapshot.Paused,
+		SkipNext:         snapshot.SkipNext,
+		Progress:         snapshot.Progress,
+		Retries:          snapshot.CurrentRetry,
+		MaxFailedRetries: job.MaxFailedRetries,
+		Repository:       job.RepositoryName,
+		SourceFile:       job.SourceFile(),
+		NextJobs:         stringList(job.JobNameToTrigger),
+		OnFailureJobs:    stringList(job.OnFailureJobs),
+		OnPartialJobs:    stringList(job.OnPartialJobs),
+		AfterAll:         stringList(job.AfterAll),
+		LastRun:          newRunDTO(snapshot.LastRun),
+		History:          make([]RunDTO, 0, len(snapshot.History)),
+	}
+	//WaitEnd is nanoseconds since the epoch, only meaningful while it lies ahead
+	if next := time.Unix(0, int64(snapshot.WaitEnd)); snapshot.WaitEnd > 0 && next.After(time.Now()) {
+		dto.NextRun = formatTime(next)
+	}
+	for _, info := range snapshot.History {
+		dto.History = append(dto.History, *newRunDTO(info))
+	}
+	if staleness := job.Staleness(); staleness.MaxAge > 0 {
+		dto.MaxAge = job.MaxAge
+		dto.Stale = staleness.Stale
+		dto.StaleSince = formatTime(staleness.Since)
+		dto.NewestBackup = formatTime(staleness.Newest)
+		dto.StaleError = staleness.Error
+	}
+	return dto
+}
+
+func newOutputDTO(out jobs.JobOutput) OutputDTO {
+	return OutputDTO{RunID: out.RunID, Running: out.Running, Started: formatTime(out.Started), Lines: stringList(out.Lines)}
+}
+
+func newEventDTO(event jobs.Event) EventDTO {
+	return EventDTO{
+		ID:       event.ID,
+		Type:     event.Type,
+		Job:      event.Job,
+		Time:     formatTime(event.Time),
+		Trigger:  event.Trigger,
+		At:       formatTime(event.At),
+		Progress: event.Progress,
+		Retry:    event.Retry,
+		Run:      newRunDTO(event.Run),
+	}
+}
+
+func newOperationDTO(op jobs.Operation) OperationDTO {
+	return OperationDTO{
+		ID:       op.ID,
+		Action:   op.Action,
+		Job:      op.Job,
+		Status:   op.Status,
+		Error:    op.Error,
+		Started:  formatTime(op.Started),
+		Finished: formatTime(op.Finished),
+		Run:      newRunDTO(op.Run),
+	}
+}
+
+func newGraphDTO(nodes []jobs.GraphNode) []GraphNodeDTO {
+	dtos := make([]GraphNodeDTO, 0, len(nodes))
+	for _, node := range nodes {
+		dtos = append(dtos, GraphNodeDTO{
+			Name:          node.Name,
+			NextJobs:      stringList(node.NextJobs),
+			OnFailureJobs: stringList(node.OnFailureJobs),
+			OnPartialJobs: stringList(node.OnPartialJobs),
+			AfterAll:      stringList(node.AfterAll),
+			TriggeredBy:   stringList(node.TriggeredBy),
+		})
+	}
+	return dtos
+}
@@ -0,0 +1,104 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//keepAliveInterval is how often a comment is sent on an idle event stream, so proxies do not close it
+const keepAliveInterval = 15 * time.Second
+
+//eventFilter selects the events a client asked for with ?job= and ?type=, both can be given more than once
+type eventFilter struct {
+	jobs  map[string]bool
+	types map[string]bool
+}
+
+func newEventFilter(r *http.Request) eventFilter {
+	filter := eventFilter{jobs: make(map[string]bool), types: make(map[string]bool)}
+	for _, name := range r.URL.Query()["job"] {
+		filter.jobs[name] = true
+	}
+	for _, eventType := range r.URL.Query()["type"] {
+		filter.types[eventType] = true
+	}
+	return filter
+}
+
+func (filter eventFilter) matches(event jobs.Event) bool {
+	return (len(filter.jobs) == 0 || filter.jobs[event.Job]) && (len(filter.types) == 0 || filter.types[event.Type])
+}
+
+//lastEventID is sent by EventSource when it reconnects. Clients that can not set headers may use ?lastEventId=
+func lastEventID(r *http.Request) uint64 {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+	id, _ := strconv.ParseUint(value, 10, 64)
+	return id
+}
+
+func writeEvent(wr http.ResponseWriter, event jobs.Event) error {
+	data, err := json.Marshal(newEventDTO(event))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(wr, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+//serveEvents streams the events of the jobs as server-sent events. It first sends the events the client missed
+//since the Last-Event-ID, as far as they are still kept
+func serveEvents(queue *jobs.JobQueue) http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		flusher, ok := wr.(http.Flusher)
+		if !ok {
+			http.Error(wr, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		filter := newEventFilter(r)
+		missed, events, cancel := queue.Events().Subscribe(lastEventID(r))
+		defer cancel()
+
+		wr.Header().Set("Content-Type", "text/event-stream")
+		wr.Header().Set("Cache-Control", "no-cache")
+		wr.WriteHeader(http.StatusOK)
+		for _, event := range missed {
+			if filter.matches(event) {
+				writeEvent(wr, event)
+			}
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					//the client lagged behind and was dropped, it reconnects with the last id it got
+					return
+				}
+				if !filter.matches(event) {
+					continue
+				}
+				if writeEvent(wr, event) != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(wr, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//readEvent reads the next event from the stream, comments are skipped
+func readEvent(t *testing.T, reader *bufio.Reader) (id, eventType string, event EventDTO) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && eventType != "":
+			return id, eventType, event
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-events")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	queue, err := jobs.NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.Publish(jobs.Event{Type: jobs.EventJobReloaded, Job: "a"})
+	queue.Publish(jobs.Event{Type: jobs.EventJobReloaded, Job: "b"})
+	queue.Publish(jobs.Event{Type: jobs.EventJobRemoved, Job: "b"})
+
+	server := httptest.NewServer(newMux(queue))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/events?job=b", nil)
+	req.Header.Set("Last-Event-ID", "2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Error("Wrong content type " + resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	id, eventType, event := readEvent(t, reader)
+	if id != "3" || eventType != jobs.EventJobRemoved || event.Job != "b" {
+		t.Errorf("Missed event not resumed: %s %s", id, eventType)
+	}
+
+	queue.Publish(jobs.Event{Type: jobs.EventJobStopped, Job: "a"})
+	queue.Publish(jobs.Event{Type: jobs.EventJobStopped, Job: "b"})
+	id, eventType, event = readEvent(t, reader)
+	if id != "5" || eventType != jobs.EventJobStopped || event.Job != "b" || event.Time == "" {
+		t.Errorf("Event of another job sent: %s %s %s", id, eventType, event.Job)
+	}
+
+	resp, err = http.Get(server.URL + apiPrefix + "/events?type=job_removed")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+	_, eventType, _ = readEvent(t, bufio.NewReader(resp.Body))
+	if eventType != jobs.EventJobRemoved {
+		t.Error("Type filter ignored: " + eventType)
+	}
+}
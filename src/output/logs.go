@@ -0,0 +1,92 @@
+package output
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+func (api *apiServer) listRuns(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	job, _ := api.queue.FindJob(params["name"])
+	if job == nil {
+		writeQueueError(wr, jobs.ErrNoSuchJob)
+		return
+	}
+	history := job.Snapshot().History
+	runs := make([]RunDTO, 0, len(history))
+	for _, info := range history {
+		runs = append(runs, *newRunDTO(info))
+	}
+	writeJSON(wr, http.StatusOK, runs)
+}
+
+//getRunLog serves the output of a run, the id can be "latest". With ?follow=1 the lines are streamed until the run ends.
+//Runs that are no longer in memory are served from their log file
+func (api *apiServer) getRunLog(wr http.ResponseWriter, r *http.Request, params map[string]string) {
+	job, _ := api.queue.FindJob(params["name"])
+	if job == nil {
+		writeQueueError(wr, jobs.ErrNoSuchJob)
+		return
+	}
+	var runLog *jobs.RunLog
+	file := ""
+	if params["id"] == "latest" {
+		runLog = job.LatestRunLog()
+	} else {
+		id, err := strconv.Atoi(params["id"])
+		if err != nil {
+			writeError(wr, http.StatusBadRequest, "bad_request", "The run id must be a number or latest")
+			return
+		}
+		runLog = job.RunLog(id)
+		file = job.LogFile(id)
+	}
+	follow := r.URL.Query().Get("follow") == "1" || r.URL.Query().Get("follow") == "true"
+
+	wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if runLog == nil && file == "" {
+		writeQueueError(wr, jobs.ErrNoSuchRun)
+		return
+	}
+	if runLog == nil {
+		http.ServeFile(wr, r, file)
+		return
+	}
+	if _, _, _, done, _ := runLog.Lines(0); done && !follow && runLog.Path() != "" {
+		//the file has all lines, memory only the last ones
+		http.ServeFile(wr, r, runLog.Path())
+		return
+	}
+	streamRunLog(wr, r, runLog, follow)
+}
+
+//streamRunLog writes the lines of the run kept in memory and with follow the ones that follow, until the run ends
+func streamRunLog(wr http.ResponseWriter, r *http.Request, runLog *jobs.RunLog, follow bool) {
+	flusher, _ := wr.(http.Flusher)
+	next := 0
+	for {
+		lines, n, skipped, done, changed := runLog.Lines(next)
+		if skipped > 0 {
+			fmt.Fprintf(wr, "[%d lines are only in the log file]\n", skipped)
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(wr, line); err != nil {
+				return
+			}
+		}
+		next = n
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done || !follow {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
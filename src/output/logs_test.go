@@ -0,0 +1,74 @@
+package output
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+func TestRunLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-logs")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	job := `{"JobName": "backup", "ResticPath": "/bin/sh", "ResticArguments": ["-c", "echo one; sleep 0.2; echo two"]}`
+	ioutil.WriteFile(path.Join(dir, "backup.json"), []byte(job), 0600)
+	queue, err := jobs.NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.StartQueue()
+	defer queue.StopAllJobs()
+	server := httptest.NewServer(newAPIServer(queue))
+	defer server.Close()
+	base := server.URL + apiPrefix + "/jobs/backup"
+
+	if resp := request(t, "GET", base+"/runs/latest/log", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Error("Log of a job that did not run")
+	}
+
+	_, events, cancel := queue.Events().Subscribe(0)
+	defer cancel()
+	time.Sleep(10 * time.Millisecond)
+	queue.TriggerJob("backup")
+	for event := range events {
+		if event.Type == jobs.EventRunStarted {
+			break
+		}
+	}
+
+	resp, err := http.Get(base + "/runs/latest/log?follow=1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(content) != "one\ntwo\n" {
+		t.Errorf("Run not followed to its end: %q", content)
+	}
+
+	var runs []RunDTO
+	request(t, "GET", base+"/runs", "", &runs)
+	if len(runs) != 1 || runs[0].ID != 1 {
+		t.Fatalf("Wrong runs: %v", runs)
+	}
+	resp, _ = http.Get(base + "/runs/1/log")
+	content, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(content) != "one\ntwo\n" {
+		t.Errorf("Wrong log of the finished run: %q", content)
+	}
+	if resp := request(t, "GET", base+"/runs/2/log", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Error("Log of an unknown run")
+	}
+	if resp := request(t, "GET", base+"/runs/first/log", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Error("Invalid run id accepted")
+	}
+}
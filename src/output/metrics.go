@@ -0,0 +1,175 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//metricsPrefix is the prefix of all metric names
+const metricsPrefix = "restic_cronned_"
+
+//results are the values of the result label, so the number of series stays bounded
+var results = []string{jobs.ResultSuccess, jobs.ResultPartial, jobs.ResultFailure, jobs.ResultCancelled}
+
+//metricsWriter writes the prometheus text format. Only the job name and fixed sets of values are used as labels
+type metricsWriter struct {
+	wr io.Writer
+}
+
+func (mw metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(mw.wr, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+//sample writes one value. labels are pairs of name and value
+func (mw metricsWriter) sample(name string, value float64, labels ...string) {
+	pairs := make([]string, 0, len(labels)/2)
+	for idx := 0; idx+1 < len(labels); idx += 2 {
+		pairs = append(pairs, labels[idx]+`="`+escapeLabel(labels[idx+1])+`"`)
+	}
+	labelText := ""
+	if len(pairs) > 0 {
+		labelText = "{" + strings.Join(pairs, ",") + "}"
+	}
+	fmt.Fprintf(mw.wr, "%s%s%s %s\n", metricsPrefix, name, labelText, formatValue(value))
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func timestamp(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//writeMetrics writes the metrics of all jobs, grouped by metric as the format wants it
+func writeMetrics(wr io.Writer, queue *jobs.JobQueue) {
+	mw := metricsWriter{wr: wr}
+	list := queue.List()
+	sort.Slice(list, func(i, j int) bool { return list[i].JobName < list[j].JobName })
+	metrics := make([]jobs.JobMetrics, len(list))
+	snapshots := make([]jobs.JobSnapshot, len(list))
+	for idx, job := range list {
+		metrics[idx] = job.Metrics()
+		snapshots[idx] = job.Snapshot()
+	}
+
+	//perJob writes one value per job, jobs without a value (ok false) are left out
+	perJob := func(name, kind, help string, value func(idx int) (float64, bool)) {
+		mw.header(name, kind, help)
+		for idx, job := range list {
+			if v, ok := value(idx); ok {
+				mw.sample(name, v, "job", job.JobName)
+			}
+		}
+	}
+
+	mw.header("maintenance", "gauge", "1 if the daemon is in maintenance mode")
+	mw.sample("maintenance", boolValue(queue.InMaintenance()))
+
+	mw.header("job_status", "gauge", "1 for the status the job is in")
+	for idx, job := range list {
+		for _, status := range jobs.Statuses {
+			mw.sample("job_status", boolValue(snapshots[idx].Status == status), "job", job.JobName, "status", string(status))
+		}
+	}
+	perJob("job_paused", "gauge", "1 if the job is paused", func(idx int) (float64, bool) {
+		return boolValue(snapshots[idx].Paused), true
+	})
+	perJob("job_last_success_timestamp_seconds", "gauge", "Time of the last successful run", func(idx int) (float64, bool) {
+		return timestamp(metrics[idx].LastSuccess), !metrics[idx].LastSuccess.IsZero()
+	})
+	perJob("job_last_failure_timestamp_seconds", "gauge", "Time of the last failed run", func(idx int) (float64, bool) {
+		return timestamp(metrics[idx].LastFailure), !metrics[idx].LastFailure.IsZero()
+	})
+	perJob("job_next_run_timestamp_seconds", "gauge", "Time of the next scheduled run", func(idx int) (float64, bool) {
+		next := time.Unix(0, int64(snapshots[idx].WaitEnd))
+		return timestamp(next), snapshots[idx].WaitEnd > 0 && next.After(time.Now())
+	})
+	perJob("job_retries", "gauge", "Retries of the current failure", func(idx int) (float64, bool) {
+		return float64(snapshots[idx].CurrentRetry), true
+	})
+	perJob("job_consecutive_failures", "gauge", "Failed runs since the last success", func(idx int) (float64, bool) {
+		return float64(metrics[idx].ConsecutiveFailures), true
+	})
+	staleness := make([]jobs.Staleness, len(list))
+	for idx, job := range list {
+		staleness[idx] = job.Staleness()
+	}
+	perJob("job_stale", "gauge", "1 if the newest backup is older than the MaxAge of the job", func(idx int) (float64, bool) {
+		return boolValue(staleness[idx].Stale), staleness[idx].MaxAge > 0
+	})
+	perJob("job_max_age_seconds", "gauge", "The MaxAge of the job", func(idx int) (float64, bool) {
+		return staleness[idx].MaxAge.Seconds(), staleness[idx].MaxAge > 0
+	})
+	perJob("job_newest_backup_timestamp_seconds", "gauge", "Time of the newest backup, the newest snapshot or the last success", func(idx int) (float64, bool) {
+		return timestamp(staleness[idx].Newest), !staleness[idx].Newest.IsZero()
+	})
+	perJob("job_precondition_failures_total", "counter", "Runs skipped because the preconditions were not met", func(idx int) (float64, bool) {
+		return float64(metrics[idx].PreconditionFailures), true
+	})
+
+	mw.header("job_runs_total", "counter", "Finished scheduled runs by result")
+	for idx, job := range list {
+		for _, result := range results {
+			mw.sample("job_runs_total", float64(metrics[idx].Runs[result]), "job", job.JobName, "result", result)
+		}
+	}
+
+	mw.header("job_run_duration_seconds", "histogram", "Duration of the scheduled runs")
+	for idx, job := range list {
+		for bucket, bound := range jobs.RunDurationBuckets {
+			mw.sample("job_run_duration_seconds_bucket", float64(metrics[idx].DurationBuckets[bucket]), "job", job.JobName, "le", formatValue(bound))
+		}
+		mw.sample("job_run_duration_seconds_bucket", float64(metrics[idx].DurationCount), "job", job.JobName, "le", "+Inf")
+		mw.sample("job_run_duration_seconds_sum", metrics[idx].DurationSum, "job", job.JobName)
+		mw.sample("job_run_duration_seconds_count", float64(metrics[idx].DurationCount), "job", job.JobName)
+	}
+
+	//the summary of restic is only there with --json
+	summary := func(name, help string, field func(info *jobs.RunInfo) uint64) {
+		perJob(name, "gauge", help, func(idx int) (float64, bool) {
+			run := metrics[idx].LastRun
+			if run == nil {
+				return 0, false
+			}
+			return float64(field(run)), true
+		})
+	}
+	summary("job_last_run_bytes_added", "Bytes the last run added to the repository", func(info *jobs.RunInfo) uint64 { return info.BytesAdded })
+	summary("job_last_run_bytes_processed", "Bytes the last run processed", func(info *jobs.RunInfo) uint64 { return info.BytesProcessed })
+	summary("job_last_run_files_new", "New files of the last run", func(info *jobs.RunInfo) uint64 { return info.FilesNew })
+	summary("job_last_run_files_changed", "Changed files of the last run", func(info *jobs.RunInfo) uint64 { return info.FilesChanged })
+}
+
+func serveMetrics(queue *jobs.JobQueue) http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		wr.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(wr, queue)
+	}
+}
+
+//StartMetricsServer blockingly serves only the metrics on the address, without authentication.
+//The metrics are also served on the main server
+func StartMetricsServer(queue *jobs.JobQueue, address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics(queue))
+	return http.ListenAndServe(address, mux)
+}
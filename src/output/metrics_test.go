@@ -0,0 +1,65 @@
+package output
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-metrics")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	job := `{"JobName": "back\"up", "ResticPath": "/bin/false", "regularTimer": "@every 1h"}`
+	ioutil.WriteFile(path.Join(dir, "backup.json"), []byte(job), 0600)
+	queue, err := jobs.NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	queue.StartQueue()
+	defer queue.StopAllJobs()
+	time.Sleep(10 * time.Millisecond)
+	queue.TriggerJob(`back"up`)
+	time.Sleep(50 * time.Millisecond)
+
+	var out bytes.Buffer
+	writeMetrics(&out, queue)
+	text := out.String()
+
+	expected := []string{
+		`restic_cronned_job_status{job="back\"up",status="waiting"} 1`,
+		`restic_cronned_job_runs_total{job="back\"up",result="failure"} 1`,
+		`restic_cronned_job_consecutive_failures{job="back\"up"} 1`,
+		`restic_cronned_job_run_duration_seconds_bucket{job="back\"up",le="+Inf"} 1`,
+		`restic_cronned_job_run_duration_seconds_count{job="back\"up"} 1`,
+		`restic_cronned_job_last_run_bytes_added{job="back\"up"} 0`,
+		"# TYPE restic_cronned_job_run_duration_seconds histogram",
+	}
+	for _, line := range expected {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Missing %s", line)
+		}
+	}
+	if !strings.Contains(text, "restic_cronned_job_next_run_timestamp_seconds{") {
+		t.Error("Next run missing")
+	}
+	if strings.Contains(text, "job_last_success_timestamp_seconds{") {
+		t.Error("Success of a job that never succeeded")
+	}
+
+	sample := regexp.MustCompile(`^restic_cronned_[a-z_]+(\{[a-z]+="([^"\\]|\\.)*"(,[a-z]+="([^"\\]|\\.)*")*\})? [-+0-9.eInf]+$`)
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if !strings.HasPrefix(line, "# ") && !sample.MatchString(line) {
+			t.Errorf("Invalid line %q", line)
+		}
+	}
+}
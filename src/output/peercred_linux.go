@@ -0,0 +1,31 @@
+package output
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+//peerCredentials reads the uid and gid of the process connected to the unix socket with SO_PEERCRED
+func peerCredentials(c net.Conn) (peer, error) {
+	unixConn, ok := c.(*net.UnixConn)
+	if !ok {
+		return peer{}, errors.New("not a unix socket")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return peer{}, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return peer{}, err
+	}
+	if credErr != nil {
+		return peer{}, credErr
+	}
+	return peer{uid: int(cred.Uid), gid: int(cred.Gid)}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package output
+
+import (
+	"errors"
+	"net"
+)
+
+//peerCredentials is only implemented on linux, elsewhere all requests on the socket are denied
+func peerCredentials(c net.Conn) (peer, error) {
+	return peer{}, errors.New("peer credentials are only supported on linux")
+}
@@ -1,61 +1,245 @@
 package output
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 
+	log "github.com/Sirupsen/logrus"
+
 	"github.com/killingspark/restic-cronned/src/jobs"
 )
 
-//StartServer blockingly starts the server that serves information abut the queue
-func StartServer(queue *jobs.JobQueue, port string) {
-	http.HandleFunc("/queue", func(wr http.ResponseWriter, r *http.Request) {
+//ServerConfig configures the server. Without Credentials no authentication is needed
+type ServerConfig struct {
+	Address     string
+	Credentials []Credential
+	TLS         TLSConfig
+}
+
+//TLSConfig enables https if Cert and Key are set. With ClientCA only clients with a certificate signed by it are accepted
+type TLSConfig struct {
+	Cert     string
+	Key      string
+	ClientCA string
+}
+
+//StartServer blockingly starts the server that serves information abut the queue.
+//The versioned json api is served under /api/v1, the endpoints below are kept for older clients
+func StartServer(queue *jobs.JobQueue, config ServerConfig) error {
+	auth, err := newAuthenticator(config.Credentials)
+	if err != nil {
+		return err
+	}
+	if len(auth.credentials) == 0 && !isLocalAddress(config.Address) {
+		log.WithFields(log.Fields{"Address": config.Address}).Warning("The server is reachable from other hosts without authentication")
+	}
+
+	server := &http.Server{Addr: config.Address, Handler: auth.wrap(newMux(queue))}
+	if config.TLS.Cert == "" && config.TLS.Key == "" {
+		return server.ListenAndServe()
+	}
+	if config.TLS.ClientCA != "" {
+		pem, err := ioutil.ReadFile(config.TLS.ClientCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no certificates found in " + config.TLS.ClientCA)
+		}
+		server.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+	return server.ListenAndServeTLS(config.TLS.Cert, config.TLS.Key)
+}
+
+//newMux sets up all endpoints, the same for tcp and the unix socket
+func newMux(queue *jobs.JobQueue) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(apiPrefix+"/", newAPIServer(queue))
+	mux.Handle("/", newDashboardHandler())
+	mux.HandleFunc("/events", serveEvents(queue))
+	mux.HandleFunc("/metrics", serveMetrics(queue))
+	mux.HandleFunc("/queue", func(wr http.ResponseWriter, r *http.Request) {
 		encodeQueue(queue, wr)
 	})
-	http.HandleFunc("/stop", func(wr http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/stop", legacyAction("POST", "/jobs/{name}/stop", func(wr http.ResponseWriter, r *http.Request) {
 		err := queue.StopJob(r.URL.Query().Get("name"))
 		if err != nil {
 			wr.Write([]byte(err.Error()))
 		} else {
 			wr.Write([]byte("Done"))
 		}
-	})
-	http.HandleFunc("/restart", func(wr http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("/restart", legacyAction("POST", "/jobs/{name}/restart", func(wr http.ResponseWriter, r *http.Request) {
 		err := queue.RestartJob(r.URL.Query().Get("name"))
 		if err != nil {
 			wr.Write([]byte(err.Error()))
+		} else {
+			wr.Write([]byte("Done"))
 		}
-	})
-	http.HandleFunc("/reload", func(wr http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("/reload", legacyAction("POST", "/jobs/{name}/reload", func(wr http.ResponseWriter, r *http.Request) {
 		err := queue.ReloadJob(r.URL.Query().Get("name"))
 		if err != nil {
 			wr.Write([]byte(err.Error()))
 		} else {
 			wr.Write([]byte("Done"))
 		}
-	})
-	http.HandleFunc("/trigger", func(wr http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("/trigger", legacyAction("POST", "/jobs/{name}/trigger", func(wr http.ResponseWriter, r *http.Request) {
 		err := queue.TriggerJob(r.URL.Query().Get("name"))
 		if err != nil {
 			wr.Write([]byte(err.Error()))
 		} else {
 			wr.Write([]byte("Done"))
 		}
-	})
-	http.HandleFunc("/remove", func(wr http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("/remove", legacyAction("DELETE", "/jobs/{name}", func(wr http.ResponseWriter, r *http.Request) {
 		err := queue.RemoveJob(r.URL.Query().Get("name"))
 		if err != nil {
 			wr.Write([]byte(err.Error()))
 		} else {
 			wr.Write([]byte("Done"))
 		}
+	}))
+	mux.HandleFunc("/pause", legacyAction("POST", "/jobs/{name}/pause", func(wr http.ResponseWriter, r *http.Request) {
+		err := queue.PauseJob(r.URL.Query().Get("name"))
+		if err != nil {
+			wr.Write([]byte(err.Error()))
+		} else {
+			wr.Write([]byte("Done"))
+		}
+	}))
+	mux.HandleFunc("/resume", legacyAction("POST", "/jobs/{name}/resume", func(wr http.ResponseWriter, r *http.Request) {
+		err := queue.ResumeJob(r.URL.Query().Get("name"))
+		if err != nil {
+			wr.Write([]byte(err.Error()))
+		} else {
+			wr.Write([]byte("Done"))
+		}
+	}))
+	mux.HandleFunc("/skipnext", legacyAction("POST", "/jobs/{name}/skip-next", func(wr http.ResponseWriter, r *http.Request) {
+		err := queue.SkipNextJob(r.URL.Query().Get("name"))
+		if err != nil {
+			wr.Write([]byte(err.Error()))
+		} else {
+			wr.Write([]byte("Done"))
+		}
+	}))
+	mux.HandleFunc("/maintenance", func(wr http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("state") != "" && !legacyPost(wr, r, "PUT", "/maintenance") {
+			return
+		}
+		switch r.URL.Query().Get("state") {
+		case "on":
+			queue.SetMaintenance(true)
+		case "off":
+			queue.SetMaintenance(false)
+		case "":
+		default:
+			wr.Write([]byte("state must be on or off"))
+			return
+		}
+		if queue.InMaintenance() {
+			wr.Write([]byte("on"))
+		} else {
+			wr.Write([]byte("off"))
+		}
+	})
+	mux.HandleFunc("/cancel", legacyAction("POST", "/jobs/{name}/cancel", func(wr http.ResponseWriter, r *http.Request) {
+		op, err := queue.CancelJob(r.URL.Query().Get("name"))
+		if err != nil {
+			wr.Write([]byte(err.Error()))
+			return
+		}
+		json.NewEncoder(wr).Encode(op)
+	}))
+	mux.HandleFunc("/adhoc", legacyAction("POST", "/jobs/{name}/adhoc", func(wr http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get("name")
+		adHoc := &jobs.AdHocRun{Arguments: query["arg"], Replace: query.Get("replace") == "true"}
+		if query.Get("wait") != "true" {
+			op, err := queue.StartAdHoc(name, adHoc)
+			if err != nil {
+				wr.Write([]byte(err.Error()))
+				return
+			}
+			json.NewEncoder(wr).Encode(op)
+			return
+		}
+		//stream the output of restic while it runs
+		adHoc.Output = &flushWriter{wr: wr}
+		info, err := queue.RunAdHoc(name, adHoc)
+		if err != nil {
+			wr.Write([]byte(err.Error()))
+			return
+		}
+		fmt.Fprintf(wr, "Result: %s (exit code %d)\n", info.Result, info.ExitCode)
+	}))
+	mux.HandleFunc("/operation", func(wr http.ResponseWriter, r *http.Request) {
+		op, err := queue.Operation(r.URL.Query().Get("id"))
+		if err != nil {
+			wr.Write([]byte(err.Error()))
+			return
+		}
+		json.NewEncoder(wr).Encode(op)
+	})
+	mux.HandleFunc("/graph", func(wr http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(wr).Encode(queue.Graph())
 	})
-	http.HandleFunc("/stopall", func(wr http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/definition", func(wr http.ResponseWriter, r *http.Request) {
+		job, _ := queue.FindJob(r.URL.Query().Get("name"))
+		if job == nil {
+			wr.Write([]byte("No such Job"))
+			return
+		}
+		json.NewEncoder(wr).Encode(job.Definition())
+	})
+	mux.HandleFunc("/stopall", legacyAction("POST", "/stop-all", func(wr http.ResponseWriter, r *http.Request) {
 		queue.StopAllJobs()
 		wr.Write([]byte("Done"))
-	})
-	http.ListenAndServe(port, nil)
+	}))
+	return mux
+}
+
+//legacyAction guards an old endpoint that changes something. They are deprecated in favour of the route of the api
+//and act only on POST, a link or an image in some page must not stop jobs
+func legacyAction(method, successor string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		if legacyPost(wr, r, method, successor) {
+			handler(wr, r)
+		}
+	}
+}
+
+//legacyPost marks the answer as deprecated and refuses everything but POST, pointing to the route of the api
+func legacyPost(wr http.ResponseWriter, r *http.Request, method, successor string) bool {
+	wr.Header().Set("Deprecation", "true")
+	if r.Method == http.MethodPost {
+		return true
+	}
+	wr.Header().Set("Allow", http.MethodPost)
+	http.Error(wr, "Use "+method+" "+apiPrefix+successor+" instead", http.StatusMethodNotAllowed)
+	return false
+}
+
+//flushWriter sends everything written to it to the client immediately
+type flushWriter struct {
+	wr http.ResponseWriter
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.wr.Write(p)
+	if flusher, ok := fw.wr.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
 }
 
 func encodeQueue(queue *jobs.JobQueue, wr io.Writer) error {
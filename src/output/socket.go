@@ -0,0 +1,252 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+//SocketConfig configures the unix socket. Requests on it are authorized by the uid and gid of the connected process.
+//The user running the daemon and root are always admins
+type SocketConfig struct {
+	Path string
+	//the socket is owned by this group and can be used by its members (mode 0660), otherwise only by the owner (mode 0600)
+	Group string
+	//users and groups (prefixed with @) that get the roles
+	Admins  []string
+	Readers []string
+}
+
+//DefaultSocketPath is where rccommands looks for the socket if it is not told otherwise, the recommended Socket.Path.
+//It is empty if there is no XDG_RUNTIME_DIR
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "restic-cronned.sock")
+	}
+	return ""
+}
+
+//peer is the process on the other end of a unix socket connection
+type peer struct {
+	uid int
+	gid int
+}
+
+type peerKey struct{}
+
+//peerAuthorizer maps the peers of the socket to roles
+type peerAuthorizer struct {
+	admins  principals
+	readers principals
+}
+
+//principals are user and group ids
+type principals struct {
+	uids map[int]bool
+	gids map[int]bool
+}
+
+func newPrincipals(names []string) (principals, error) {
+	p := principals{uids: make(map[int]bool), gids: make(map[int]bool)}
+	for _, name := range names {
+		if strings.HasPrefix(name, "@") {
+			group, err := user.LookupGroup(strings.TrimPrefix(name, "@"))
+			if err != nil {
+				return p, err
+			}
+			gid, _ := strconv.Atoi(group.Gid)
+			p.gids[gid] = true
+			continue
+		}
+		u, err := user.Lookup(name)
+		if err != nil {
+			return p, err
+		}
+		uid, _ := strconv.Atoi(u.Uid)
+		p.uids[uid] = true
+	}
+	return p, nil
+}
+
+//contains checks the uid, the primary gid and the supplementary groups of the user
+func (p principals) contains(pr peer) bool {
+	if p.uids[pr.uid] || p.gids[pr.gid] {
+		return true
+	}
+	if len(p.gids) == 0 {
+		return false
+	}
+	u, err := user.LookupId(strconv.Itoa(pr.uid))
+	if err != nil {
+		return false
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, g := range groups {
+		if gid, err := strconv.Atoi(g); err == nil && p.gids[gid] {
+			return true
+		}
+	}
+	return false
+}
+
+func (auth *peerAuthorizer) role(pr peer) string {
+	if pr.uid == 0 || pr.uid == os.Getuid() || auth.admins.contains(pr) {
+		return RoleAdmin
+	}
+	if auth.readers.contains(pr) {
+		return RoleRead
+	}
+	return ""
+}
+
+func (auth *peerAuthorizer) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		pr, ok := r.Context().Value(peerKey{}).(peer)
+		if !ok {
+			denied(wr, r, http.StatusForbidden, "forbidden", "Could not identify the peer")
+			return
+		}
+		serveWithRole(wr, r, next, auth.role(pr), fmt.Sprintf("uid %d", pr.uid))
+	})
+}
+
+//listenSocket creates the socket with the permissions of the config. A stale socket from an earlier run is removed,
+//one a daemon still listens on is not
+func listenSocket(config SocketConfig) (net.Listener, error) {
+	mode := os.FileMode(0600)
+	gid := -1
+	if config.Group != "" {
+		group, err := user.LookupGroup(config.Group)
+		if err != nil {
+			return nil, err
+		}
+		gid, _ = strconv.Atoi(group.Gid)
+		mode = 0660
+	}
+	dir := filepath.Dir(config.Path)
+	if err := makeSocketDir(dir, gid); err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(config.Path); err != nil {
+		return nil, err
+	}
+	//the socket is created under the umask, so it gets its permissions in a directory only the daemon can enter
+	//and is moved into place afterwards. Nobody can connect in between
+	private, err := ioutil.TempDir(dir, ".restic-cronned")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(private)
+	tmpPath := filepath.Join(private, "socket")
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	//the socket is renamed, the listener must not remove anything when it is closed
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if gid >= 0 {
+		err = os.Chown(tmpPath, -1, gid)
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, config.Path)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+//makeSocketDir creates the directory of the socket if it does not exist yet. With a group its members may enter it
+//to reach the socket, otherwise only the daemon. A directory that exists already is left as it is
+func makeSocketDir(dir string, gid int) error {
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if gid < 0 {
+		return nil
+	}
+	if err := os.Chown(dir, -1, gid); err != nil {
+		return err
+	}
+	//the umask may have taken the permissions of the group away
+	return os.Chmod(dir, 0750)
+}
+
+//removeStaleSocket removes the socket at path if nobody listens on it anymore
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is no socket", path)
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("another daemon listens on %s", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return os.Remove(path)
+}
+
+//StartSocketServer blockingly serves the same endpoints as StartServer on a unix socket
+func StartSocketServer(queue *jobs.JobQueue, config SocketConfig) error {
+	admins, err := newPrincipals(config.Admins)
+	if err != nil {
+		return err
+	}
+	readers, err := newPrincipals(config.Readers)
+	if err != nil {
+		return err
+	}
+	auth := &peerAuthorizer{admins: admins, readers: readers}
+
+	listener, err := listenSocket(config)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(config.Path)
+	log.WithFields(log.Fields{"Path": config.Path}).Info("Serving on unix socket")
+
+	server := &http.Server{
+		Handler: auth.wrap(newMux(queue)),
+		//the peer is read once per connection and handed to the requests in their context
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			pr, err := peerCredentials(c)
+			if err != nil {
+				log.WithFields(log.Fields{"Error": err.Error()}).Warning("Could not read peer credentials")
+				return ctx
+			}
+			return context.WithValue(ctx, peerKey{}, pr)
+		},
+	}
+	return server.Serve(listener)
+}
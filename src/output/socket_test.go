@@ -0,0 +1,123 @@
+package output
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/killingspark/restic-cronned/src/jobs"
+)
+
+func TestSocketServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-socket")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	queue, err := jobs.NewJobQueue(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	socket := path.Join(dir, "control.sock")
+	go StartSocketServer(queue, SocketConfig{Path: socket})
+	time.Sleep(50 * time.Millisecond)
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Socket has mode %o", info.Mode().Perm())
+	}
+
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial("unix", socket)
+	}}}
+	resp, err := client.Get("http://unix" + apiPrefix + "/maintenance")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Own user was not allowed: %d", resp.StatusCode)
+	}
+}
+
+func TestListenSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rc-socket")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "run", "control.sock")
+	listener, err := listenSocket(SocketConfig{Path: socket})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info, err := os.Stat(path.Dir(socket)); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Directory of the socket is not private: %v", info.Mode())
+	}
+	if entries, _ := ioutil.ReadDir(path.Dir(socket)); len(entries) != 1 {
+		t.Errorf("Temporary files left behind: %d entries", len(entries))
+	}
+
+	//a daemon listens on it
+	if _, err := listenSocket(SocketConfig{Path: socket}); err == nil {
+		t.Error("Took the socket away from a live daemon")
+	}
+	//the daemon crashed and left the socket behind
+	listener.Close()
+	if _, err := os.Stat(socket); err != nil {
+		t.Fatal("Socket removed on close")
+	}
+	listener, err = listenSocket(SocketConfig{Path: socket})
+	if err != nil {
+		t.Fatalf("Stale socket not replaced: %s", err.Error())
+	}
+	listener.Close()
+
+	//the members of the group must be able to enter the directory the daemon created
+	group, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	shared := path.Join(dir, "shared", "control.sock")
+	listener, err = listenSocket(SocketConfig{Path: shared, Group: group.Name})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	listener.Close()
+	info, err := os.Stat(path.Dir(shared))
+	if err != nil || info.Mode().Perm() != 0750 || int(info.Sys().(*syscall.Stat_t).Gid) != os.Getgid() {
+		t.Errorf("Directory of the socket is not open to the group: %v", info.Mode())
+	}
+
+	file := path.Join(dir, "file")
+	ioutil.WriteFile(file, nil, 0600)
+	if _, err := listenSocket(SocketConfig{Path: file}); err == nil {
+		t.Error("Replaced a file that is no socket")
+	}
+}
+
+func TestPeerRoles(t *testing.T) {
+	const stranger = 54321
+	auth := &peerAuthorizer{}
+	if auth.role(peer{uid: os.Getuid(), gid: os.Getgid()}) != RoleAdmin {
+		t.Error("The user of the daemon is no admin")
+	}
+	if auth.role(peer{uid: stranger, gid: stranger}) != "" {
+		t.Error("Unknown user got a role")
+	}
+	auth.readers = principals{uids: map[int]bool{}, gids: map[int]bool{stranger: true}}
+	if auth.role(peer{uid: stranger, gid: stranger}) != RoleRead {
+		t.Error("Member of a reader group is no reader")
+	}
+}
@@ -0,0 +1,294 @@
+//dashboard of restic-cronned. It only uses the versioned api and needs nothing from the internet
+"use strict";
+
+var api = "../api/v1";
+//the view is refreshed on every event of the jobs, the timer only catches what the event stream misses
+var refreshInterval = 10000;
+//the output of a running job has no events, it is polled
+var outputInterval = 2000;
+var running = false;
+var timer = null;
+var pending = null;
+var eventTypes = [
+  "trigger_scheduled", "trigger_received", "preconditions_checking", "preconditions_failed", "run_started",
+  "progress", "run_finished", "retry_scheduled", "job_failed", "job_stopped", "job_reloaded", "job_removed"
+];
+
+//el creates an element. Text is always set as text, so names and output can not inject html
+function el(tag, attrs, children) {
+  var node = document.createElement(tag);
+  Object.keys(attrs || {}).forEach(function (key) {
+    if (key === "onclick") {
+      node.onclick = attrs[key];
+    } else {
+      node.setAttribute(key, attrs[key]);
+    }
+  });
+  (children || []).forEach(function (child) {
+    if (child === null || child === undefined) {
+      return;
+    }
+    node.appendChild(typeof child === "string" ? document.createTextNode(child) : child);
+  });
+  return node;
+}
+
+function showMessage(text) {
+  var message = document.getElementById("message");
+  message.textContent = text;
+  message.hidden = !text;
+}
+
+function request(method, path, body) {
+  var options = { method: method, credentials: "same-origin", headers: {} };
+  if (body !== undefined) {
+    options.headers["Content-Type"] = "application/json";
+    options.body = JSON.stringify(body);
+  }
+  return fetch(api + path, options).then(function (resp) {
+    return resp.json().then(function (data) {
+      if (!resp.ok) {
+        throw new Error(data.Error ? data.Error.Message : resp.statusText);
+      }
+      return data;
+    });
+  });
+}
+
+function jobPath(name) {
+  return "/jobs/" + encodeURIComponent(name);
+}
+
+function formatTime(value) {
+  return value ? new Date(value).toLocaleString() : "-";
+}
+
+function action(name, verb) {
+  request("POST", jobPath(name) + "/" + verb).then(function () {
+    showMessage("");
+    render();
+  }).catch(function (err) {
+    showMessage(name + ": " + verb + " failed: " + err.message);
+  });
+}
+
+function actionButtons(job) {
+  var buttons = [
+    el("button", { onclick: function () { action(job.Name, "trigger"); } }, ["Trigger"]),
+    job.Paused ?
+      el("button", { onclick: function () { action(job.Name, "resume"); } }, ["Resume"]) :
+      el("button", { onclick: function () { action(job.Name, "pause"); } }, ["Pause"]),
+    job.Status === "stopped" ?
+      el("button", { onclick: function () { action(job.Name, "restart"); } }, ["Restart"]) :
+      el("button", { onclick: function () { action(job.Name, "stop"); } }, ["Stop"]),
+    el("button", { onclick: function () { action(job.Name, "reload"); } }, ["Reload"])
+  ];
+  if (job.Status === "working") {
+    buttons.push(el("button", { onclick: function () { action(job.Name, "cancel"); } }, ["Cancel run"]));
+  }
+  return el("span", {}, buttons);
+}
+
+function statusBadge(job) {
+  return el("span", {}, [
+    el("span", { "class": "status status-" + job.Status }, [job.Status]),
+    job.Paused ? el("span", { "class": "status paused" }, ["paused"]) : null,
+    job.SkipNext ? el("span", { "class": "status paused" }, ["skip next"]) : null,
+    job.Stale ? el("span", {
+      "class": "status stale",
+      "title": "No backup newer than " + job.MaxAge + (job.NewestBackup ? ", the newest is from " + formatTime(job.NewestBackup) : "")
+    }, ["stale"]) : null
+  ]);
+}
+
+function resultBadge(run) {
+  if (!run) {
+    return "-";
+  }
+  return el("span", { "class": "result result-" + run.Result }, [run.Result]);
+}
+
+function progressBar(job) {
+  if (job.Status !== "working") {
+    return "-";
+  }
+  var bar = el("div", {});
+  bar.style.width = Math.min(100, Math.max(0, job.Progress)) + "%";
+  return el("div", { "class": "progress", title: job.Progress.toFixed(1) + "%" }, [bar]);
+}
+
+function jobLink(name) {
+  return el("a", { href: "#/job/" + encodeURIComponent(name) }, [name]);
+}
+
+function jobTable(list) {
+  var rows = list.map(function (job) {
+    return el("tr", {}, [
+      el("td", {}, [jobLink(job.Name)]),
+      el("td", {}, [statusBadge(job)]),
+      el("td", {}, [formatTime(job.NextRun)]),
+      el("td", {}, [resultBadge(job.LastRun), job.LastRun ? " " + formatTime(job.LastRun.Finished) : null]),
+      el("td", {}, [progressBar(job)]),
+      el("td", {}, [actionButtons(job)])
+    ]);
+  });
+  return el("table", {}, [
+    el("tr", {}, ["Job", "Status", "Next run", "Last result", "Progress", "Actions"].map(function (title) {
+      return el("th", {}, [title]);
+    }))
+  ].concat(rows));
+}
+
+//chain renders the follow ups of the node and their follow ups. seen breaks cycles
+function chain(node, nodes, seen) {
+  var edges = [];
+  [["NextJobs", "on success"], ["OnPartialJobs", "on partial"], ["OnFailureJobs", "on failure"]].forEach(function (kind) {
+    node[kind[0]].forEach(function (name) {
+      edges.push({ name: name, label: kind[1] });
+    });
+  });
+  if (edges.length === 0) {
+    return null;
+  }
+  return el("ul", {}, edges.map(function (edge) {
+    var next = nodes[edge.name];
+    var item = el("li", {}, [el("span", { "class": "edge" }, [edge.label + ": "]), jobLink(edge.name)]);
+    if (next && next.AfterAll.length > 0) {
+      item.appendChild(el("span", { "class": "edge" }, [" after all of " + next.AfterAll.join(", ")]));
+    }
+    if (next && !seen[edge.name]) {
+      seen[edge.name] = true;
+      item.appendChild(chain(next, nodes, seen) || document.createTextNode(""));
+    }
+    return item;
+  }));
+}
+
+function chains(graph) {
+  var nodes = {};
+  graph.forEach(function (node) {
+    nodes[node.Name] = node;
+  });
+  var roots = graph.filter(function (node) {
+    return node.TriggeredBy.length === 0 && (node.NextJobs.length + node.OnPartialJobs.length + node.OnFailureJobs.length) > 0;
+  });
+  if (roots.length === 0) {
+    return el("p", {}, ["No follow up jobs are defined."]);
+  }
+  return el("ul", { "class": "chains" }, roots.map(function (root) {
+    var seen = {};
+    seen[root.Name] = true;
+    return el("li", {}, [jobLink(root.Name), chain(root, nodes, seen)]);
+  }));
+}
+
+function renderList(view) {
+  return Promise.all([request("GET", "/jobs"), request("GET", "/graph")]).then(function (results) {
+    view.replaceChildren(
+      el("h2", {}, ["Jobs"]),
+      jobTable(results[0]),
+      el("h2", {}, ["Follow up chains"]),
+      chains(results[1])
+    );
+  });
+}
+
+function historyTable(job) {
+  var rows = job.History.slice().reverse().map(function (run) {
+    return el("tr", {}, [
+      el("td", {}, [el("a", { href: api + jobPath(job.Name) + "/runs/" + run.ID + "/log", target: "_blank" }, [String(run.ID)])]),
+      el("td", {}, [formatTime(run.Started)]),
+      el("td", {}, [formatTime(run.Finished)]),
+      el("td", {}, [resultBadge(run), run.AdHoc ? " (ad hoc)" : null]),
+      el("td", {}, [String(run.ExitCode)]),
+      el("td", {}, [run.SnapshotID || "-"])
+    ]);
+  });
+  if (rows.length === 0) {
+    return el("p", {}, ["The job did not run yet."]);
+  }
+  return el("table", {}, [
+    el("tr", {}, ["Run (log)", "Started", "Finished", "Result", "Exit code", "Snapshot"].map(function (title) {
+      return el("th", {}, [title]);
+    }))
+  ].concat(rows));
+}
+
+function renderJob(view, name) {
+  return Promise.all([request("GET", jobPath(name)), request("GET", jobPath(name) + "/output")]).then(function (results) {
+    var job = results[0];
+    var output = results[1];
+    var pre = el("pre", { "class": "output" }, [output.Lines.join("\n") || "No output."]);
+    var title = (output.Running ? "Live output of run " : "Output of the last run ") + (output.RunID || "");
+    running = output.Running;
+    view.replaceChildren(
+      el("p", {}, [el("a", { href: "#/" }, ["All jobs"])]),
+      el("h2", {}, [job.Name, " ", statusBadge(job)]),
+      el("p", {}, [actionButtons(job)]),
+      el("table", {}, [
+        el("tr", {}, [el("th", {}, ["Next run"]), el("td", {}, [formatTime(job.NextRun)])]),
+        el("tr", {}, [el("th", {}, ["Progress"]), el("td", {}, [progressBar(job)])]),
+        el("tr", {}, [el("th", {}, ["Retries"]), el("td", {}, [job.Retries + " of " + job.MaxFailedRetries])]),
+        el("tr", {}, [el("th", {}, ["Repository"]), el("td", {}, [job.Repository || "-"])]),
+        el("tr", {}, [el("th", {}, ["Definition"]), el("td", {}, [job.SourceFile || "-"])]),
+        el("tr", {}, [el("th", {}, ["Follow ups"]), el("td", {}, [job.NextJobs.concat(job.OnPartialJobs, job.OnFailureJobs).join(", ") || "-"])])
+      ]),
+      el("h3", {}, ["History"]),
+      historyTable(job),
+      el("h3", {}, [title, output.Started ? " (started " + formatTime(output.Started) + ")" : null]),
+      pre
+    );
+    pre.scrollTop = pre.scrollHeight;
+  });
+}
+
+function renderMaintenance() {
+  return request("GET", "/maintenance").then(function (state) {
+    document.getElementById("maintenance").checked = state.Enabled;
+  });
+}
+
+function render() {
+  clearTimeout(timer);
+  var view = document.getElementById("view");
+  var match = location.hash.match(/^#\/job\/(.+)$/);
+  running = false;
+  var done = match ? renderJob(view, decodeURIComponent(match[1])) : renderList(view);
+  Promise.all([done, renderMaintenance()]).catch(function (err) {
+    showMessage(err.message);
+  }).then(function () {
+    timer = setTimeout(render, running ? outputInterval : refreshInterval);
+  });
+}
+
+document.getElementById("maintenance").onchange = function (event) {
+  request("PUT", "/maintenance", { Enabled: event.target.checked }).then(function () {
+    showMessage("");
+    render();
+  }).catch(function (err) {
+    showMessage("Maintenance mode could not be changed: " + err.message);
+    render();
+  });
+};
+
+//listen refreshes the view shortly after events, many events in a row cause only one refresh
+function listen() {
+  if (!window.EventSource) {
+    return;
+  }
+  var source = new EventSource("../events");
+  eventTypes.forEach(function (type) {
+    source.addEventListener(type, function () {
+      if (pending === null) {
+        pending = setTimeout(function () {
+          pending = null;
+          render();
+        }, 500);
+      }
+    });
+  });
+}
+
+window.onhashchange = render;
+render();
+listen();
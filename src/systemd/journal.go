@@ -0,0 +1,127 @@
+package systemd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//JournalSocket is where journald receives entries in its native protocol
+var JournalSocket = "/run/systemd/journal/socket"
+
+//journalFields renames the fields of the log entries that have a well known name in the journal
+var journalFields = map[string]string{
+	"Job": "JOB",
+	"Run": "RUN_ID",
+}
+
+//JournalAvailable tells if the output of the daemon goes to the journal anyway, systemd sets $JOURNAL_STREAM then
+func JournalAvailable() bool {
+	if os.Getenv("JOURNAL_STREAM") == "" {
+		return false
+	}
+	_, err := os.Stat(JournalSocket)
+	return err == nil
+}
+
+//JournalHook sends the log entries to the journal with their fields as journal fields, so
+//journalctl JOB=backup shows the entries of one job
+type JournalHook struct {
+	//SYSLOG_IDENTIFIER of the entries
+	Identifier string
+
+	mutex sync.Mutex
+	conn  *net.UnixConn
+}
+
+//NewJournalHook connects to the journal
+func NewJournalHook(identifier string) (*JournalHook, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JournalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournalHook{Identifier: identifier, conn: conn}, nil
+}
+
+//Levels are all levels, the level filtering is done by logrus
+func (hook *JournalHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+//Fire sends one entry
+func (hook *JournalHook) Fire(entry *log.Entry) error {
+	var msg bytes.Buffer
+	writeField(&msg, "MESSAGE", entry.Message)
+	writeField(&msg, "PRIORITY", fmt.Sprint(priority(entry.Level)))
+	writeField(&msg, "SYSLOG_IDENTIFIER", hook.Identifier)
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name, ok := journalFields[key]
+		if !ok {
+			name = fieldName(key)
+		}
+		if name != "" {
+			writeField(&msg, name, fmt.Sprint(entry.Data[key]))
+		}
+	}
+	hook.mutex.Lock()
+	defer hook.mutex.Unlock()
+	_, err := hook.conn.Write(msg.Bytes())
+	return err
+}
+
+//priority maps the levels of logrus to the syslog priorities the journal uses
+func priority(level log.Level) int {
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return 2
+	case log.ErrorLevel:
+		return 3
+	case log.WarnLevel:
+		return 4
+	case log.InfoLevel:
+		return 6
+	}
+	return 7
+}
+
+//fieldName turns a field of logrus into a valid journal field: upper case letters, digits and underscores, not starting
+//with an underscore or a digit. Empty if nothing is left
+func fieldName(key string) string {
+	var name strings.Builder
+	for _, r := range strings.ToUpper(key) {
+		switch {
+		case r >= 'A' && r <= 'Z', r == '_':
+			name.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if name.Len() > 0 {
+				name.WriteRune(r)
+			}
+		default:
+			name.WriteRune('_')
+		}
+	}
+	return strings.TrimLeft(name.String(), "_")
+}
+
+//writeField writes one field in the native protocol. Values with line breaks are written with their length
+func writeField(msg *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		msg.WriteString(name + "=" + value + "\n")
+		return
+	}
+	msg.WriteString(name + "\n")
+	binary.Write(msg, binary.LittleEndian, uint64(len(value)))
+	msg.WriteString(value + "\n")
+}
@@ -0,0 +1,60 @@
+//Package systemd talks to systemd without linking libsystemd: readiness, status and watchdog notifications
+//over $NOTIFY_SOCKET and structured logging to the journal
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//states sent with Notify
+const (
+	Ready     = "READY=1"
+	Stopping  = "STOPPING=1"
+	Reloading = "RELOADING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+//Status is the state that sets the status line shown by systemctl status
+func Status(text string) string {
+	//the state is line based
+	return "STATUS=" + strings.Replace(text, "\n", " ", -1)
+}
+
+//Notify sends the states to the service manager. It does nothing (and returns false) if the daemon was not started
+//by systemd with Type=notify
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	//sockets in the abstract namespace start with @
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//WatchdogInterval returns how often WATCHDOG=1 has to be sent, half of WatchdogSec of the unit, so one late
+//ping does not get the daemon killed. Zero if the watchdog is not enabled for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,102 @@
+package systemd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//listen receives datagrams on a socket in a temporary directory
+func listen(t *testing.T) (*net.UnixConn, string, func()) {
+	dir, err := ioutil.TempDir("", "rc-systemd")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	socket := path.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err.Error())
+	}
+	return conn, socket, func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func receive(t *testing.T, conn *net.UnixConn) []byte {
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return buf[:n]
+}
+
+func TestNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if notified, err := Notify(Ready); notified || err != nil {
+		t.Error("Notified without a socket")
+	}
+
+	conn, socket, done := listen(t)
+	defer done()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	if notified, err := Notify(Ready, Status("2 jobs\nrunning")); !notified || err != nil {
+		t.Fatalf("Not notified: %v", err)
+	}
+	if msg := string(receive(t, conn)); msg != "READY=1\nSTATUS=2 jobs running" {
+		t.Errorf("Wrong message %q", msg)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	os.Setenv("WATCHDOG_USEC", "60000000")
+	if interval := WatchdogInterval(); interval != 30*time.Second {
+		t.Errorf("Wrong interval %v", interval)
+	}
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if WatchdogInterval() != 0 {
+		t.Error("Watchdog of another process used")
+	}
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	if WatchdogInterval() != 0 {
+		t.Error("Watchdog without WATCHDOG_USEC")
+	}
+}
+
+func TestJournalHook(t *testing.T) {
+	conn, socket, done := listen(t)
+	defer done()
+	JournalSocket = socket
+	hook, err := NewJournalHook("restic-cronned")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	logger.AddHook(hook)
+	logger.WithFields(log.Fields{"Job": "backup", "Run": 7, "error": "exit status 1", "9x-y": 1}).Warning("two\nlines")
+
+	var expected bytes.Buffer
+	expected.WriteString("MESSAGE\n")
+	binary.Write(&expected, binary.LittleEndian, uint64(len("two\nlines")))
+	expected.WriteString("two\nlines\n")
+	expected.WriteString("PRIORITY=4\nSYSLOG_IDENTIFIER=restic-cronned\nX_Y=1\nJOB=backup\nRUN_ID=7\nERROR=exit status 1\n")
+	if msg := receive(t, conn); !bytes.Equal(msg, expected.Bytes()) {
+		t.Errorf("Wrong entry %q", msg)
+	}
+}